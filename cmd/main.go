@@ -11,7 +11,7 @@ import (
 )
 
 func my() {
-	db, err := mylevel.Open("testdb", &mylevel.Options{CreateIfMissing: true, Comparator: &utils.InternalKeyComparator{}})
+	db, err := mylevel.Open("testdb", &mylevel.Options{CreateIfMissing: true, Comparator: &utils.BytewiseComparator{}})
 	if err != nil {
 		log.Fatal(err)
 	}
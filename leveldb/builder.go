@@ -0,0 +1,75 @@
+package leveldb
+
+import (
+	"github.com/lemonwx/goleveldb/leveldb/env"
+	"github.com/lemonwx/goleveldb/leveldb/table"
+	"github.com/lemonwx/goleveldb/leveldb/utils"
+	"github.com/lemonwx/log"
+)
+
+// BuildTable builds a Table file from the contents of iter.  The file
+// will be named according to meta.number.  On success, the rest of
+// meta will be filled with metadata about the generated table.
+// If no data is present in iter, meta.file_size will be set to
+// zero, and no Table file will be produced.
+func BuildTable(dbname string, options *Options, icmp *utils.InternalKeyComparator, table_cache *TableCache, iter table.Iterator, meta *FileMetaData) error {
+	var err error
+	meta.file_size = 0
+	iter.SeekToFirst()
+
+	fname := TableFileName(dbname, meta.number)
+	if iter.Valid() {
+		var file env.WritableFile
+		file, err = options.Env.NewWritableFile(fname)
+		if err != nil {
+			return err
+		}
+
+		builder := table.NewTableBuilder(options.tableOptions(icmp), file)
+		meta.smallest = &InternalKey{}
+		meta.smallest.DecodeFrom(string(iter.Key()))
+		var key []byte
+		for ; iter.Valid(); iter.Next() {
+			key = iter.Key()
+			builder.Add(key, iter.Value())
+		}
+		meta.largest = &InternalKey{}
+		meta.largest.DecodeFrom(string(key))
+
+		// Finish and check for builder errors
+		err = builder.Finish()
+		if err == nil {
+			meta.file_size = builder.FileSize()
+		}
+
+		// Finish and check for file errors
+		if err == nil {
+			if err = file.Sync(); err != nil {
+				log.Errorf("sync file: %s failed: %v", fname, err)
+			}
+		}
+		if close_err := file.Close(); err == nil && close_err != nil {
+			log.Errorf("close file: %s failed: %v", fname, close_err)
+			err = close_err
+		}
+
+		if err == nil {
+			// Verify that the table is usable
+			it := table_cache.NewIterator(&table.ReadOptions{}, meta.number, meta.file_size)
+			err = it.Error()
+			it.Close()
+		}
+	}
+
+	// Check for input iterator errors
+	if iter_err := iter.Error(); iter_err != nil {
+		err = iter_err
+	}
+
+	if err == nil && meta.file_size > 0 {
+		// Keep it
+	} else {
+		options.Env.DeleteFile(fname)
+	}
+	return err
+}
@@ -0,0 +1,357 @@
+package cache
+
+import (
+	"sync"
+
+	"github.com/lemonwx/goleveldb/leveldb/utils"
+)
+
+// A Cache is an interface that maps keys to values.  It has internal
+// synchronization and may be safely accessed concurrently from
+// multiple goroutines.  It may automatically evict entries to make
+// room for new entries.  Values have a specified charge against the
+// cache capacity.  For example, a cache where the values are variable
+// length strings, may use the length of the string as the charge for
+// the string.
+//
+// A builtin cache implementation with a least-recently-used eviction
+// policy is provided.  Clients may use their own implementations if
+// they want something more sophisticated (like scan-resistance, a
+// custom eviction policy, variable cache sizing, etc.)
+type Cache interface {
+	// Insert a mapping from key->value into the cache and assign it
+	// the specified charge against the total cache capacity.
+	//
+	// Returns a handle that corresponds to the mapping.  The caller
+	// must call Release(handle) when the returned mapping is no
+	// longer needed.
+	//
+	// When the inserted entry is no longer needed, the key and
+	// value will be passed to "deleter", if it is non-nil.
+	Insert(key []byte, value interface{}, charge int, deleter func(key []byte, value interface{})) *Handle
+
+	// If the cache has no mapping for "key", returns nil.
+	//
+	// Else return a handle that corresponds to the mapping.  The caller
+	// must call Release(handle) when the returned mapping is no
+	// longer needed.
+	Lookup(key []byte) *Handle
+
+	// Release a mapping returned by a previous Lookup().
+	// REQUIRES: handle must not have been released yet.
+	// REQUIRES: handle must have been returned by a method on this
+	// instance.
+	Release(handle *Handle)
+
+	// Value returns the value encapsulated in a handle returned by a
+	// successful Lookup().
+	// REQUIRES: handle must not have been released yet.
+	// REQUIRES: handle must have been returned by a method on this
+	// instance.
+	Value(handle *Handle) interface{}
+
+	// If the cache contains entry for key, erase it.  Note that the
+	// underlying entry will be kept around until all existing handles
+	// to it have been released.
+	Erase(key []byte)
+
+	// Return a new numeric id.  May be used by multiple clients who are
+	// sharing the same cache to partition the key space.  Typically the
+	// client will allocate a new id at startup and prepend the id to
+	// its cache keys.
+	NewId() uint64
+
+	// Remove all cache entries that are not actively in use.  Memory-
+	// constrained applications may wish to call this method to reduce
+	// memory usage.
+	Prune()
+
+	// Return an estimate of the combined charges of all elements stored
+	// in the cache.
+	TotalCharge() int
+}
+
+// Handle is an entry of the cache.  Entries are kept in a circular
+// doubly linked list ordered by access time.
+type Handle struct {
+	value    interface{}
+	deleter  func(key []byte, value interface{})
+	next     *Handle
+	prev     *Handle
+	charge   int
+	in_cache bool   // Whether entry is in the cache.
+	refs     uint32 // References, including cache reference, if present.
+	hash     uint32 // Hash of key; used for fast sharding
+	key      []byte
+}
+
+// LRU cache implementation
+//
+// Cache entries have an "in_cache" boolean indicating whether the cache
+// has a reference on the entry.  The only ways that this can become
+// false without the entry being passed to its "deleter" are via
+// Erase() or via Insert() when an element with a duplicate key is
+// inserted.
+//
+// The cache keeps two linked lists of items in the cache.  All items
+// in the cache are in one list or the other, and never both.  Items
+// still referenced by clients but erased from the cache are in
+// neither list.  The lists are:
+//   - in-use:  contains the items currently referenced by clients, in
+//     no particular order.  (This list is used for invariant checking.
+//     If we removed the check, elements that would otherwise be on
+//     this list could be left as disconnected singleton lists.)
+//   - LRU:  contains the items not currently referenced by clients, in
+//     LRU order
+//
+// Elements are moved between these lists by the Ref() and Unref()
+// methods, when they detect an element in the cache acquiring or
+// losing its only external reference.
+type LRUCache struct {
+	// Initialized before use.
+	capacity_ int
+
+	// mutex_ protects the following state.
+	mutex_ sync.Mutex
+	usage_ int
+
+	// Dummy head of LRU list.
+	// lru.prev is newest entry, lru.next is oldest entry.
+	// Entries have refs==1 and in_cache==true.
+	lru_ Handle
+
+	// Dummy head of in-use list.
+	// Entries are in use by clients, and have refs >= 2 and in_cache==true.
+	in_use_ Handle
+
+	table_ map[string]*Handle
+}
+
+func newLRUCache(capacity int) *LRUCache {
+	c := &LRUCache{capacity_: capacity, table_: map[string]*Handle{}}
+	// Make empty circular linked lists.
+	c.lru_.next = &c.lru_
+	c.lru_.prev = &c.lru_
+	c.in_use_.next = &c.in_use_
+	c.in_use_.prev = &c.in_use_
+	return c
+}
+
+func (c *LRUCache) Ref(e *Handle) {
+	if e.refs == 1 && e.in_cache { // If on lru_ list, move to in_use_ list.
+		c.LRU_Remove(e)
+		c.LRU_Append(&c.in_use_, e)
+	}
+	e.refs += 1
+}
+
+func (c *LRUCache) Unref(e *Handle) {
+	if e.refs == 0 {
+		panic("cache: Unref of an unreferenced handle")
+	}
+	e.refs -= 1
+	if e.refs == 0 { // Deallocate.
+		if e.in_cache {
+			panic("cache: deallocating an entry still in the cache")
+		}
+		if e.deleter != nil {
+			e.deleter(e.key, e.value)
+		}
+	} else if e.in_cache && e.refs == 1 {
+		// No longer in use; move to lru_ list.
+		c.LRU_Remove(e)
+		c.LRU_Append(&c.lru_, e)
+	}
+}
+
+func (c *LRUCache) LRU_Remove(e *Handle) {
+	e.next.prev = e.prev
+	e.prev.next = e.next
+}
+
+func (c *LRUCache) LRU_Append(list *Handle, e *Handle) {
+	// Make "e" newest entry by inserting just before *list
+	e.next = list
+	e.prev = list.prev
+	e.prev.next = e
+	e.next.prev = e
+}
+
+func (c *LRUCache) Lookup(key []byte) *Handle {
+	c.mutex_.Lock()
+	defer c.mutex_.Unlock()
+	e := c.table_[string(key)]
+	if e != nil {
+		c.Ref(e)
+	}
+	return e
+}
+
+func (c *LRUCache) Release(handle *Handle) {
+	c.mutex_.Lock()
+	defer c.mutex_.Unlock()
+	c.Unref(handle)
+}
+
+func (c *LRUCache) Insert(key []byte, hash uint32, value interface{}, charge int, deleter func(key []byte, value interface{})) *Handle {
+	c.mutex_.Lock()
+	defer c.mutex_.Unlock()
+
+	e := &Handle{
+		value:    value,
+		deleter:  deleter,
+		charge:   charge,
+		hash:     hash,
+		in_cache: false,
+		refs:     1, // for the returned handle.
+		key:      append([]byte{}, key...),
+	}
+
+	if c.capacity_ > 0 {
+		e.refs += 1 // for the cache's reference.
+		e.in_cache = true
+		c.LRU_Append(&c.in_use_, e)
+		c.usage_ += charge
+		old := c.table_[string(e.key)]
+		c.table_[string(e.key)] = e
+		c.FinishErase(old)
+	} // else don't cache.  (capacity_==0 is supported and turns off caching.)
+
+	for c.usage_ > c.capacity_ && c.lru_.next != &c.lru_ {
+		old := c.lru_.next
+		if old.refs != 1 {
+			panic("cache: entry on the lru list is in use")
+		}
+		c.FinishErase(c.Remove(old.key))
+	}
+
+	return e
+}
+
+// Remove drops the entry of key from table_ and returns it, or nil if
+// there is none.
+// REQUIRES: mutex_ held
+func (c *LRUCache) Remove(key []byte) *Handle {
+	e := c.table_[string(key)]
+	if e != nil {
+		delete(c.table_, string(key))
+	}
+	return e
+}
+
+// FinishErase finishes removing *e from the cache; it has already been
+// removed from the hash table.  Returns whether e != nil.
+// REQUIRES: mutex_ held
+func (c *LRUCache) FinishErase(e *Handle) bool {
+	if e != nil {
+		if !e.in_cache {
+			panic("cache: erasing an entry that is not in the cache")
+		}
+		c.LRU_Remove(e)
+		e.in_cache = false
+		c.usage_ -= e.charge
+		c.Unref(e)
+	}
+	return e != nil
+}
+
+func (c *LRUCache) Erase(key []byte) {
+	c.mutex_.Lock()
+	defer c.mutex_.Unlock()
+	c.FinishErase(c.Remove(key))
+}
+
+func (c *LRUCache) Prune() {
+	c.mutex_.Lock()
+	defer c.mutex_.Unlock()
+	for c.lru_.next != &c.lru_ {
+		e := c.lru_.next
+		if e.refs != 1 {
+			panic("cache: entry on the lru list is in use")
+		}
+		c.FinishErase(c.Remove(e.key))
+	}
+}
+
+func (c *LRUCache) TotalCharge() int {
+	c.mutex_.Lock()
+	defer c.mutex_.Unlock()
+	return c.usage_
+}
+
+const (
+	kNumShardBits = 4
+	kNumShards    = 1 << kNumShardBits
+)
+
+// ShardedLRUCache spreads the entries over kNumShards LRUCaches by the
+// hash of their keys, so that lookups of different keys seldom contend
+// on the same mutex.
+type ShardedLRUCache struct {
+	shard_    [kNumShards]*LRUCache
+	id_mutex_ sync.Mutex
+	last_id_  uint64
+}
+
+// NewLRUCache creates a new cache with a fixed size capacity.  This
+// implementation of Cache uses a least-recently-used eviction policy.
+func NewLRUCache(capacity int) Cache {
+	c := &ShardedLRUCache{}
+	per_shard := (capacity + (kNumShards - 1)) / kNumShards
+	for s := 0; s < kNumShards; s += 1 {
+		c.shard_[s] = newLRUCache(per_shard)
+	}
+	return c
+}
+
+func HashSlice(s []byte) uint32 {
+	return utils.Hash(s, 0)
+}
+
+func Shard(hash uint32) uint32 {
+	return hash >> (32 - kNumShardBits)
+}
+
+func (c *ShardedLRUCache) Insert(key []byte, value interface{}, charge int, deleter func(key []byte, value interface{})) *Handle {
+	hash := HashSlice(key)
+	return c.shard_[Shard(hash)].Insert(key, hash, value, charge, deleter)
+}
+
+func (c *ShardedLRUCache) Lookup(key []byte) *Handle {
+	hash := HashSlice(key)
+	return c.shard_[Shard(hash)].Lookup(key)
+}
+
+func (c *ShardedLRUCache) Release(handle *Handle) {
+	c.shard_[Shard(handle.hash)].Release(handle)
+}
+
+func (c *ShardedLRUCache) Erase(key []byte) {
+	hash := HashSlice(key)
+	c.shard_[Shard(hash)].Erase(key)
+}
+
+func (c *ShardedLRUCache) Value(handle *Handle) interface{} {
+	return handle.value
+}
+
+func (c *ShardedLRUCache) NewId() uint64 {
+	c.id_mutex_.Lock()
+	defer c.id_mutex_.Unlock()
+	c.last_id_ += 1
+	return c.last_id_
+}
+
+func (c *ShardedLRUCache) Prune() {
+	for s := 0; s < kNumShards; s += 1 {
+		c.shard_[s].Prune()
+	}
+}
+
+func (c *ShardedLRUCache) TotalCharge() int {
+	total := 0
+	for s := 0; s < kNumShards; s += 1 {
+		total += c.shard_[s].TotalCharge()
+	}
+	return total
+}
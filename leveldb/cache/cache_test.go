@@ -0,0 +1,336 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/lemonwx/goleveldb/leveldb/utils"
+)
+
+// Conversions between numeric keys/values and the types expected by
+// Cache.
+func encodeKey(k int) []byte {
+	var buf []byte
+	utils.PutFixed32(&buf, uint32(k))
+	return buf
+}
+
+func decodeKey(k []byte) int {
+	return int(utils.DecodeFixed32(k))
+}
+
+const kCacheSize = 1000
+
+// cacheTest wraps a cache with int keys and values, it records the
+// entries passed to the deleter.
+type cacheTest struct {
+	cache_          Cache
+	deleted_keys_   []int
+	deleted_values_ []int
+}
+
+func newCacheTest(capacity int) *cacheTest {
+	return &cacheTest{cache_: NewLRUCache(capacity)}
+}
+
+func (ct *cacheTest) deleter(key []byte, v interface{}) {
+	ct.deleted_keys_ = append(ct.deleted_keys_, decodeKey(key))
+	ct.deleted_values_ = append(ct.deleted_values_, v.(int))
+}
+
+func (ct *cacheTest) Lookup(key int) int {
+	handle := ct.cache_.Lookup(encodeKey(key))
+	if handle == nil {
+		return -1
+	}
+	r := ct.cache_.Value(handle).(int)
+	ct.cache_.Release(handle)
+	return r
+}
+
+func (ct *cacheTest) Insert(key, value, charge int) {
+	ct.cache_.Release(ct.InsertAndReturnHandle(key, value, charge))
+}
+
+func (ct *cacheTest) InsertAndReturnHandle(key, value, charge int) *Handle {
+	return ct.cache_.Insert(encodeKey(key), value, charge, ct.deleter)
+}
+
+func (ct *cacheTest) Erase(key int) {
+	ct.cache_.Erase(encodeKey(key))
+}
+
+func (ct *cacheTest) checkDeleted(t *testing.T, keys, values []int) {
+	t.Helper()
+	if len(ct.deleted_keys_) != len(keys) {
+		t.Fatalf("deleted keys %v, want %v", ct.deleted_keys_, keys)
+	}
+	for i := range keys {
+		if ct.deleted_keys_[i] != keys[i] || ct.deleted_values_[i] != values[i] {
+			t.Fatalf("deleted %v => %v, want %v => %v", ct.deleted_keys_, ct.deleted_values_, keys, values)
+		}
+	}
+}
+
+func TestCacheHitAndMiss(t *testing.T) {
+	ct := newCacheTest(kCacheSize)
+	lookups := func(want map[int]int) {
+		t.Helper()
+		for k, v := range want {
+			if got := ct.Lookup(k); got != v {
+				t.Errorf("Lookup(%d) = %d, want %d", k, got, v)
+			}
+		}
+	}
+	lookups(map[int]int{100: -1})
+
+	ct.Insert(100, 101, 1)
+	lookups(map[int]int{100: 101, 200: -1, 300: -1})
+
+	ct.Insert(200, 201, 1)
+	lookups(map[int]int{100: 101, 200: 201, 300: -1})
+
+	ct.Insert(100, 102, 1)
+	lookups(map[int]int{100: 102, 200: 201, 300: -1})
+
+	ct.checkDeleted(t, []int{100}, []int{101})
+}
+
+func TestCacheErase(t *testing.T) {
+	ct := newCacheTest(kCacheSize)
+	ct.Erase(200)
+	ct.checkDeleted(t, nil, nil)
+
+	ct.Insert(100, 101, 1)
+	ct.Insert(200, 201, 1)
+	ct.Erase(100)
+	if ct.Lookup(100) != -1 || ct.Lookup(200) != 201 {
+		t.Errorf("Lookup after Erase(100) = %d, %d", ct.Lookup(100), ct.Lookup(200))
+	}
+	ct.checkDeleted(t, []int{100}, []int{101})
+
+	ct.Erase(100)
+	if ct.Lookup(100) != -1 || ct.Lookup(200) != 201 {
+		t.Errorf("Lookup after a second Erase(100) = %d, %d", ct.Lookup(100), ct.Lookup(200))
+	}
+	ct.checkDeleted(t, []int{100}, []int{101})
+}
+
+func TestCacheEntriesArePinned(t *testing.T) {
+	ct := newCacheTest(kCacheSize)
+	ct.Insert(100, 101, 1)
+	h1 := ct.cache_.Lookup(encodeKey(100))
+	if v := ct.cache_.Value(h1).(int); v != 101 {
+		t.Fatalf("Value(h1) = %d, want 101", v)
+	}
+
+	ct.Insert(100, 102, 1)
+	h2 := ct.cache_.Lookup(encodeKey(100))
+	if v := ct.cache_.Value(h2).(int); v != 102 {
+		t.Fatalf("Value(h2) = %d, want 102", v)
+	}
+	ct.checkDeleted(t, nil, nil)
+
+	ct.cache_.Release(h1)
+	ct.checkDeleted(t, []int{100}, []int{101})
+
+	ct.Erase(100)
+	if got := ct.Lookup(100); got != -1 {
+		t.Errorf("Lookup(100) = %d after Erase", got)
+	}
+	ct.checkDeleted(t, []int{100}, []int{101})
+
+	ct.cache_.Release(h2)
+	ct.checkDeleted(t, []int{100, 100}, []int{101, 102})
+}
+
+func TestCacheEvictionPolicy(t *testing.T) {
+	ct := newCacheTest(kCacheSize)
+	ct.Insert(100, 101, 1)
+	ct.Insert(200, 201, 1)
+	ct.Insert(300, 301, 1)
+	h := ct.cache_.Lookup(encodeKey(300))
+
+	// Frequently used entry must be kept around,
+	// as must things that are still in use.
+	for i := 0; i < kCacheSize+100; i += 1 {
+		ct.Insert(1000+i, 2000+i, 1)
+		if got := ct.Lookup(1000 + i); got != 2000+i {
+			t.Fatalf("Lookup(%d) = %d, want %d", 1000+i, got, 2000+i)
+		}
+		if got := ct.Lookup(100); got != 101 {
+			t.Fatalf("Lookup(100) = %d, want 101", got)
+		}
+	}
+	if got := ct.Lookup(100); got != 101 {
+		t.Errorf("Lookup(100) = %d, want 101", got)
+	}
+	if got := ct.Lookup(200); got != -1 {
+		t.Errorf("Lookup(200) = %d, want it evicted", got)
+	}
+	if got := ct.Lookup(300); got != 301 {
+		t.Errorf("Lookup(300) = %d, want 301", got)
+	}
+	ct.cache_.Release(h)
+}
+
+func TestCacheLRUOrder(t *testing.T) {
+	// A single shard, so that the eviction order is exact.
+	c := newLRUCache(3)
+	var evicted []string
+	deleter := func(key []byte, value interface{}) { evicted = append(evicted, string(key)) }
+	insert := func(key string) {
+		c.Release(c.Insert([]byte(key), HashSlice([]byte(key)), key, 1, deleter))
+	}
+	touch := func(key string) {
+		if h := c.Lookup([]byte(key)); h != nil {
+			c.Release(h)
+		}
+	}
+	insert("a")
+	insert("b")
+	insert("c")
+	touch("a")
+	insert("d") // evicts b, the least recently used
+	touch("c")
+	insert("e") // evicts a
+	pinned := c.Lookup([]byte("d"))
+	insert("f") // evicts c, d is in use
+	insert("g") // evicts e
+	if got := strings.Join(evicted, ""); got != "bace" {
+		t.Errorf("evicted %q, want %q", got, "bace")
+	}
+	c.Release(pinned)
+	if c.Lookup([]byte("d")) == nil {
+		t.Error("released entry was dropped while the cache was full")
+	}
+}
+
+func TestCacheUseExceedsCacheSize(t *testing.T) {
+	ct := newCacheTest(kCacheSize)
+	// Overfill the cache, keeping handles on all inserted entries.
+	var h []*Handle
+	for i := 0; i < kCacheSize+100; i += 1 {
+		h = append(h, ct.InsertAndReturnHandle(1000+i, 2000+i, 1))
+	}
+
+	// Check that all the entries can be found in the cache.
+	for i := range h {
+		if got := ct.Lookup(1000 + i); got != 2000+i {
+			t.Errorf("Lookup(%d) = %d, want %d", 1000+i, got, 2000+i)
+		}
+	}
+	if got := ct.cache_.TotalCharge(); got != kCacheSize+100 {
+		t.Errorf("TotalCharge() = %d with every entry pinned, want %d", got, kCacheSize+100)
+	}
+
+	for _, handle := range h {
+		ct.cache_.Release(handle)
+	}
+}
+
+func TestCacheHeavyEntries(t *testing.T) {
+	ct := newCacheTest(kCacheSize)
+	// Add a bunch of light and heavy entries and then count the combined
+	// size of items still in the cache, which must be approximately the
+	// same as the total capacity.
+	const kLight = 1
+	const kHeavy = 10
+	added, index := 0, 0
+	for added < 2*kCacheSize {
+		weight := kHeavy
+		if index&1 != 0 {
+			weight = kLight
+		}
+		ct.Insert(index, 1000+index, weight)
+		added += weight
+		index += 1
+	}
+
+	cached_weight := 0
+	for i := 0; i < index; i += 1 {
+		weight := kHeavy
+		if i&1 != 0 {
+			weight = kLight
+		}
+		if r := ct.Lookup(i); r >= 0 {
+			cached_weight += weight
+			if r != 1000+i {
+				t.Errorf("Lookup(%d) = %d, want %d", i, r, 1000+i)
+			}
+		}
+	}
+	if cached_weight > kCacheSize+kCacheSize/10 {
+		t.Errorf("cached weight %d, capacity %d", cached_weight, kCacheSize)
+	}
+	if got := ct.cache_.TotalCharge(); got != cached_weight {
+		t.Errorf("TotalCharge() = %d, want %d", got, cached_weight)
+	}
+}
+
+func TestCacheTotalCharge(t *testing.T) {
+	ct := newCacheTest(kCacheSize)
+	ct.Insert(1, 100, 10)
+	ct.Insert(2, 200, 20)
+	ct.Insert(3, 300, 30)
+	if got := ct.cache_.TotalCharge(); got != 60 {
+		t.Fatalf("TotalCharge() = %d, want 60", got)
+	}
+
+	// Replacing an entry charges the new one instead.
+	ct.Insert(1, 101, 5)
+	if got := ct.cache_.TotalCharge(); got != 55 {
+		t.Errorf("TotalCharge() = %d after replacing, want 55", got)
+	}
+
+	// An erased entry stops counting at once, even while it is pinned.
+	h := ct.cache_.Lookup(encodeKey(2))
+	ct.Erase(2)
+	if got := ct.cache_.TotalCharge(); got != 35 {
+		t.Errorf("TotalCharge() = %d after Erase, want 35", got)
+	}
+	ct.cache_.Release(h)
+	if got := ct.cache_.TotalCharge(); got != 35 {
+		t.Errorf("TotalCharge() = %d after releasing an erased entry, want 35", got)
+	}
+
+	ct.cache_.Prune()
+	if got := ct.cache_.TotalCharge(); got != 0 {
+		t.Errorf("TotalCharge() = %d after Prune, want 0", got)
+	}
+}
+
+func TestCacheNewId(t *testing.T) {
+	c := NewLRUCache(kCacheSize)
+	a := c.NewId()
+	b := c.NewId()
+	if a == b {
+		t.Errorf("NewId() returned %d twice", a)
+	}
+}
+
+func TestCachePrune(t *testing.T) {
+	ct := newCacheTest(kCacheSize)
+	ct.Insert(1, 100, 1)
+	ct.Insert(2, 200, 1)
+
+	handle := ct.cache_.Lookup(encodeKey(1))
+	ct.cache_.Prune()
+	ct.cache_.Release(handle)
+
+	if got := ct.Lookup(1); got != 100 {
+		t.Errorf("Lookup(1) = %d, want the pinned entry to survive Prune", got)
+	}
+	if got := ct.Lookup(2); got != -1 {
+		t.Errorf("Lookup(2) = %d, want it pruned", got)
+	}
+}
+
+func TestCacheZeroSize(t *testing.T) {
+	ct := newCacheTest(0)
+	ct.Insert(1, 100, 1)
+	if got := ct.Lookup(1); got != -1 {
+		t.Errorf("Lookup(1) = %d in a zero size cache", got)
+	}
+	ct.checkDeleted(t, []int{1}, []int{100})
+}
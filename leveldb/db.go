@@ -11,36 +11,52 @@ type DB struct {
 func Open(name string, opt *Options) (*DBImpl, error) {
 	dbimpl := NewDBImpl(name, opt)
 	dbimpl.lock.Lock()
-	defer dbimpl.lock.Unlock()
 	edit := NewVersionEdit()
+	// Recover handles create_if_missing
 	saveManifest, err := dbimpl.Recover(edit)
-	if err != nil {
-		return nil, err
-	}
-	// todo: chk dbImpl.mem_ != nil
-	new_log_number := dbimpl.versions.NewFileNumber()
-	logFile, err := env.NewWritableFile(LogFileName(dbimpl.dbName, new_log_number))
-	if err != nil {
-		return nil, err
+	if err == nil && dbimpl.mem_ == nil {
+		// Create new log and a corresponding memtable.
+		new_log_number := dbimpl.versions.NewFileNumber()
+		var logFile env.WritableFile
+		logFile, err = dbimpl.env_.NewWritableFile(LogFileName(dbimpl.dbName, new_log_number))
+		if err == nil {
+			edit.SetLogNumber(new_log_number)
+			dbimpl.logfile_ = logFile
+			dbimpl.logfile_number_ = new_log_number
+			dbimpl.log_ = NewLogWriter(dbimpl.logfile_)
+			dbimpl.mem_ = NewMemTable(dbimpl.internal_comparator_)
+			dbimpl.mem_.Ref()
+		}
 	}
-	edit.SetLogNumber(new_log_number)
-	dbimpl.logfile_ = logFile
-	dbimpl.logfile_number_ = new_log_number
-	dbimpl.log_ = log.NewDefaultLogger(dbimpl.logfile_, log.DEBUG)
-	dbimpl.mem_ = NewMemTable()
-	dbimpl.mem_.Ref()
-	if saveManifest {
+	if err == nil && saveManifest {
 		edit.prev_log_number_ = 0
 		edit.log_number_ = dbimpl.logfile_number_
 		log.Debug(dbimpl.logfile_number_)
-		dbimpl.versions.LogAndApply(edit, dbimpl.lock)
+		err = dbimpl.versions.LogAndApply(edit, &dbimpl.lock)
+	}
+	if err == nil {
+		dbimpl.DeleteObsoleteFiles()
+		dbimpl.MaybeScheduleCompaction()
+	}
+	dbimpl.lock.Unlock()
+	if err != nil {
+		// Release the lock and the files opened so far, so that the DB
+		// can be opened again.
+		dbimpl.Close()
+		return nil, err
 	}
-	dbimpl.DeleteObsoleteFiles()
-	dbimpl.MaybeScheduleCompaction()
 	return dbimpl, nil
 }
 
 type DDB interface {
-	Put(key, value []byte) error
-	Get(key []byte) ([]byte, error)
+	Put(options *WriteOptions, key, value []byte) error
+	Delete(options *WriteOptions, key []byte) error
+	Write(options *WriteOptions, updates *WriteBatch) error
+	Get(options *ReadOptions, key []byte) ([]byte, error)
+	CompactRange(begin, end []byte) error
+	NewIterator(options *ReadOptions) Iterator
+	PrefixIterator(options *ReadOptions, prefix []byte) Iterator
+	GetSnapshot() *Snapshot
+	ReleaseSnapshot(snapshot *Snapshot)
+	Close() error
 }
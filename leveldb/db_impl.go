@@ -3,13 +3,14 @@ package leveldb
 import (
 	"errors"
 	"fmt"
-	"os"
 	"sort"
 	"sync"
 	"sync/atomic"
-	"unsafe"
 
+	"github.com/lemonwx/goleveldb/leveldb/cache"
 	"github.com/lemonwx/goleveldb/leveldb/env"
+	"github.com/lemonwx/goleveldb/leveldb/table"
+	"github.com/lemonwx/goleveldb/leveldb/utils"
 	"github.com/lemonwx/log"
 )
 
@@ -22,16 +23,32 @@ type ManualCompaction struct {
 }
 
 type DBImpl struct {
-	lock            sync.Mutex
-	dbName          string
-	versions        *VersionSet
-	opt             *Options
-	logfile_        *env.WritableFile
-	logfile_number_ uint64
-	log_            log.Logger
-	mem_            *MemTable
-	imm_            *MemTable
-	shutting_down_  *unsafe.Pointer
+	lock                 sync.Mutex
+	dbName               string
+	versions             *VersionSet
+	opt                  *Options
+	env_                 env.Env
+	internal_comparator_ *utils.InternalKeyComparator
+	table_cache_         *TableCache // table_cache_ provides its own synchronization
+	logfile_             env.WritableFile
+	logfile_number_      uint64
+	log_                 *LogWriter
+	mem_                 *MemTable
+	imm_                 *MemTable
+	has_imm_             int32 // So bg goroutine can detect non-nil imm_
+	shutting_down_       int32 // Set when Close begins, read by the bg goroutine
+	db_lock_             env.FileLock
+	info_log_file_       env.WritableFile // Set if the DB opened its own info log
+
+	// Set of table files to protect from deletion because they are
+	// part of ongoing compactions.
+	pending_outputs_ map[uint64]struct{}
+
+	// Queue of writers.
+	writers_   []*Writer
+	tmp_batch_ *WriteBatch
+
+	snapshots_ *SnapshotList
 
 	manual_compaction_ *ManualCompaction
 
@@ -41,29 +58,152 @@ type DBImpl struct {
 }
 
 func NewDBImpl(name string, opt *Options) *DBImpl {
+	// The defaults are filled in a copy: the info log and the block cache
+	// created here belong to this DB, not to the caller's Options.
+	sanitized := *opt
 	dbImpl := &DBImpl{
-		opt:      opt,
-		dbName:   name,
-		versions: NewVersionSet(name, opt),
+		opt:              &sanitized,
+		dbName:           name,
+		tmp_batch_:       NewWriteBatch(),
+		snapshots_:       NewSnapshotList(),
+		pending_outputs_: map[uint64]struct{}{},
 	}
-	dbImpl.SanitizeOptions() // init dbimpl.opt
+	dbImpl.background_work_finished_signal_ = sync.NewCond(&dbImpl.lock)
+	dbImpl.SanitizeOptions()
+	dbImpl.env_ = dbImpl.opt.Env
+	dbImpl.internal_comparator_ = utils.NewInternalKeyComparator(dbImpl.opt.Comparator)
+	dbImpl.table_cache_ = NewTableCache(name, dbImpl.opt, dbImpl.internal_comparator_, TableCacheSize(dbImpl.opt))
+	dbImpl.versions = NewVersionSet(name, dbImpl.opt, dbImpl.table_cache_)
 	return dbImpl
 }
 
+// Close waits for the background compaction in progress, then closes
+// the log, the MANIFEST, the info log and the cached tables and
+// releases the lock on the DB.  Iterators should be closed before
+// the DB, the DB must not be used after Close.
+func (db *DBImpl) Close() error {
+	// Wait for background work to finish.
+	db.lock.Lock()
+	if atomic.LoadInt32(&db.shutting_down_) != 0 {
+		db.lock.Unlock()
+		return nil
+	}
+	atomic.StoreInt32(&db.shutting_down_, 1)
+	for db.background_compaction_scheduled_ {
+		db.background_work_finished_signal_.Wait()
+	}
+	db.lock.Unlock()
+
+	var err error
+	keep := func(e error) {
+		if err == nil {
+			err = e
+		}
+	}
+	keep(db.versions.Close())
+	if db.mem_ != nil {
+		db.mem_.Unref()
+		db.mem_ = nil
+	}
+	if db.imm_ != nil {
+		db.imm_.Unref()
+		db.imm_ = nil
+	}
+	db.log_ = nil
+	if db.logfile_ != nil {
+		keep(db.logfile_.Close())
+		db.logfile_ = nil
+	}
+	db.table_cache_.Close()
+
+	if db.info_log_file_ != nil {
+		keep(db.info_log_file_.Close())
+		db.info_log_file_ = nil
+	}
+
+	// Unlock last: another process may open the DB once the lock is
+	// released, all the files must be closed by then.
+	if db.db_lock_ != nil {
+		keep(db.env_.UnlockFile(db.db_lock_))
+		db.db_lock_ = nil
+	}
+	return err
+}
+
+// Number of open files that are not tables: the log, the manifest,
+// the info log, the lock file...
+const kNumNonTableCacheFiles = 10
+
+// TableCacheSize returns the number of tables the DB keeps open.
+func TableCacheSize(sanitized_options *Options) int {
+	// Reserve ten files or so for other uses and give the rest to TableCache.
+	return sanitized_options.MaxOpenFiles - kNumNonTableCacheFiles
+}
+
+// ClipToRange returns def for an unset (zero) value, else v clipped
+// to [minvalue, maxvalue].
+func ClipToRange(v, minvalue, maxvalue, def int) int {
+	if v == 0 {
+		return def
+	}
+	if v > maxvalue {
+		return maxvalue
+	}
+	if v < minvalue {
+		return minvalue
+	}
+	return v
+}
+
+// SanitizeOptions fills in the defaults of db.opt, a copy of the
+// options the DB was opened with, and clips the sizes to sane ranges.
 func (db *DBImpl) SanitizeOptions() {
+	if db.opt.Comparator == nil {
+		db.opt.Comparator = &utils.BytewiseComparator{}
+	}
+	db.opt.MaxOpenFiles = ClipToRange(db.opt.MaxOpenFiles, 64+kNumNonTableCacheFiles, 50000, 1000)
+	db.opt.WriteBufferSize = ClipToRange(db.opt.WriteBufferSize, 64<<10, 1<<30, 4<<20)
+	db.opt.MaxFileSize = ClipToRange(db.opt.MaxFileSize, 1<<20, 1<<30, 2<<20)
+	db.opt.BlockSize = ClipToRange(db.opt.BlockSize, 1<<10, 4<<20, 4<<10)
+	if db.opt.BlockRestartInterval <= 0 {
+		db.opt.BlockRestartInterval = 16
+	}
+	if db.opt.Env == nil {
+		db.opt.Env = env.Default()
+	}
 	if db.opt.info_log == nil {
-		err := os.Mkdir(db.dbName, 0755)
-		if err != nil && err != os.ErrExist {
-			log.Fatal(err)
-		}
-		env.RenameFile(InfoLogFileName(db.dbName), OldInfoLogFileName(db.dbName))
-		f, err := env.NewAppendableFile(InfoLogFileName(db.dbName))
+		// Open a log file in the same directory as the db
+		db.opt.Env.CreateDir(db.dbName) // In case it does not exist
+		db.opt.Env.RenameFile(InfoLogFileName(db.dbName), OldInfoLogFileName(db.dbName))
+		f, err := db.opt.Env.NewAppendableFile(InfoLogFileName(db.dbName))
 		if err != nil {
 			log.Fatal(err)
 		}
-		db.opt.info_log = log.NewDefaultLogger(f, log.DEBUG)
+		db.info_log_file_ = f
+		db.opt.info_log = log.NewDefaultLogger(&infoLogWriter{file_: f}, log.DEBUG)
+	}
+	if db.opt.BlockCache == nil {
+		db.opt.BlockCache = cache.NewLRUCache(8 << 20)
+	}
+}
+
+// infoLogWriter writes the messages of the info log to a WritableFile,
+// flushing each of them so that the log survives a crash of the process.
+type infoLogWriter struct {
+	mu_   sync.Mutex
+	file_ env.WritableFile
+}
+
+func (w *infoLogWriter) Write(p []byte) (int, error) {
+	w.mu_.Lock()
+	defer w.mu_.Unlock()
+	if err := w.file_.Append(p); err != nil {
+		return 0, err
 	}
-	// todo: block cache
+	if err := w.file_.Flush(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
 }
 
 func (db *DBImpl) NewDB() error {
@@ -76,22 +216,26 @@ func (db *DBImpl) NewDB() error {
 	manifest := DescriptorFileName(db.dbName, 1)
 	defer func() {
 		if err != nil {
-			env.DeleteFile(manifest)
+			db.env_.DeleteFile(manifest)
 		}
 	}()
-	f, err := env.NewWritableFile(manifest)
+	f, err := db.env_.NewWritableFile(manifest)
 	if err != nil {
 		return err
 	}
 	w := LogWriter{dest_: f}
-	if err := w.AddRecord(ve.Encode()); err != nil {
+	if err = w.AddRecord(ve.Encode()); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		log.Errorf("sync file: %s failed: %v", manifest, err)
 		return err
 	}
-	if err := f.F.Close(); err != nil {
+	if err := f.Close(); err != nil {
 		log.Errorf("close file: %s failed: %v", manifest, err)
 		return err
 	}
-	if err := SetCurrentFile(db.dbName, 1); err != nil {
+	if err := SetCurrentFile(db.env_, db.dbName, 1); err != nil {
 		log.Errorf("set current file: %s failed: %v", db.dbName, err)
 		return err
 	}
@@ -100,20 +244,24 @@ func (db *DBImpl) NewDB() error {
 }
 
 func (db *DBImpl) Recover(edit *VersionEdit) (bool, error) {
-	if err := env.Makedir(db.dbName, os.FileMode(0755)); err != nil {
+	if err := db.env_.CreateDir(db.dbName); err != nil {
 		// Ignore error from CreateDir since the creation of the DB is
 		// committed only when the descriptor is created, and this directory
 		// may already exist from a previous failed creation attempt.
 		log.Errorf("mkdir %s, mode: %v failed: %v", db.dbName, 0755, err)
 	}
-	if err := env.LockFile(LockFileName(db.dbName)); err != nil {
+	lock, err := db.env_.LockFile(LockFileName(db.dbName))
+	if err != nil {
 		return false, err
 	}
-	if !env.FileExists(CurrentFileName(db.dbName)) {
+	db.db_lock_ = lock
+	if !db.env_.FileExists(CurrentFileName(db.dbName)) {
 		if db.opt.CreateIfMissing {
 			if err := db.NewDB(); err != nil {
 				return false, err
 			}
+		} else {
+			return false, fmt.Errorf("%s: does not exist (create_if_missing is false)", db.dbName)
 		}
 	}
 	saveManiFest, err := db.versions.Recover(false)
@@ -121,7 +269,7 @@ func (db *DBImpl) Recover(edit *VersionEdit) (bool, error) {
 		return saveManiFest, err
 	}
 	log.Debugf("save manifest: %v", saveManiFest)
-	childs, err := env.GetChildren(db.dbName)
+	childs, err := db.env_.GetChildren(db.dbName)
 	if err != nil {
 		return saveManiFest, err
 	}
@@ -146,10 +294,11 @@ func (db *DBImpl) Recover(edit *VersionEdit) (bool, error) {
 		log.Error(err)
 		return saveManiFest, err
 	}
+	// Recover in the order in which the logs were generated
 	sort.Sort(Logs(logs))
 	for i, log_num := range logs {
 		last_log := (i == len(logs)-1)
-		err := db.RecoverLogFile(log_num, last_log, saveManiFest, edit, max_seq)
+		err := db.RecoverLogFile(log_num, last_log, &saveManiFest, edit, &max_seq)
 		if err != nil {
 			return saveManiFest, err
 		}
@@ -161,14 +310,205 @@ func (db *DBImpl) Recover(edit *VersionEdit) (bool, error) {
 	return saveManiFest, nil
 }
 
-func (db *DBImpl) RecoverLogFile(logNum uint64, last_log bool, save_manifest bool, edit *VersionEdit, maxSeq SequenceNumber) error {
+// LogReporter logs the records dropped while replaying a log file, the
+// first error is kept in err unless it is nil (i.e. !ParanoidChecks).
+type LogReporter struct {
+	info_log log.Logger
+	fname    string
+	err      *error
+}
+
+func (r *LogReporter) Corruption(size int, err error) {
+	ignoring := ""
+	if r.err == nil {
+		ignoring = "(ignoring error) "
+	}
+	r.info_log.Infof("%s%s: dropping %d bytes; %v", ignoring, r.fname, size, err)
+	if r.err != nil && *r.err == nil {
+		*r.err = err
+	}
+}
+
+// MaybeIgnoreError drops err unless ParanoidChecks is set.
+func (db *DBImpl) MaybeIgnoreError(err error) error {
+	if err == nil || db.opt.ParanoidChecks {
+		return err
+	}
+	db.opt.info_log.Infof("Ignoring error %v", err)
+	return nil
+}
+
+// RecoverLogFile replays the batches of log file logNum into memtables,
+// flushing them to level-0 tables (recorded in edit) whenever they grow
+// past WriteBufferSize.  max_sequence is raised to the last sequence
+// number found in the log.
+func (db *DBImpl) RecoverLogFile(logNum uint64, last_log bool, save_manifest *bool, edit *VersionEdit, max_sequence *SequenceNumber) error {
+	// Open the log file
 	fname := LogFileName(db.dbName, logNum)
-	_, err := env.NewSequentialFile(fname)
+	file, err := db.env_.NewSequentialFile(fname)
 	if err != nil {
-		return err
+		return db.MaybeIgnoreError(err)
 	}
+	defer file.Close()
 
-	return nil
+	// Create the log reader.
+	var status error
+	reporter := &LogReporter{info_log: db.opt.info_log, fname: fname}
+	if db.opt.ParanoidChecks {
+		reporter.err = &status
+	}
+	// We intentionally make LogReader do checksumming even if
+	// ParanoidChecks is false so that corruptions cause entire commits
+	// to be skipped instead of propagating bad information (like overly
+	// large sequence numbers).
+	reader := NewLogReader(file, reporter, true, 0)
+	db.opt.info_log.Infof("Recovering log #%d", logNum)
+
+	// Read all the records and add to a memtable
+	batch := NewWriteBatch()
+	compactions := 0
+	var mem *MemTable
+	for status == nil {
+		record, err := reader.ReadRecord()
+		if err != nil {
+			break
+		}
+		if len(record) < kWriteBatchHeader {
+			reporter.Corruption(len(record), utils.NewCorruption("log record too small"))
+			continue
+		}
+		batch.SetContents(record)
+
+		if mem == nil {
+			mem = NewMemTable(db.internal_comparator_)
+			mem.Ref()
+		}
+		status = db.MaybeIgnoreError(batch.InsertInto(mem))
+		if status != nil {
+			break
+		}
+		last_seq := batch.Sequence() + SequenceNumber(batch.Count()) - 1
+		if last_seq > *max_sequence {
+			*max_sequence = last_seq
+		}
+
+		if mem.ApproximateMemoryUsage() > db.opt.WriteBufferSize {
+			compactions += 1
+			*save_manifest = true
+			status = db.WriteLevel0Table(mem, edit, nil)
+			mem.Unref()
+			mem = nil
+			if status != nil {
+				// Reflect errors immediately so that conditions like full
+				// file-systems cause the DB::Open() to fail.
+				break
+			}
+		}
+	}
+
+	// See if we should keep reusing the last log file.
+	if status == nil && db.opt.ReuseLogs && last_log && compactions == 0 {
+		lfile_size, err := db.env_.GetFileSize(fname)
+		if err == nil {
+			db.logfile_, err = db.env_.NewAppendableFile(fname)
+		}
+		if err == nil {
+			db.opt.info_log.Infof("Reusing old log %s", fname)
+			db.log_ = NewLogWriterWithLength(db.logfile_, lfile_size)
+			db.logfile_number_ = logNum
+			if mem != nil {
+				db.mem_ = mem
+				mem = nil
+			} else {
+				// mem can be nil if lognum exists but was empty.
+				db.mem_ = NewMemTable(db.internal_comparator_)
+				db.mem_.Ref()
+			}
+		}
+	}
+
+	if mem != nil {
+		// mem did not get reused; compact it.
+		if status == nil {
+			*save_manifest = true
+			status = db.WriteLevel0Table(mem, edit, nil)
+		}
+		mem.Unref()
+	}
+
+	return status
+}
+
+// WriteLevel0Table writes the contents of mem to a new table file and
+// records it in edit.
+// REQUIRES: lock is held
+func (db *DBImpl) WriteLevel0Table(mem *MemTable, edit *VersionEdit, base *Version) error {
+	meta := &FileMetaData{}
+	meta.number = db.versions.NewFileNumber()
+	db.pending_outputs_[meta.number] = struct{}{}
+	iter := mem.NewIterator()
+	db.opt.info_log.Infof("Level-0 table #%d: started", meta.number)
+
+	var err error
+	{
+		db.lock.Unlock()
+		err = BuildTable(db.dbName, db.opt, db.internal_comparator_, db.table_cache_, iter, meta)
+		db.lock.Lock()
+	}
+
+	db.opt.info_log.Infof("Level-0 table #%d: %d bytes %v", meta.number, meta.file_size, err)
+	iter.Close()
+	delete(db.pending_outputs_, meta.number)
+
+	// Note that if file_size is zero, the file has been deleted and
+	// should not be added to the manifest.
+	level := 0
+	if err == nil && meta.file_size > 0 {
+		min_user_key := meta.smallest.user_key()
+		max_user_key := meta.largest.user_key()
+		if base != nil {
+			level = base.PickLevelForMemTableOutput(min_user_key, max_user_key)
+		}
+		edit.AddFile(level, meta.number, meta.file_size, meta.smallest, meta.largest)
+	}
+	return err
+}
+
+// CompactMemTable writes imm_ to a level-0 table and installs the
+// new version, dropping imm_ and the logs it was recovered from.
+// REQUIRES: lock is held
+func (db *DBImpl) CompactMemTable() {
+	if db.imm_ == nil {
+		panic("leveldb: CompactMemTable without an immutable memtable")
+	}
+
+	// Save the contents of the memtable as a new Table
+	edit := NewVersionEdit()
+	base := db.versions.current_
+	base.Ref()
+	err := db.WriteLevel0Table(db.imm_, edit, base)
+	base.Unref()
+
+	if err == nil && atomic.LoadInt32(&db.shutting_down_) != 0 {
+		err = errors.New("leveldb: deleting DB during memtable compaction")
+	}
+
+	// Replace immutable memtable with the generated Table
+	if err == nil {
+		edit.SetPrevLogNumber(0)
+		edit.SetLogNumber(db.logfile_number_) // Earlier logs no longer needed
+		err = db.versions.LogAndApply(edit, &db.lock)
+	}
+
+	if err == nil {
+		// Commit to the new state
+		db.imm_.Unref()
+		db.imm_ = nil
+		atomic.StoreInt32(&db.has_imm_, 0)
+		db.DeleteObsoleteFiles()
+	} else {
+		db.RecordBackgroundError(err)
+	}
 }
 
 func (db *DBImpl) DeleteObsoleteFiles() {
@@ -181,8 +521,12 @@ func (db *DBImpl) DeleteObsoleteFiles() {
 	if db.bg_error != nil {
 		return
 	}
+	// Make a set of all of the live files
 	lives := db.versions.AddLiveFiles()
-	childs, err := env.GetChildren(db.dbName)
+	for number := range db.pending_outputs_ {
+		lives[number] = struct{}{}
+	}
+	childs, err := db.env_.GetChildren(db.dbName)
 	if err != nil {
 		// todo: handle listdir err
 	}
@@ -207,10 +551,10 @@ func (db *DBImpl) DeleteObsoleteFiles() {
 		}
 		if !keep {
 			if Type == env.KTableFile {
-				// todo: cache
+				db.table_cache_.Evict(number)
 			}
 			db.opt.info_log.Infof("delete type=%d %s #%d\n", Type, f, number)
-			env.DeleteFile(db.dbName + "/" + f)
+			db.env_.DeleteFile(db.dbName + "/" + f)
 		}
 	}
 }
@@ -218,7 +562,7 @@ func (db *DBImpl) DeleteObsoleteFiles() {
 func (db *DBImpl) MaybeScheduleCompaction() {
 	if db.background_compaction_scheduled_ {
 		// Already scheduled
-	} else if atomic.LoadPointer(db.shutting_down_) != nil {
+	} else if atomic.LoadInt32(&db.shutting_down_) != 0 {
 		// DB is being deleted; no more background compactions
 	} else if db.bg_error != nil {
 		// Already got an error; no more changes
@@ -228,7 +572,7 @@ func (db *DBImpl) MaybeScheduleCompaction() {
 		// No work to be done
 	} else {
 		db.background_compaction_scheduled_ = true
-		env.Schedule(db.BGWork)
+		db.env_.Schedule(db.BGWork)
 	}
 }
 
@@ -239,7 +583,7 @@ func (db *DBImpl) BGWork() {
 func (db *DBImpl) BackgroundCall() {
 	db.lock.Lock()
 	defer db.lock.Unlock()
-	if atomic.LoadPointer(db.shutting_down_) != nil {
+	if atomic.LoadInt32(&db.shutting_down_) != 0 {
 		// No more background work when shutting down.
 	} else if db.bg_error != nil {
 		// No more background work after a background error.
@@ -256,104 +600,826 @@ func (db *DBImpl) BackgroundCall() {
 }
 
 func (db *DBImpl) BackgroundCompaction() {
-	/*
-	   if (db.imm_ != nil) {
-	   db.CompactMemTable();
-	   return;
-	   }
-
-	   Compaction* c;
-	   bool is_manual = (manual_compaction_ != nullptr);
-	   InternalKey manual_end;
-	   if (is_manual) {
-	   ManualCompaction* m = manual_compaction_;
-	   c = versions_->CompactRange(m->level, m->begin, m->end);
-	   m->done = (c == nullptr);
-	   if (c != nullptr) {
-	   manual_end = c->input(0, c->num_input_files(0) - 1)->largest;
-	   }
-	   Log(options_.info_log,
-	   "Manual compaction at level-%d from %s .. %s; will stop at %s\n",
-	   m->level,
-	   (m->begin ? m->begin->DebugString().c_str() : "(begin)"),
-	   (m->end ? m->end->DebugString().c_str() : "(end)"),
-	   (m->done ? "(end)" : manual_end.DebugString().c_str()));
-	   } else {
-	   c = versions_->PickCompaction();
-	   }
-
-	   Status status;
-	   if (c == nullptr) {
-	   // Nothing to do
-	   } else if (!is_manual && c->IsTrivialMove()) {
-	   // Move file to next level
-	   assert(c->num_input_files(0) == 1);
-	   FileMetaData* f = c->input(0, 0);
-	   c->edit()->DeleteFile(c->level(), f->number);
-	   c->edit()->AddFile(c->level() + 1, f->number, f->file_size,
-	   f->smallest, f->largest);
-	   status = versions_->LogAndApply(c->edit(), &mutex_);
-	   if (!status.ok()) {
-	   RecordBackgroundError(status);
-	   }
-	   VersionSet::LevelSummaryStorage tmp;
-	   Log(options_.info_log, "Moved #%lld to level-%d %lld bytes %s: %s\n",
-	   static_cast<unsigned long long>(f->number),
-	   c->level() + 1,
-	   static_cast<unsigned long long>(f->file_size),
-	   status.ToString().c_str(),
-	   versions_->LevelSummary(&tmp));
-	   } else {
-	   CompactionState* compact = new CompactionState(c);
-	   status = DoCompactionWork(compact);
-	   if (!status.ok()) {
-	   RecordBackgroundError(status);
-	   }
-	   CleanupCompaction(compact);
-	   c->ReleaseInputs();
-	   DeleteObsoleteFiles();
-	   }
-	   delete c;
-
-	   if (status.ok()) {
-	   // Done
-	   } else if (shutting_down_.Acquire_Load()) {
-	   // Ignore compaction errors found during shutting down
-	   } else {
-	   Log(options_.info_log,
-	   "Compaction error: %s", status.ToString().c_str());
-	   }
-
-	   if (is_manual) {
-	   ManualCompaction* m = manual_compaction_;
-	   if (!status.ok()) {
-	   m->done = true;
-	   }
-	   if (!m->done) {
-	   // We only compacted part of the requested range.  Update *m
-	   // to the range that is left to be compacted.
-	   m->tmp_storage = manual_end;
-	   m->begin = &m->tmp_storage;
-	   }
-	   manual_compaction_ = nullptr;
-	   }*/
-}
-
-func (db *DBImpl) Put(key, value []byte) error {
-	batch := &WriteBatch{}
-	batch.Put(key, value)
-	return db.Write(batch)
+	if db.imm_ != nil {
+		db.CompactMemTable()
+		return
+	}
+
+	var c *Compaction
+	is_manual := db.manual_compaction_ != nil
+	var manual_end *InternalKey
+	if is_manual {
+		m := db.manual_compaction_
+		c = db.versions.CompactRange(m.level, m.begin, m.end)
+		m.done = c == nil
+		if c != nil {
+			manual_end = c.input(0, c.num_input_files(0)-1).largest
+		}
+		begin, end, stop := "(begin)", "(end)", "(end)"
+		if m.begin != nil {
+			begin = m.begin.DebugString()
+		}
+		if m.end != nil {
+			end = m.end.DebugString()
+		}
+		if !m.done {
+			stop = manual_end.DebugString()
+		}
+		db.opt.info_log.Infof("Manual compaction at level-%d from %s .. %s; will stop at %s\n",
+			m.level, begin, end, stop)
+	} else {
+		c = db.versions.PickCompaction()
+	}
+
+	var err error
+	if c == nil {
+		// Nothing to do
+	} else if !is_manual && c.IsTrivialMove() {
+		// Move file to next level
+		f := c.input(0, 0)
+		c.Edit().DeleteFile(c.level(), f.number)
+		c.Edit().AddFile(c.level()+1, f.number, f.file_size, f.smallest, f.largest)
+		err = db.versions.LogAndApply(c.Edit(), &db.lock)
+		if err != nil {
+			db.RecordBackgroundError(err)
+		}
+		db.opt.info_log.Infof("Moved #%d to level-%d %d bytes %v: %s\n",
+			f.number, c.level()+1, f.file_size, err, db.versions.LevelSummary())
+		c.ReleaseInputs()
+	} else {
+		compact := &CompactionState{compaction: c}
+		err = db.DoCompactionWork(compact)
+		if err != nil {
+			db.RecordBackgroundError(err)
+		}
+		db.CleanupCompaction(compact)
+		c.ReleaseInputs()
+		db.DeleteObsoleteFiles()
+	}
+
+	if err == nil {
+		// Done
+	} else if atomic.LoadInt32(&db.shutting_down_) != 0 {
+		// Ignore compaction errors found during shutting down
+	} else {
+		db.opt.info_log.Infof("Compaction error: %v", err)
+	}
+
+	if is_manual {
+		m := db.manual_compaction_
+		if err != nil {
+			m.done = true
+		}
+		if !m.done {
+			// We only compacted part of the requested range.  Update *m
+			// to the range that is left to be compacted.
+			m.tmp_storage = manual_end
+			m.begin = m.tmp_storage
+		}
+		db.manual_compaction_ = nil
+	}
+}
+
+// CompactRange compacts the underlying storage for the key range
+// [begin,end].  In particular, deleted and overwritten versions are
+// discarded, and the data is rearranged to reduce the cost of
+// operations needed to access the data.  This operation should
+// typically only be invoked by users who understand the underlying
+// implementation.
+//
+// begin==nil is treated as a key before all keys in the database.
+// end==nil is treated as a key after all keys in the database.
+// Therefore the following call will compact the entire database:
+//
+//	db.CompactRange(nil, nil)
+func (db *DBImpl) CompactRange(begin, end []byte) error {
+	var begin_user_key, end_user_key *string
+	if begin != nil {
+		k := string(begin)
+		begin_user_key = &k
+	}
+	if end != nil {
+		k := string(end)
+		end_user_key = &k
+	}
+
+	max_level_with_files := 1
+	{
+		db.lock.Lock()
+		base := db.versions.current_
+		for level := 1; level < levelNum; level += 1 {
+			if base.OverlapInLevel(level, begin_user_key, end_user_key) {
+				max_level_with_files = level
+			}
+		}
+		db.lock.Unlock()
+	}
+	// todo: Skip if memtable does not overlap
+	if err := db.FlushMemTable(); err != nil {
+		return err
+	}
+	for level := 0; level < max_level_with_files; level += 1 {
+		if err := db.CompactLevelRange(level, begin, end); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CompactLevelRange compacts any files in the named level that overlap
+// [begin,end] into level+1, waiting until the manual compaction is done.
+// Returns an invalid argument error if level+1 is not a valid level.
+func (db *DBImpl) CompactLevelRange(level int, begin, end []byte) error {
+	if level < 0 || level+1 >= levelNum {
+		return utils.NewInvalidArgument(fmt.Sprintf("CompactLevelRange level %d out of range [0, %d)", level, levelNum-1))
+	}
+
+	manual := &ManualCompaction{level: level}
+	if begin != nil {
+		manual.begin = NewInternalKey(string(begin), kMaxSequenceNumber, kValueTypeForSeek)
+	}
+	if end != nil {
+		manual.end = NewInternalKey(string(end), 0, ValueType(0))
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	for !manual.done && atomic.LoadInt32(&db.shutting_down_) == 0 && db.bg_error == nil {
+		if db.manual_compaction_ == nil { // Idle
+			db.manual_compaction_ = manual
+			db.MaybeScheduleCompaction()
+		} else { // Running either my compaction or another compaction.
+			db.background_work_finished_signal_.Wait()
+		}
+	}
+	// Finish current background compaction in the case where
+	// background_work_finished_signal_ was signalled due to an error.
+	for db.background_compaction_scheduled_ {
+		db.background_work_finished_signal_.Wait()
+	}
+	if db.manual_compaction_ == manual {
+		// Cancel my manual compaction since we aborted early for some reason.
+		db.manual_compaction_ = nil
+	}
+	return db.bg_error
+}
+
+// FlushMemTable forces the current memtable contents to be compacted
+// into a table file and waits until that is done.
+func (db *DBImpl) FlushMemTable() error {
+	// nil batch means just wait for earlier writes to be done
+	err := db.Write(nil, nil)
+	if err == nil {
+		// Wait until the compaction completes
+		db.lock.Lock()
+		for db.imm_ != nil && db.bg_error == nil {
+			db.background_work_finished_signal_.Wait()
+		}
+		if db.imm_ != nil {
+			err = db.bg_error
+		}
+		db.lock.Unlock()
+	}
+	return err
+}
+
+// CompactionOutput is a table file produced by a compaction.
+type CompactionOutput struct {
+	number            uint64
+	file_size         uint64
+	smallest, largest *InternalKey
+}
+
+// CompactionState holds the state of a running compaction.
+type CompactionState struct {
+	compaction *Compaction
+
+	// Sequence numbers < smallest_snapshot are not significant since we
+	// will never have to service a snapshot below smallest_snapshot.
+	// Therefore if we have seen a sequence number S <= smallest_snapshot,
+	// we can drop all entries for the same key with sequence numbers < S.
+	smallest_snapshot SequenceNumber
+
+	outputs []*CompactionOutput
+
+	// State kept for output being generated
+	outfile env.WritableFile
+	builder *table.TableBuilder
+
+	total_bytes uint64
 }
 
-func (db *DBImpl) Get(key []byte) ([]byte, error) {
-	return nil, nil
+func (cs *CompactionState) current_output() *CompactionOutput {
+	return cs.outputs[len(cs.outputs)-1]
 }
 
-func (db *DBImpl) Write(batch *WriteBatch) error {
+// CleanupCompaction abandons the unfinished output of compact and
+// releases its pending outputs.
+// REQUIRES: lock is held
+func (db *DBImpl) CleanupCompaction(compact *CompactionState) {
+	if compact.builder != nil {
+		// May happen if we get a shutdown call in the middle of compaction
+		compact.builder.Abandon()
+		compact.builder = nil
+	}
+	if compact.outfile != nil {
+		compact.outfile.Close()
+		compact.outfile = nil
+	}
+	for _, out := range compact.outputs {
+		delete(db.pending_outputs_, out.number)
+	}
+}
 
+// OpenCompactionOutputFile starts a new output table of compact.
+// REQUIRES: lock is not held
+func (db *DBImpl) OpenCompactionOutputFile(compact *CompactionState) error {
+	if compact.builder != nil {
+		panic("leveldb: compaction output file already open")
+	}
+	var file_number uint64
+	{
+		db.lock.Lock()
+		file_number = db.versions.NewFileNumber()
+		db.pending_outputs_[file_number] = struct{}{}
+		out := &CompactionOutput{number: file_number, smallest: &InternalKey{}, largest: &InternalKey{}}
+		compact.outputs = append(compact.outputs, out)
+		db.lock.Unlock()
+	}
+
+	// Make the output file
+	fname := TableFileName(db.dbName, file_number)
+	outfile, err := db.env_.NewWritableFile(fname)
+	if err != nil {
+		return err
+	}
+	compact.outfile = outfile
+	compact.builder = table.NewTableBuilder(db.opt.tableOptions(db.internal_comparator_), outfile)
 	return nil
 }
 
+// FinishCompactionOutputFile finishes, syncs and verifies the current
+// output table of compact.
+// REQUIRES: lock is not held
+func (db *DBImpl) FinishCompactionOutputFile(compact *CompactionState, input table.Iterator) error {
+	output_number := compact.current_output().number
+
+	// Check for iterator errors
+	err := input.Error()
+	current_entries := compact.builder.NumEntries()
+	if err == nil {
+		err = compact.builder.Finish()
+	} else {
+		compact.builder.Abandon()
+	}
+	current_bytes := compact.builder.FileSize()
+	compact.current_output().file_size = current_bytes
+	compact.total_bytes += current_bytes
+	compact.builder = nil
+
+	// Finish and check for file errors
+	if err == nil {
+		err = compact.outfile.Sync()
+	}
+	if close_err := compact.outfile.Close(); err == nil {
+		err = close_err
+	}
+	compact.outfile = nil
+
+	if err == nil && current_entries > 0 {
+		// Verify that the table is usable
+		iter := db.table_cache_.NewIterator(&table.ReadOptions{}, output_number, current_bytes)
+		err = iter.Error()
+		iter.Close()
+		if err == nil {
+			db.opt.info_log.Infof("Generated table #%d@%d: %d keys, %d bytes",
+				output_number, compact.compaction.level(), current_entries, current_bytes)
+		}
+	}
+	return err
+}
+
+// InstallCompactionResults replaces the compaction inputs with its
+// outputs in a new version.
+// REQUIRES: lock is held
+func (db *DBImpl) InstallCompactionResults(compact *CompactionState) error {
+	c := compact.compaction
+	db.opt.info_log.Infof("Compacted %d@%d + %d@%d files => %d bytes",
+		c.num_input_files(0), c.level(), c.num_input_files(1), c.level()+1, compact.total_bytes)
+
+	// Add compaction outputs
+	c.AddInputDeletions(c.Edit())
+	level := c.level()
+	for _, out := range compact.outputs {
+		c.Edit().AddFile(level+1, out.number, out.file_size, out.smallest, out.largest)
+	}
+	return db.versions.LogAndApply(c.Edit(), &db.lock)
+}
+
+// DoCompactionWork merges the inputs of compact into new level+1
+// tables, dropping overwritten values and obsolete deletion markers.
+// REQUIRES: lock is held
+func (db *DBImpl) DoCompactionWork(compact *CompactionState) error {
+	c := compact.compaction
+	db.opt.info_log.Infof("Compacting %d@%d + %d@%d files",
+		c.num_input_files(0), c.level(), c.num_input_files(1), c.level()+1)
+
+	if db.versions.NumLevelFiles(c.level()) <= 0 {
+		panic("leveldb: compaction of an empty level")
+	}
+	if compact.builder != nil || compact.outfile != nil {
+		panic("leveldb: compaction output already open")
+	}
+	if db.snapshots_.empty() {
+		compact.smallest_snapshot = db.versions.LastSequence()
+	} else {
+		compact.smallest_snapshot = db.snapshots_.oldest().sequence_
+	}
+
+	input := db.versions.MakeInputIterator(c)
+
+	// Release lock while we're actually doing the compaction work
+	db.lock.Unlock()
+
+	input.SeekToFirst()
+	var err error
+	user_cmp := db.internal_comparator_.User_comparator()
+	current_user_key := ""
+	has_current_user_key := false
+	last_sequence_for_key := kMaxSequenceNumber
+	for input.Valid() && atomic.LoadInt32(&db.shutting_down_) == 0 {
+		// Prioritize immutable compaction work
+		if atomic.LoadInt32(&db.has_imm_) != 0 {
+			db.lock.Lock()
+			if db.imm_ != nil {
+				db.CompactMemTable()
+				// Wake up MakeRoomForWrite() if necessary.
+				db.background_work_finished_signal_.Broadcast()
+			}
+			db.lock.Unlock()
+		}
+
+		key := input.Key()
+		if c.ShouldStopBefore(key) && compact.builder != nil {
+			if err = db.FinishCompactionOutputFile(compact, input); err != nil {
+				break
+			}
+		}
+
+		// Handle key/value, add to state, etc.
+		drop := false
+		ikey, ok := ParseInternalKey(string(key))
+		if !ok {
+			// Do not hide error keys
+			current_user_key = ""
+			has_current_user_key = false
+			last_sequence_for_key = kMaxSequenceNumber
+		} else {
+			if !has_current_user_key || user_cmp.Compare(ikey.user_key, current_user_key) != 0 {
+				// First occurrence of this user key
+				current_user_key = ikey.user_key
+				has_current_user_key = true
+				last_sequence_for_key = kMaxSequenceNumber
+			}
+
+			if last_sequence_for_key <= compact.smallest_snapshot {
+				// Hidden by an newer entry for same user key
+				drop = true // (A)
+			} else if ikey.Type == kTypeDeletion &&
+				ikey.sequence <= compact.smallest_snapshot &&
+				c.IsBaseLevelForKey(ikey.user_key) {
+				// For this user key:
+				// (1) there is no data in higher levels
+				// (2) data in lower levels will have larger sequence numbers
+				// (3) data in layers that are being compacted here and have
+				//     smaller sequence numbers will be dropped in the next
+				//     few iterations of this loop (by rule (A) above).
+				// Therefore this deletion marker is obsolete and can be dropped.
+				drop = true
+			}
+
+			last_sequence_for_key = ikey.sequence
+		}
+
+		if !drop {
+			// Open output file if necessary
+			if compact.builder == nil {
+				if err = db.OpenCompactionOutputFile(compact); err != nil {
+					break
+				}
+			}
+			if compact.builder.NumEntries() == 0 {
+				compact.current_output().smallest.DecodeFrom(string(key))
+			}
+			compact.current_output().largest.DecodeFrom(string(key))
+			compact.builder.Add(key, input.Value())
+
+			// Close output file if it is big enough
+			if compact.builder.FileSize() >= c.MaxOutputFileSize() {
+				if err = db.FinishCompactionOutputFile(compact, input); err != nil {
+					break
+				}
+			}
+		}
+
+		input.Next()
+	}
+
+	if err == nil && atomic.LoadInt32(&db.shutting_down_) != 0 {
+		err = errors.New("leveldb: deleting DB during compaction")
+	}
+	if err == nil && compact.builder != nil {
+		err = db.FinishCompactionOutputFile(compact, input)
+	}
+	if err == nil {
+		err = input.Error()
+	}
+	input.Close()
+
+	db.lock.Lock()
+
+	if err == nil {
+		err = db.InstallCompactionResults(compact)
+	}
+	db.opt.info_log.Infof("compacted to: %s", db.versions.LevelSummary())
+	return err
+}
+
+// Put sets the database entry for "key" to "value".
+// Note: consider setting options.Sync = true.
+func (db *DBImpl) Put(options *WriteOptions, key, value []byte) error {
+	batch := NewWriteBatch()
+	batch.Put(key, value)
+	return db.Write(options, batch)
+}
+
+// Delete removes the database entry (if any) for "key".  It is not an
+// error if "key" did not exist in the database.
+// Note: consider setting options.Sync = true.
+func (db *DBImpl) Delete(options *WriteOptions, key []byte) error {
+	batch := NewWriteBatch()
+	batch.Delete(key)
+	return db.Write(options, batch)
+}
+
+// Get returns the value for "key" if the database contains an entry
+// for it, ErrNotFound if there is no entry and any other error on
+// failure. A nil options is the same as NewReadOptions().
+func (db *DBImpl) Get(options *ReadOptions, key []byte) ([]byte, error) {
+	if options == nil {
+		options = NewReadOptions()
+	}
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	snapshot := db.versions.LastSequence()
+	if options.Snapshot != nil {
+		snapshot = options.Snapshot.sequence_
+	}
+
+	mem := db.mem_
+	imm := db.imm_
+	current := db.versions.current_
+	mem.Ref()
+	if imm != nil {
+		imm.Ref()
+	}
+	current.Ref()
+
+	have_stat_update := false
+	stats := &GetStats{}
+	var value []byte
+	var err error
+
+	// Unlock while reading from files and memtables
+	{
+		db.lock.Unlock()
+		// First look in the memtable, then in the immutable memtable (if any).
+		lkey := NewLookupKey(key, snapshot)
+		found := false
+		value, found, err = mem.Get(lkey)
+		if !found && imm != nil {
+			value, found, err = imm.Get(lkey)
+		}
+		if !found {
+			value, err = current.Get(options, lkey, stats)
+			have_stat_update = true
+		}
+		db.lock.Lock()
+	}
+
+	if have_stat_update && current.UpdateStats(stats) {
+		db.MaybeScheduleCompaction()
+	}
+	mem.Unref()
+	if imm != nil {
+		imm.Unref()
+	}
+	current.Unref()
+	return value, err
+}
+
+// NewInternalIterator returns an iterator over the internal keys of
+// the memtables and of the current version, along with the sequence
+// number of the latest write.
+func (db *DBImpl) NewInternalIterator(options *ReadOptions) (table.Iterator, SequenceNumber) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	latest_snapshot := db.versions.LastSequence()
+
+	// Collect together all needed child iterators
+	list := []table.Iterator{db.mem_.NewIterator()}
+	mem := db.mem_
+	mem.Ref()
+	imm := db.imm_
+	if imm != nil {
+		list = append(list, imm.NewIterator())
+		imm.Ref()
+	}
+	current := db.versions.current_
+	list = current.AddIterators(options.tableReadOptions(), list)
+	internal_iter := table.NewMergingIterator(db.internal_comparator_, list)
+	current.Ref()
+
+	return table.NewCleanupIterator(internal_iter, func() {
+		db.lock.Lock()
+		mem.Unref()
+		if imm != nil {
+			imm.Unref()
+		}
+		current.Unref()
+		db.lock.Unlock()
+	}), latest_snapshot
+}
+
+// NewIterator returns an iterator over the contents of the database.
+// The result of NewIterator() is initially invalid (caller must call
+// one of the Seek methods on the iterator before using it).
+//
+// Caller should Close the iterator when it is no longer needed.
+// A nil options is the same as NewReadOptions().
+func (db *DBImpl) NewIterator(options *ReadOptions) Iterator {
+	if options == nil {
+		options = NewReadOptions()
+	}
+	iter, latest_snapshot := db.NewInternalIterator(options)
+	sequence := latest_snapshot
+	if options.Snapshot != nil {
+		sequence = options.Snapshot.sequence_
+	}
+	return NewDBIterator(db.internal_comparator_.User_comparator(), iter, sequence, options.LowerBound, options.UpperBound)
+}
+
+// PrefixIterator returns an iterator over the keys of the DB that
+// start with prefix, it is NewIterator with the bounds of options
+// narrowed to those keys.
+// REQUIRES: the comparator of the DB orders the keys bytewise.
+func (db *DBImpl) PrefixIterator(options *ReadOptions, prefix []byte) Iterator {
+	opts := NewReadOptions()
+	if options != nil {
+		*opts = *options
+	}
+	ucmp := db.internal_comparator_.User_comparator()
+	if opts.LowerBound == nil || ucmp.Compare(string(prefix), string(opts.LowerBound)) > 0 {
+		opts.LowerBound = prefix
+	}
+	if limit := prefixSuccessor(prefix); limit != nil {
+		if opts.UpperBound == nil || ucmp.Compare(string(limit), string(opts.UpperBound)) < 0 {
+			opts.UpperBound = limit
+		}
+	}
+	return db.NewIterator(opts)
+}
+
+// GetSnapshot returns a handle to the current DB state.  Reads made
+// with this handle in ReadOptions.Snapshot observe a stable snapshot
+// of the current DB state.  The caller must call ReleaseSnapshot(result)
+// when the snapshot is no longer needed.
+func (db *DBImpl) GetSnapshot() *Snapshot {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	return db.snapshots_.New(db.versions.LastSequence())
+}
+
+// ReleaseSnapshot releases a previously acquired snapshot.  The caller
+// must not use "snapshot" after this call.
+func (db *DBImpl) ReleaseSnapshot(snapshot *Snapshot) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.snapshots_.Delete(snapshot)
+}
+
+func (db *DBImpl) RecordBackgroundError(err error) {
+	if db.bg_error == nil {
+		db.bg_error = err
+		db.background_work_finished_signal_.Broadcast()
+	}
+}
+
+// Writer is a caller of Write waiting in DBImpl.writers_ for its batch
+// to be committed, either by itself or by the writer in front of it.
+type Writer struct {
+	err   error
+	batch *WriteBatch
+	sync  bool
+	done  bool
+	cv    *sync.Cond
+}
+
+// Write applies the specified updates to the database. A nil options
+// is the same as the default WriteOptions.
+func (db *DBImpl) Write(options *WriteOptions, updates *WriteBatch) error {
+	if options == nil {
+		options = &WriteOptions{}
+	}
+	w := &Writer{batch: updates, sync: options.Sync, cv: sync.NewCond(&db.lock)}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.writers_ = append(db.writers_, w)
+	for !w.done && w != db.writers_[0] {
+		w.cv.Wait()
+	}
+	if w.done {
+		return w.err
+	}
+
+	// May temporarily unlock and wait.
+	err := db.MakeRoomForWrite(updates == nil)
+	last_sequence := db.versions.LastSequence()
+	last_writer := w
+	if err == nil && updates != nil { // nil batch is for compactions
+		write_batch := db.BuildBatchGroup(&last_writer)
+		write_batch.SetSequence(last_sequence + 1)
+		last_sequence += SequenceNumber(write_batch.Count())
+
+		// Add to log and apply to memtable.  We can release the lock
+		// during this phase since w is currently responsible for logging
+		// and protects against concurrent loggers and concurrent writes
+		// into mem_.
+		{
+			db.lock.Unlock()
+			err = db.log_.AddRecord(write_batch.Contents())
+			sync_error := false
+			if err == nil && w.sync {
+				// The group is synced if its first writer asked for it,
+				// BuildBatchGroup never adds a sync writer to a non-sync group.
+				err = db.logfile_.Sync()
+				if err != nil {
+					sync_error = true
+				}
+			}
+			if err == nil {
+				err = write_batch.InsertInto(db.mem_)
+			}
+			db.lock.Lock()
+			if sync_error {
+				// The state of the log file is indeterminate: the log record we
+				// just added may or may not show up when the DB is re-opened.
+				// So we force the DB into a mode where all future writes fail.
+				db.RecordBackgroundError(err)
+			}
+		}
+		if write_batch == db.tmp_batch_ {
+			db.tmp_batch_.Clear()
+		}
+
+		db.versions.SetLastSequence(last_sequence)
+	}
+
+	for {
+		ready := db.writers_[0]
+		db.writers_[0] = nil
+		db.writers_ = db.writers_[1:]
+		if ready != w {
+			ready.err = err
+			ready.done = true
+			ready.cv.Signal()
+		}
+		if ready == last_writer {
+			break
+		}
+	}
+
+	// Notify new head of write queue
+	if len(db.writers_) != 0 {
+		db.writers_[0].cv.Signal()
+	}
+
+	return err
+}
+
+// BuildBatchGroup merges the batches of the writers queued behind the
+// front writer into one group commit, last_writer is set to the last
+// writer whose batch was included.
+// REQUIRES: Writer list must be non-empty
+// REQUIRES: First writer must have a non-nil batch
+func (db *DBImpl) BuildBatchGroup(last_writer **Writer) *WriteBatch {
+	first := db.writers_[0]
+	result := first.batch
+
+	size := first.batch.ApproximateSize()
+
+	// Allow the group to grow up to a maximum size, but if the
+	// original write is small, limit the growth so we do not slow
+	// down the small write too much.
+	max_size := 1 << 20
+	if size <= (128 << 10) {
+		max_size = size + (128 << 10)
+	}
+
+	*last_writer = first
+	for _, w := range db.writers_[1:] {
+		if w.sync && !first.sync {
+			// Do not include a sync write into a batch handled by a non-sync write.
+			break
+		}
+
+		if w.batch != nil {
+			size += w.batch.ApproximateSize()
+			if size > max_size {
+				// Do not make batch too big
+				break
+			}
+
+			// Append to *result
+			if result == first.batch {
+				// Switch to temporary batch instead of disturbing caller's batch
+				result = db.tmp_batch_
+				result.Append(first.batch)
+			}
+			result.Append(w.batch)
+		}
+		*last_writer = w
+	}
+	return result
+}
+
+// MakeRoomForWrite makes sure there is room in mem_ for the next
+// write, switching to a new memtable and log when it is full.
+// REQUIRES: lock is held
+// REQUIRES: this goroutine is currently at the front of the writer queue
+func (db *DBImpl) MakeRoomForWrite(force bool) error {
+	allow_delay := !force
+	for {
+		if db.bg_error != nil {
+			// Yield previous error
+			return db.bg_error
+		} else if allow_delay && db.versions.NumLevelFiles(0) >= kL0_SlowdownWritesTrigger {
+			// We are getting close to hitting a hard limit on the number of
+			// L0 files.  Rather than delaying a single write by several
+			// seconds when we hit the hard limit, start delaying each
+			// individual write by 1ms to reduce latency variance.  Also,
+			// this delay hands over some CPU to the compaction thread in
+			// case it is sharing the same core as the writer.
+			db.lock.Unlock()
+			db.env_.SleepForMicroseconds(1000)
+			allow_delay = false // Do not delay a single write more than once
+			db.lock.Lock()
+		} else if !force && db.mem_.ApproximateMemoryUsage() <= db.opt.WriteBufferSize {
+			// There is room in current memtable
+			return nil
+		} else if db.imm_ != nil {
+			// We have filled up the current memtable, but the previous
+			// one is still being compacted, so we wait.
+			db.opt.info_log.Infof("Current memtable full; waiting...")
+			db.background_work_finished_signal_.Wait()
+		} else if db.versions.NumLevelFiles(0) >= kL0_StopWritesTrigger {
+			// There are too many level-0 files.
+			db.opt.info_log.Infof("Too many L0 files; waiting...")
+			db.background_work_finished_signal_.Wait()
+		} else {
+			// Attempt to switch to a new memtable and trigger compaction of old
+			new_log_number := db.versions.NewFileNumber()
+			lfile, err := db.env_.NewWritableFile(LogFileName(db.dbName, new_log_number))
+			if err != nil {
+				// Avoid chewing through file number space in a tight loop.
+				db.versions.ReuseFileNumber(new_log_number)
+				return err
+			}
+
+			if err := db.logfile_.Close(); err != nil {
+				// We may have lost some data written to the previous log file.
+				// Switch to the new log file anyway, but record as a background
+				// error so we do not attempt any more writes.
+				//
+				// We could perhaps attempt to save the memtable corresponding
+				// to log file and suppress the error if that works, but that
+				// would add more complexity in a critical code path.
+				db.RecordBackgroundError(err)
+			}
+			db.logfile_ = lfile
+			db.logfile_number_ = new_log_number
+			db.log_ = NewLogWriter(lfile)
+			db.imm_ = db.mem_
+			atomic.StoreInt32(&db.has_imm_, 1)
+			db.mem_ = NewMemTable(db.internal_comparator_)
+			db.mem_.Ref()
+			force = false // Do not force another compaction if have room
+			db.MaybeScheduleCompaction()
+		}
+	}
+}
+
 type Logs []uint64
 
 func (l Logs) Less(i, j int) bool {
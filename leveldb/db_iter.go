@@ -0,0 +1,309 @@
+package leveldb
+
+import (
+	"github.com/lemonwx/goleveldb/leveldb/table"
+	"github.com/lemonwx/goleveldb/leveldb/utils"
+)
+
+// Iterator is the interface of the iterators returned by
+// DBImpl.NewIterator, keys and values are user keys and values.
+type Iterator = table.Iterator
+
+type direction int
+
+// Which direction is the iterator currently moving?
+// (1) When moving forward, the internal iterator is positioned at
+// the exact entry that yields it.Key(), it.Value()
+// (2) When moving backwards, the internal iterator is positioned
+// just before all entries whose user key == it.Key().
+const (
+	kForward direction = iota
+	kReverse
+)
+
+// Memtables and sstables that make the DB representation contain
+// (userkey,seq,type) => uservalue entries.  DBIter combines multiple
+// entries for the same userkey found in the DB representation into a
+// single entry while accounting for sequence numbers, deletion
+// markers, overwrites, etc.
+type DBIter struct {
+	user_comparator_ utils.Comparator
+	iter_            table.Iterator
+	sequence_        SequenceNumber
+	status_          error
+	saved_key_       []byte // == current key when direction_==kReverse
+	saved_value_     []byte // == current raw value when direction_==kReverse
+	direction_       direction
+	valid_           bool
+	lower_bound_     []byte // If non-nil, no key before it is yielded
+	upper_bound_     []byte // If non-nil, no key at or after it is yielded
+}
+
+// NewDBIterator returns a new iterator that converts internal keys
+// (yielded by "internal_iter") that were live at the specified
+// "sequence" number into appropriate user keys.  Only the user keys
+// within [lower_bound, upper_bound) are yielded, a nil bound is
+// unbounded.
+func NewDBIterator(user_key_comparator utils.Comparator, internal_iter table.Iterator, sequence SequenceNumber, lower_bound, upper_bound []byte) *DBIter {
+	return &DBIter{
+		user_comparator_: user_key_comparator,
+		iter_:            internal_iter,
+		sequence_:        sequence,
+		direction_:       kForward,
+		lower_bound_:     lower_bound,
+		upper_bound_:     upper_bound,
+	}
+}
+
+func (it *DBIter) Valid() bool {
+	return it.valid_
+}
+
+func (it *DBIter) Key() []byte {
+	if it.direction_ == kForward {
+		return []byte(utils.ExtractUserKey(string(it.iter_.Key())))
+	}
+	return it.saved_key_
+}
+
+func (it *DBIter) Value() []byte {
+	if it.direction_ == kForward {
+		return it.iter_.Value()
+	}
+	return it.saved_value_
+}
+
+func (it *DBIter) Error() error {
+	if it.status_ == nil {
+		return it.iter_.Error()
+	}
+	return it.status_
+}
+
+func (it *DBIter) Close() error {
+	return it.iter_.Close()
+}
+
+func (it *DBIter) SaveKey(k string, dst *[]byte) {
+	*dst = append((*dst)[:0], k...)
+}
+
+func (it *DBIter) ClearSavedValue() {
+	if cap(it.saved_value_) > 1048576 {
+		it.saved_value_ = nil
+	} else {
+		it.saved_value_ = it.saved_value_[:0]
+	}
+}
+
+func (it *DBIter) ParseKey() (*ParsedInternalKey, bool) {
+	// todo: sample reads for seek-triggered compactions
+	ikey, ok := ParseInternalKey(string(it.iter_.Key()))
+	if !ok {
+		it.status_ = utils.NewCorruption("corrupted internal key in DBIter")
+		return nil, false
+	}
+	return ikey, true
+}
+
+func (it *DBIter) Next() {
+	if !it.valid_ {
+		panic("leveldb: Next on an invalid DBIter")
+	}
+
+	if it.direction_ == kReverse { // Switch directions?
+		it.direction_ = kForward
+		// iter_ is pointing just before the entries for it.Key(),
+		// so advance into the range of entries for it.Key() and then
+		// use the normal skipping code below.
+		if !it.iter_.Valid() {
+			it.iter_.SeekToFirst()
+		} else {
+			it.iter_.Next()
+		}
+		if !it.iter_.Valid() {
+			it.valid_ = false
+			it.saved_key_ = it.saved_key_[:0]
+			return
+		}
+		// saved_key_ already contains the key to skip past.
+	} else {
+		// Store in saved_key_ the current key so we skip it below.
+		it.SaveKey(utils.ExtractUserKey(string(it.iter_.Key())), &it.saved_key_)
+
+		// iter_ is pointing to current key. We can now safely move to the next to
+		// avoid checking current key.
+		it.iter_.Next()
+		if !it.iter_.Valid() {
+			it.valid_ = false
+			it.saved_key_ = it.saved_key_[:0]
+			return
+		}
+	}
+
+	it.FindNextUserEntry(true, &it.saved_key_)
+}
+
+func (it *DBIter) FindNextUserEntry(skipping bool, skip *[]byte) {
+	// Loop until we hit an acceptable entry to yield
+	for {
+		ikey, ok := it.ParseKey()
+		if ok && it.upper_bound_ != nil && it.user_comparator_.Compare(ikey.user_key, string(it.upper_bound_)) >= 0 {
+			// The rest of the entries are out of bounds
+			break
+		}
+		if ok && ikey.sequence <= it.sequence_ {
+			switch ikey.Type {
+			case kTypeDeletion:
+				// Arrange to skip all upcoming entries for this key since
+				// they are hidden by this deletion.
+				it.SaveKey(ikey.user_key, skip)
+				skipping = true
+			case kTypeValue:
+				if skipping && it.user_comparator_.Compare(ikey.user_key, string(*skip)) <= 0 {
+					// Entry hidden
+				} else {
+					it.valid_ = true
+					it.saved_key_ = it.saved_key_[:0]
+					return
+				}
+			}
+		}
+		it.iter_.Next()
+		if !it.iter_.Valid() {
+			break
+		}
+	}
+	it.saved_key_ = it.saved_key_[:0]
+	it.valid_ = false
+}
+
+func (it *DBIter) Prev() {
+	if !it.valid_ {
+		panic("leveldb: Prev on an invalid DBIter")
+	}
+
+	if it.direction_ == kForward { // Switch directions?
+		// iter_ is pointing at the current entry.  Scan backwards until
+		// the key changes so we can use the normal reverse scanning code.
+		it.SaveKey(utils.ExtractUserKey(string(it.iter_.Key())), &it.saved_key_)
+		for {
+			it.iter_.Prev()
+			if !it.iter_.Valid() {
+				it.valid_ = false
+				it.saved_key_ = it.saved_key_[:0]
+				it.ClearSavedValue()
+				return
+			}
+			if it.user_comparator_.Compare(utils.ExtractUserKey(string(it.iter_.Key())), string(it.saved_key_)) < 0 {
+				break
+			}
+		}
+		it.direction_ = kReverse
+	}
+
+	it.FindPrevUserEntry()
+}
+
+func (it *DBIter) FindPrevUserEntry() {
+	value_type := kTypeDeletion
+	for it.iter_.Valid() {
+		ikey, ok := it.ParseKey()
+		if ok && it.lower_bound_ != nil && it.user_comparator_.Compare(ikey.user_key, string(it.lower_bound_)) < 0 {
+			// The rest of the entries are out of bounds
+			break
+		}
+		if ok && ikey.sequence <= it.sequence_ {
+			if value_type != kTypeDeletion && it.user_comparator_.Compare(ikey.user_key, string(it.saved_key_)) < 0 {
+				// We encountered a non-deleted value in entries for previous keys,
+				break
+			}
+			value_type = ikey.Type
+			if value_type == kTypeDeletion {
+				it.saved_key_ = it.saved_key_[:0]
+				it.ClearSavedValue()
+			} else {
+				raw_value := it.iter_.Value()
+				if cap(it.saved_value_) > len(raw_value)+1048576 {
+					it.saved_value_ = nil
+				}
+				it.SaveKey(ikey.user_key, &it.saved_key_)
+				it.saved_value_ = append(it.saved_value_[:0], raw_value...)
+			}
+		}
+		it.iter_.Prev()
+	}
+
+	if value_type == kTypeDeletion {
+		// End
+		it.valid_ = false
+		it.saved_key_ = it.saved_key_[:0]
+		it.ClearSavedValue()
+		it.direction_ = kForward
+	} else {
+		it.valid_ = true
+	}
+}
+
+func (it *DBIter) Seek(target []byte) {
+	if it.lower_bound_ != nil && it.user_comparator_.Compare(string(target), string(it.lower_bound_)) < 0 {
+		target = it.lower_bound_
+	}
+	it.direction_ = kForward
+	it.ClearSavedValue()
+	it.saved_key_ = it.saved_key_[:0]
+	AppendInternalKey(&it.saved_key_, &ParsedInternalKey{user_key: string(target), sequence: it.sequence_, Type: kValueTypeForSeek})
+	it.iter_.Seek(it.saved_key_)
+	if it.iter_.Valid() {
+		it.FindNextUserEntry(false, &it.saved_key_ /* temporary storage */)
+	} else {
+		it.valid_ = false
+	}
+}
+
+func (it *DBIter) SeekToFirst() {
+	if it.lower_bound_ != nil {
+		it.Seek(it.lower_bound_)
+		return
+	}
+	it.direction_ = kForward
+	it.ClearSavedValue()
+	it.iter_.SeekToFirst()
+	if it.iter_.Valid() {
+		it.FindNextUserEntry(false, &it.saved_key_ /* temporary storage */)
+	} else {
+		it.valid_ = false
+	}
+}
+
+func (it *DBIter) SeekToLast() {
+	it.direction_ = kReverse
+	it.ClearSavedValue()
+	if it.upper_bound_ == nil {
+		it.iter_.SeekToLast()
+	} else {
+		// Position just before all entries at or after the bound
+		it.saved_key_ = it.saved_key_[:0]
+		AppendInternalKey(&it.saved_key_, &ParsedInternalKey{user_key: string(it.upper_bound_), sequence: kMaxSequenceNumber, Type: kValueTypeForSeek})
+		it.iter_.Seek(it.saved_key_)
+		if it.iter_.Valid() {
+			it.iter_.Prev()
+		} else {
+			it.iter_.SeekToLast()
+		}
+	}
+	it.FindPrevUserEntry()
+}
+
+// prefixSuccessor returns the smallest key that is larger than all the
+// keys starting with prefix in bytewise order, or nil if there is none.
+func prefixSuccessor(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i -= 1 {
+		if prefix[i] != 0xff {
+			limit := append([]byte{}, prefix[:i+1]...)
+			limit[i] += 1
+			return limit
+		}
+	}
+	return nil
+}
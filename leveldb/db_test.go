@@ -0,0 +1,1667 @@
+package leveldb
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/lemonwx/goleveldb/leveldb/env"
+	"github.com/lemonwx/goleveldb/leveldb/table"
+	"github.com/lemonwx/goleveldb/leveldb/utils"
+)
+
+// readLogBatches returns the batches recorded in the log file fname.
+func readLogBatches(t *testing.T, fname string) []*WriteBatch {
+	t.Helper()
+	file, err := env.Default().NewSequentialFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader := NewLogReader(file, nil, true, 0)
+	var batches []*WriteBatch
+	for {
+		record, err := reader.ReadRecord()
+		if err != nil {
+			break
+		}
+		batch := NewWriteBatch()
+		if err := batch.SetContents(record); err != nil {
+			t.Fatal(err)
+		}
+		batches = append(batches, batch)
+	}
+	return batches
+}
+
+func TestDBConcurrentWrites(t *testing.T) {
+	// Small enough for the log to fit in one block.
+	const kNumWriters = 8
+	const kNumWrites = 40
+	db, err := Open(t.TempDir()+"/db", &Options{CreateIfMissing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < kNumWriters; w += 1 {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < kNumWrites; i += 1 {
+				key := fmt.Sprintf("w%d-%04d", w, i)
+				if err := db.Put(nil, []byte(key), []byte("v-"+key)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if seq := db.versions.LastSequence(); seq != kNumWriters*kNumWrites {
+		t.Errorf("LastSequence() = %d, want %d", seq, kNumWriters*kNumWrites)
+	}
+	for w := 0; w < kNumWriters; w += 1 {
+		for i := 0; i < kNumWrites; i += 1 {
+			key := fmt.Sprintf("w%d-%04d", w, i)
+			value, found, err := db.mem_.Get(NewLookupKey([]byte(key), kMaxSequenceNumber))
+			if !found || err != nil || string(value) != "v-"+key {
+				t.Fatalf("memtable Get(%q) = (%q, %v, %v)", key, value, found, err)
+			}
+		}
+	}
+
+	// Every write reached the log exactly once, each group commit is
+	// one record whose sequence numbers follow the previous one.
+	batches := readLogBatches(t, LogFileName(db.dbName, db.logfile_number_))
+	next := SequenceNumber(1)
+	for _, batch := range batches {
+		if batch.Sequence() != next {
+			t.Fatalf("log record starts at sequence %d, want %d", batch.Sequence(), next)
+		}
+		next += SequenceNumber(batch.Count())
+	}
+	if next != kNumWriters*kNumWrites+1 {
+		t.Errorf("log holds %d writes, want %d", next-1, kNumWriters*kNumWrites)
+	}
+	if len(batches) > kNumWriters*kNumWrites {
+		t.Errorf("%d log records for %d writes", len(batches), kNumWriters*kNumWrites)
+	}
+}
+
+func TestDBBuildBatchGroup(t *testing.T) {
+	db := NewDBImpl(t.TempDir()+"/db", &Options{})
+	small := func(key string) *WriteBatch {
+		b := NewWriteBatch()
+		b.Put([]byte(key), []byte("v"))
+		return b
+	}
+	big := NewWriteBatch()
+	big.Put([]byte("big"), make([]byte, 1<<20))
+
+	tests := []struct {
+		name    string
+		batches []*WriteBatch
+		sync    []bool // WriteOptions.Sync of each writer, false if missing
+		grouped int    // writers whose batches are merged in the group
+		count   int    // records in the merged batch
+	}{
+		{name: "single", batches: []*WriteBatch{small("a")}, grouped: 1, count: 1},
+		{name: "merged", batches: []*WriteBatch{small("a"), small("b"), small("c")}, grouped: 3, count: 3},
+		{name: "nil batch joins", batches: []*WriteBatch{small("a"), nil, small("c")}, grouped: 3, count: 2},
+		{name: "stops before a large batch", batches: []*WriteBatch{small("a"), small("b"), big, small("c")}, grouped: 2, count: 2},
+		{name: "stops before a sync write", batches: []*WriteBatch{small("a"), small("b"), small("c")}, sync: []bool{false, false, true}, grouped: 2, count: 2},
+		{name: "sync group takes non-sync writes", batches: []*WriteBatch{small("a"), small("b"), small("c")}, sync: []bool{true, false, true}, grouped: 3, count: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db.writers_ = nil
+			for i, b := range tt.batches {
+				db.writers_ = append(db.writers_, &Writer{batch: b, sync: i < len(tt.sync) && tt.sync[i]})
+			}
+			var last_writer *Writer
+			result := db.BuildBatchGroup(&last_writer)
+			if last_writer != db.writers_[tt.grouped-1] {
+				t.Errorf("group ends at a different writer, want writer %d", tt.grouped-1)
+			}
+			if result.Count() != tt.count {
+				t.Errorf("group has %d records, want %d", result.Count(), tt.count)
+			}
+			if tt.grouped == 1 && result != tt.batches[0] {
+				t.Error("a single batch was copied")
+			}
+			if tt.batches[0].Count() != 1 {
+				t.Error("the caller's batch was modified")
+			}
+			db.tmp_batch_.Clear()
+		})
+	}
+}
+
+func TestDBSyncWrites(t *testing.T) {
+	db, err := Open(t.TempDir()+"/db", &Options{CreateIfMissing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sync := &WriteOptions{Sync: true}
+	if err := db.Put(sync, []byte("a"), []byte("va")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(&WriteOptions{}, []byte("b"), []byte("vb")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Delete(sync, []byte("a")); err != nil {
+		t.Fatal(err)
+	}
+
+	batches := readLogBatches(t, LogFileName(db.dbName, db.logfile_number_))
+	var got []string
+	for _, batch := range batches {
+		got = append(got, printContents(batch))
+	}
+	want := []string{"Put(a, va)@1", "Put(b, vb)@2", "Delete(a)@3"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("log records = %q, want %q", got, want)
+	}
+}
+
+// A log record larger than a block is fragmented and read back whole.
+func TestDBLargeRecord(t *testing.T) {
+	db, err := Open(t.TempDir()+"/db", &Options{CreateIfMissing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := strings.Repeat("x", 3*kBlockSize+100)
+	if err := db.Put(nil, []byte("small"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Put(nil, []byte("large"), []byte(value)); err != nil {
+		t.Fatal(err)
+	}
+	batches := readLogBatches(t, LogFileName(db.dbName, db.logfile_number_))
+	if len(batches) != 2 {
+		t.Fatalf("read %d log records, want 2", len(batches))
+	}
+	if got := printContents(batches[1]); got != "Put(large, "+value+")@2" {
+		t.Errorf("large record read back with %d bytes", len(got))
+	}
+}
+
+func TestDBGet(t *testing.T) {
+	db, err := Open(t.TempDir()+"/db", &Options{CreateIfMissing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	put := func(key, value string) {
+		if err := db.Put(nil, []byte(key), []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put("a", "va1")
+	put("b", "vb1")
+	put("c", "vc1")
+	if err := db.Delete(nil, []byte("c")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Move the writes so far into the immutable memtable, the lookups
+	// below must find them behind the newer entries of the memtable.
+	db.lock.Lock()
+	db.imm_ = db.mem_
+	db.mem_ = NewMemTable(db.internal_comparator_)
+	db.mem_.Ref()
+	db.lock.Unlock()
+	put("a", "va2")
+	if err := db.Delete(nil, []byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	put("c", "vc2")
+
+	tests := []struct {
+		key      string
+		snapshot SequenceNumber // 0 reads the latest state
+		value    string
+		err      error
+	}{
+		{key: "a", value: "va2"},
+		{key: "b", err: ErrNotFound},
+		{key: "c", value: "vc2"},
+		{key: "d", err: ErrNotFound},
+		{key: "a", snapshot: 4, value: "va1"},
+		{key: "b", snapshot: 5, value: "vb1"},
+		{key: "c", snapshot: 3, value: "vc1"},
+		{key: "c", snapshot: 4, err: ErrNotFound},
+		{key: "c", snapshot: 6, err: ErrNotFound},
+		{key: "a", snapshot: 1, value: "va1"},
+		{key: "b", snapshot: 1, err: ErrNotFound},
+	}
+	for _, tt := range tests {
+		options := NewReadOptions()
+		if tt.snapshot != 0 {
+			options.Snapshot = &Snapshot{sequence_: tt.snapshot}
+		}
+		value, err := db.Get(options, []byte(tt.key))
+		if err != tt.err || string(value) != tt.value {
+			t.Errorf("Get(%q@%d) = (%q, %v), want (%q, %v)", tt.key, tt.snapshot, value, err, tt.value, tt.err)
+		}
+	}
+	if value, err := db.Get(nil, []byte("a")); err != nil || string(value) != "va2" {
+		t.Errorf("Get(nil options) = (%q, %v), want va2", value, err)
+	}
+}
+
+// dbTestOp is a Put, or a Delete if value is nil.
+type dbTestOp struct {
+	key   string
+	value []byte
+}
+
+func dbTestPut(key, value string) dbTestOp {
+	return dbTestOp{key: key, value: []byte(value)}
+}
+
+func dbTestDelete(key string) dbTestOp {
+	return dbTestOp{key: key}
+}
+
+// applyDBTestOps writes ops to db and mirrors them in model.
+func applyDBTestOps(t *testing.T, db *DBImpl, ops []dbTestOp, model map[string]string) {
+	t.Helper()
+	for _, op := range ops {
+		var err error
+		if op.value == nil {
+			err = db.Delete(nil, []byte(op.key))
+			delete(model, op.key)
+		} else {
+			err = db.Put(nil, []byte(op.key), op.value)
+			model[op.key] = string(op.value)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// recoverLog replays the current log of db into a fresh memtable the
+// way Open would, with the log reused so that no table is written.
+func recoverLog(t *testing.T, db *DBImpl) (SequenceNumber, error) {
+	t.Helper()
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.opt.ReuseLogs = true
+	db.mem_.Unref()
+	db.mem_ = nil
+	save_manifest := false
+	max_sequence := SequenceNumber(0)
+	err := db.RecoverLogFile(db.logfile_number_, true, &save_manifest, NewVersionEdit(), &max_sequence)
+	if err == nil && db.mem_ == nil {
+		t.Fatal("RecoverLogFile did not reuse the last log")
+	}
+	return max_sequence, err
+}
+
+func TestDBRecoverLogFile(t *testing.T) {
+	tests := []struct {
+		name     string
+		ops      []dbTestOp
+		corrupt  bool // flip the last byte of the log
+		paranoid bool
+		want     map[string]string
+		absent   []string
+		max_seq  SequenceNumber
+		err      bool
+	}{
+		{
+			name:    "empty log",
+			want:    map[string]string{},
+			absent:  []string{"foo"},
+			max_seq: 0,
+		},
+		{
+			name:    "puts and deletes",
+			ops:     []dbTestOp{dbTestPut("foo", "v1"), dbTestPut("bar", "v2"), dbTestDelete("bar"), dbTestPut("baz", "v3"), dbTestPut("foo", "v4")},
+			want:    map[string]string{"foo": "v4", "baz": "v3"},
+			absent:  []string{"bar", "missing"},
+			max_seq: 5,
+		},
+		{
+			name:    "corrupted record is dropped",
+			ops:     []dbTestOp{dbTestPut("a", "va"), dbTestPut("b", "vb")},
+			corrupt: true,
+			want:    map[string]string{"a": "va"},
+			absent:  []string{"b"},
+			max_seq: 1,
+		},
+		{
+			name:     "corrupted record fails paranoid checks",
+			ops:      []dbTestOp{dbTestPut("a", "va"), dbTestPut("b", "vb")},
+			corrupt:  true,
+			paranoid: true,
+			err:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := Open(t.TempDir()+"/db", &Options{CreateIfMissing: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			applyDBTestOps(t, db, tt.ops, map[string]string{})
+			if tt.corrupt {
+				fname := LogFileName(db.dbName, db.logfile_number_)
+				contents, err := os.ReadFile(fname)
+				if err != nil {
+					t.Fatal(err)
+				}
+				contents[len(contents)-1] ^= 0xff
+				if err := os.WriteFile(fname, contents, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			db.opt.ParanoidChecks = tt.paranoid
+
+			max_seq, err := recoverLog(t, db)
+			if tt.err {
+				if err == nil {
+					t.Fatal("RecoverLogFile succeeded")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("RecoverLogFile: %v", err)
+			}
+			if max_seq != tt.max_seq {
+				t.Errorf("max sequence = %d, want %d", max_seq, tt.max_seq)
+			}
+			for key, value := range tt.want {
+				got, found, err := db.mem_.Get(NewLookupKey([]byte(key), kMaxSequenceNumber))
+				if !found || err != nil || string(got) != value {
+					t.Errorf("Get(%q) = (%q, %v, %v), want %q", key, got, found, err, value)
+				}
+			}
+			for _, key := range tt.absent {
+				if _, found, err := db.mem_.Get(NewLookupKey([]byte(key), kMaxSequenceNumber)); found && err != ErrNotFound {
+					t.Errorf("Get(%q) found a value", key)
+				}
+			}
+		})
+	}
+}
+
+// checkDBContents verifies that db holds every entry of model.
+func checkDBContents(t *testing.T, db *DBImpl, model map[string]string) {
+	t.Helper()
+	for key, value := range model {
+		got, err := db.Get(nil, []byte(key))
+		if err != nil || string(got) != value {
+			t.Fatalf("Get(%q) = (%q, %v), want %q", key, got, err, value)
+		}
+	}
+}
+
+func TestDBRecovery(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  Options
+		sessions [][]dbTestOp
+		absent   []string
+		level0   int // level-0 tables after the last reopen
+	}{
+		{
+			name:    "from the log",
+			options: Options{ReuseLogs: true},
+			sessions: [][]dbTestOp{
+				{dbTestPut("foo", "v1"), dbTestPut("bar", "v2"), dbTestDelete("bar"), dbTestPut("baz", "v3")},
+			},
+			absent: []string{"bar", "missing"},
+		},
+		{
+			name:    "across several reopens",
+			options: Options{ReuseLogs: true},
+			sessions: [][]dbTestOp{
+				{dbTestPut("foo", "v1"), dbTestPut("bar", "v2")},
+				{dbTestPut("foo", "v3"), dbTestDelete("bar")},
+				{},
+				{dbTestPut("bar", "v4")},
+			},
+		},
+		{
+			// Each reopen writes the recovered log to a level-0 table.
+			name: "into level-0 tables",
+			sessions: [][]dbTestOp{
+				{dbTestPut("foo", "v1"), dbTestPut("bar", "v2")},
+				{dbTestPut("foo", "v3"), dbTestDelete("bar")},
+				{},
+				{dbTestPut("bar", "v4")},
+			},
+			level0: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbname := t.TempDir() + "/db"
+			open := func() *DBImpl {
+				options := tt.options
+				options.CreateIfMissing = true
+				db, err := Open(dbname, &options)
+				if err != nil {
+					t.Fatalf("Open: %v", err)
+				}
+				return db
+			}
+
+			model := map[string]string{}
+			db := open()
+			for _, session := range tt.sessions {
+				applyDBTestOps(t, db, session, model)
+				checkDBContents(t, db, model)
+				last_sequence := db.versions.LastSequence()
+				if err := db.Close(); err != nil {
+					t.Fatalf("Close: %v", err)
+				}
+				db = open()
+				checkDBContents(t, db, model)
+				if seq := db.versions.LastSequence(); seq != last_sequence {
+					t.Errorf("LastSequence() = %d after reopen, want %d", seq, last_sequence)
+				}
+			}
+
+			for _, key := range tt.absent {
+				if _, err := db.Get(nil, []byte(key)); err != ErrNotFound {
+					t.Errorf("Get(%q) = %v, want ErrNotFound", key, err)
+				}
+			}
+			if n := db.versions.NumLevelFiles(0); n != tt.level0 {
+				t.Errorf("NumLevelFiles(0) = %d, want %d", n, tt.level0)
+			}
+		})
+	}
+}
+
+func TestDBOpenMissing(t *testing.T) {
+	if _, err := Open(t.TempDir()+"/db", &Options{}); err == nil {
+		t.Error("Open of a missing DB without CreateIfMissing succeeded")
+	}
+}
+
+func TestDBWriteLevel0Table(t *testing.T) {
+	db, err := Open(t.TempDir()+"/db", &Options{CreateIfMissing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem := NewMemTable(db.internal_comparator_)
+	mem.Ref()
+	defer mem.Unref()
+	mem.Add(1, kTypeValue, []byte("b"), []byte("vb"))
+	mem.Add(2, kTypeValue, []byte("a"), []byte("va"))
+	mem.Add(3, kTypeValue, []byte("c"), []byte("vc"))
+	mem.Add(4, kTypeDeletion, []byte("b"), nil)
+
+	db.lock.Lock()
+	edit := NewVersionEdit()
+	base := db.versions.current_
+	if err := db.WriteLevel0Table(mem, edit, base); err != nil {
+		db.lock.Unlock()
+		t.Fatal(err)
+	}
+	if len(edit.new_files_) != 1 {
+		db.lock.Unlock()
+		t.Fatalf("edit adds %d files, want 1", len(edit.new_files_))
+	}
+	// Nothing overlaps the table, so it is pushed down.
+	level, meta := edit.new_files_[0].k, edit.new_files_[0].f
+	if level != kMaxMemCompactLevel {
+		t.Errorf("table placed at level %d, want %d", level, kMaxMemCompactLevel)
+	}
+	if meta.smallest.user_key() != "a" || meta.largest.user_key() != "c" {
+		t.Errorf("table range = [%q, %q], want [a, c]", meta.smallest.user_key(), meta.largest.user_key())
+	}
+	size, err := env.Default().GetFileSize(TableFileName(db.dbName, meta.number))
+	if err != nil || uint64(size) != meta.file_size {
+		t.Errorf("table file is %d bytes (%v), want %d", size, err, meta.file_size)
+	}
+
+	// An empty memtable writes no table.
+	empty := NewMemTable(db.internal_comparator_)
+	empty.Ref()
+	empty_edit := NewVersionEdit()
+	err = db.WriteLevel0Table(empty, empty_edit, base)
+	empty.Unref()
+	if err != nil || len(empty_edit.new_files_) != 0 {
+		t.Errorf("empty memtable: err %v, %d new files", err, len(empty_edit.new_files_))
+	}
+
+	// Lookups that miss the memtables read the table.
+	base.files_[level] = append(base.files_[level], meta)
+	db.versions.SetLastSequence(4)
+	db.lock.Unlock()
+	tests := []struct {
+		key   string
+		value string
+		err   error
+	}{
+		{key: "a", value: "va"},
+		{key: "b", err: ErrNotFound},
+		{key: "c", value: "vc"},
+		{key: "d", err: ErrNotFound},
+	}
+	for _, tt := range tests {
+		value, err := db.Get(nil, []byte(tt.key))
+		if err != tt.err || string(value) != tt.value {
+			t.Errorf("Get(%q) = (%q, %v), want (%q, %v)", tt.key, value, err, tt.value, tt.err)
+		}
+	}
+}
+
+func TestDBMakeRoomForWrite(t *testing.T) {
+	db, err := Open(t.TempDir()+"/db", &Options{CreateIfMissing: true, WriteBufferSize: 64 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Hold back the memtable compaction so the switch can be observed.
+	db.lock.Lock()
+	db.background_compaction_scheduled_ = true
+	db.lock.Unlock()
+
+	old_log := db.logfile_number_
+	model := map[string]string{}
+	var ops []dbTestOp
+	for i := 0; i < 80; i += 1 {
+		ops = append(ops, dbTestPut(fmt.Sprintf("key%04d", i), strings.Repeat(fmt.Sprint(i%10), 1000)))
+	}
+	applyDBTestOps(t, db, ops, model)
+
+	db.lock.Lock()
+	switched := db.imm_ != nil
+	new_log := db.logfile_number_
+	db.lock.Unlock()
+	if !switched {
+		t.Fatal("memtable was not switched after filling the write buffer")
+	}
+	if new_log == old_log {
+		t.Error("writes still go to the old log")
+	}
+	// Reads see the immutable memtable and the new one.
+	for key, value := range model {
+		got, err := db.Get(nil, []byte(key))
+		if err != nil || string(got) != value {
+			t.Fatalf("Get(%q) = (%q, %v)", key, got, err)
+		}
+	}
+
+	db.lock.Lock()
+	db.CompactMemTable()
+	imm, bg_error := db.imm_, db.bg_error
+	db.lock.Unlock()
+	if imm != nil || bg_error != nil {
+		t.Errorf("after CompactMemTable: imm_ = %v, bg_error = %v", imm, bg_error)
+	}
+}
+
+// addTestTable writes ops, numbered from sequence seq, to a table and
+// installs it at level of the current version.  Returns the next
+// sequence number.
+// REQUIRES: lock is held
+func addTestTable(t *testing.T, db *DBImpl, level int, seq SequenceNumber, ops []dbTestOp) SequenceNumber {
+	t.Helper()
+	mem := NewMemTable(db.internal_comparator_)
+	mem.Ref()
+	defer mem.Unref()
+	for _, op := range ops {
+		if op.value == nil {
+			mem.Add(seq, kTypeDeletion, []byte(op.key), nil)
+		} else {
+			mem.Add(seq, kTypeValue, []byte(op.key), op.value)
+		}
+		seq += 1
+	}
+	edit := NewVersionEdit()
+	if err := db.WriteLevel0Table(mem, edit, nil); err != nil {
+		t.Fatal(err)
+	}
+	current := db.versions.current_
+	f := edit.new_files_[0].f
+	f.refs = 1
+	current.files_[level] = append(current.files_[level], f)
+	if seq-1 > db.versions.LastSequence() {
+		db.versions.SetLastSequence(seq - 1)
+	}
+	return seq
+}
+
+func TestDBPickCompaction(t *testing.T) {
+	tests := []struct {
+		name   string
+		level0 [][]dbTestOp // tables from oldest to newest
+		level1 [][]dbTestOp
+		level2 [][]dbTestOp
+		inputs [2]int   // files picked from level 0 and level 1
+		merged []string // entries of the compaction input
+		base   map[string]bool
+	}{
+		{
+			name: "overlapping level-0 files",
+			level0: [][]dbTestOp{
+				{dbTestPut("a", "a1"), dbTestPut("b", "b1"), dbTestPut("c", "c1")},
+				{dbTestPut("b", "b2"), dbTestPut("d", "d2")},
+				{dbTestDelete("a"), dbTestPut("e", "e3")},
+				{dbTestPut("c", "c4")},
+			},
+			inputs: [2]int{4, 0},
+			merged: []string{"a@6:del", "a@1=a1", "b@4=b2", "b@2=b1", "c@8=c4", "c@3=c1", "d@5=d2", "e@7=e3"},
+			base:   map[string]bool{"a": true, "e": true},
+		},
+		{
+			name: "overlapping level-1 files",
+			level0: [][]dbTestOp{
+				{dbTestPut("b", "b1")},
+				{dbTestPut("c", "c1")},
+				{dbTestPut("d", "d1")},
+				{dbTestDelete("b")},
+			},
+			level1: [][]dbTestOp{
+				{dbTestPut("a", "a0"), dbTestPut("b", "b0")},
+				{dbTestPut("x", "x0")},
+			},
+			inputs: [2]int{2, 1},
+			merged: []string{"a@1=a0", "b@7:del", "b@4=b1", "b@2=b0"},
+			base:   map[string]bool{"a": true, "b": true},
+		},
+		{
+			name: "data in deeper levels",
+			level0: [][]dbTestOp{
+				{dbTestPut("a", "a1")},
+				{dbTestPut("b", "b1")},
+				{dbTestDelete("a")},
+				{dbTestPut("c", "c1")},
+			},
+			level2: [][]dbTestOp{
+				{dbTestPut("a", "a0")},
+			},
+			inputs: [2]int{2, 0},
+			merged: []string{"a@4:del", "a@2=a1"},
+			base:   map[string]bool{"a": false, "b": true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := Open(t.TempDir()+"/db", &Options{CreateIfMissing: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			db.lock.Lock()
+			defer db.lock.Unlock()
+			db.background_compaction_scheduled_ = true
+
+			seq := SequenceNumber(1)
+			for level, tables := range [][][]dbTestOp{tt.level2, tt.level1, tt.level0} {
+				for _, ops := range tables {
+					seq = addTestTable(t, db, 2-level, seq, ops)
+				}
+			}
+			db.versions.Finalize(db.versions.current_)
+			if !db.versions.NeedsCompaction() {
+				t.Fatal("NeedsCompaction() = false with a full level 0")
+			}
+
+			c := db.versions.PickCompaction()
+			if c == nil {
+				t.Fatal("no compaction picked")
+			}
+			defer c.ReleaseInputs()
+			if c.level() != 0 || c.num_input_files(0) != tt.inputs[0] || c.num_input_files(1) != tt.inputs[1] {
+				t.Fatalf("compaction of %d@%d + %d@%d files, want %d@0 + %d@1",
+					c.num_input_files(0), c.level(), c.num_input_files(1), c.level()+1, tt.inputs[0], tt.inputs[1])
+			}
+
+			iter := db.versions.MakeInputIterator(c)
+			var merged []string
+			for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+				ikey, _ := ParseInternalKey(string(iter.Key()))
+				if ikey.Type == kTypeDeletion {
+					merged = append(merged, fmt.Sprintf("%s@%d:del", ikey.user_key, ikey.sequence))
+				} else {
+					merged = append(merged, fmt.Sprintf("%s@%d=%s", ikey.user_key, ikey.sequence, iter.Value()))
+				}
+			}
+			if err := iter.Error(); err != nil {
+				t.Error(err)
+			}
+			iter.Close()
+			if strings.Join(merged, " ") != strings.Join(tt.merged, " ") {
+				t.Errorf("compaction input = %q, want %q", merged, tt.merged)
+			}
+
+			// IsBaseLevelForKey expects increasing keys.
+			keys := make([]string, 0, len(tt.base))
+			for user_key := range tt.base {
+				keys = append(keys, user_key)
+			}
+			sort.Strings(keys)
+			for _, user_key := range keys {
+				want := tt.base[user_key]
+				if got := c.IsBaseLevelForKey(user_key); got != want {
+					t.Errorf("IsBaseLevelForKey(%q) = %v, want %v", user_key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDBCompactRangeInputs(t *testing.T) {
+	db, err := Open(t.TempDir()+"/db", &Options{CreateIfMissing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.background_compaction_scheduled_ = true
+	// Below the smallest MaxFileSize Open allows, to keep the tables small.
+	db.opt.MaxFileSize = 2 << 10
+
+	// Level 1 holds four ~1KB files, level 0 a file over "c".."d".
+	value := strings.Repeat("x", 1000)
+	seq := SequenceNumber(1)
+	for _, key := range []string{"a", "b", "e", "f"} {
+		seq = addTestTable(t, db, 1, seq, []dbTestOp{dbTestPut(key, value)})
+	}
+	seq = addTestTable(t, db, 0, seq, []dbTestOp{dbTestPut("c", "c"), dbTestPut("d", "d")})
+	addTestTable(t, db, 2, seq, []dbTestOp{dbTestPut("z", "z")})
+
+	user_key := func(key string) *InternalKey {
+		if key == "" {
+			return nil
+		}
+		return NewInternalKey(key, kMaxSequenceNumber, kValueTypeForSeek)
+	}
+	tests := []struct {
+		level        int
+		begin, end   string // "" for an open end
+		inputs       [2]int
+		trivial_move bool
+	}{
+		{level: 0, inputs: [2]int{1, 0}, trivial_move: true},
+		{level: 0, begin: "x", end: "y", inputs: [2]int{0, 0}},
+		{level: 1, begin: "a", end: "a", inputs: [2]int{1, 0}, trivial_move: true},
+		// Level > 0 compactions stop at MaxFileSize bytes of input.
+		{level: 1, begin: "a", end: "f", inputs: [2]int{2, 0}},
+		{level: 1, begin: "c", end: "", inputs: [2]int{2, 0}},
+		{level: 2, inputs: [2]int{1, 0}, trivial_move: true},
+		{level: 3, inputs: [2]int{0, 0}},
+	}
+	for _, tt := range tests {
+		c := db.versions.CompactRange(tt.level, user_key(tt.begin), user_key(tt.end))
+		if c == nil {
+			if tt.inputs[0] != 0 {
+				t.Errorf("CompactRange(%d, %q, %q) = nil", tt.level, tt.begin, tt.end)
+			}
+			continue
+		}
+		if c.num_input_files(0) != tt.inputs[0] || c.num_input_files(1) != tt.inputs[1] {
+			t.Errorf("CompactRange(%d, %q, %q) picked %d + %d files, want %d + %d", tt.level, tt.begin, tt.end,
+				c.num_input_files(0), c.num_input_files(1), tt.inputs[0], tt.inputs[1])
+		}
+		if c.IsTrivialMove() != tt.trivial_move {
+			t.Errorf("CompactRange(%d, %q, %q).IsTrivialMove() = %v", tt.level, tt.begin, tt.end, !tt.trivial_move)
+		}
+		c.ReleaseInputs()
+	}
+}
+
+func TestDBCompactLevelRange(t *testing.T) {
+	db, err := Open(t.TempDir()+"/db", &Options{CreateIfMissing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, level := range []int{-1, levelNum - 1, levelNum} {
+		if err := db.CompactLevelRange(level, nil, nil); !utils.IsInvalidArgument(err) {
+			t.Errorf("CompactLevelRange(%d) = %v, want invalid argument", level, err)
+		}
+	}
+	if err := db.CompactLevelRange(levelNum-2, nil, nil); err != nil {
+		t.Errorf("CompactLevelRange(%d) = %v", levelNum-2, err)
+	}
+}
+
+// readTestTable returns the entries of table f as "key@seq=value" or
+// "key@seq:del".
+func readTestTable(t *testing.T, db *DBImpl, f *FileMetaData) []string {
+	t.Helper()
+	iter := db.table_cache_.NewIterator(&table.ReadOptions{VerifyChecksums: true}, f.number, f.file_size)
+	defer iter.Close()
+	var entries []string
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		ikey, _ := ParseInternalKey(string(iter.Key()))
+		if ikey.Type == kTypeDeletion {
+			entries = append(entries, fmt.Sprintf("%s@%d:del", ikey.user_key, ikey.sequence))
+		} else {
+			entries = append(entries, fmt.Sprintf("%s@%d=%s", ikey.user_key, ikey.sequence, iter.Value()))
+		}
+	}
+	if err := iter.Error(); err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestDBBackgroundCompaction(t *testing.T) {
+	tests := []struct {
+		name   string
+		level0 [][]dbTestOp // tables from oldest to newest
+		level1 [][]dbTestOp
+		level2 [][]dbTestOp
+		// seek_compact marks the first level-1 file for a seek compaction.
+		seek_compact bool
+		files        [3]int   // number of files per level afterwards
+		output       []string // entries of the new table
+		output_level int
+		model        map[string]string
+		deleted      []string
+	}{
+		{
+			name: "newest value wins",
+			level0: [][]dbTestOp{
+				{dbTestPut("a", "a1"), dbTestPut("b", "b1"), dbTestPut("c", "c1")},
+				{dbTestPut("b", "b2"), dbTestPut("d", "d2")},
+				{dbTestDelete("a"), dbTestPut("e", "e3")},
+				{dbTestPut("c", "c4")},
+			},
+			files:        [3]int{0, 1, 0},
+			output:       []string{"b@4=b2", "c@8=c4", "d@5=d2", "e@7=e3"},
+			output_level: 1,
+			model:        map[string]string{"b": "b2", "c": "c4", "d": "d2", "e": "e3"},
+			deleted:      []string{"a"},
+		},
+		{
+			name: "merges with overlapping level-1",
+			level0: [][]dbTestOp{
+				{dbTestPut("b", "b1")},
+				{dbTestPut("c", "c1")},
+				{dbTestPut("d", "d1")},
+				{dbTestDelete("b")},
+			},
+			level1: [][]dbTestOp{
+				{dbTestPut("a", "a0"), dbTestPut("b", "b0")},
+				{dbTestPut("x", "x0")},
+			},
+			files:        [3]int{2, 2, 0},
+			output:       []string{"a@1=a0"},
+			output_level: 1,
+			model:        map[string]string{"a": "a0", "c": "c1", "d": "d1", "x": "x0"},
+			deleted:      []string{"b"},
+		},
+		{
+			name: "deletion kept over deeper levels",
+			level0: [][]dbTestOp{
+				{dbTestPut("a", "a1")},
+				{dbTestPut("b", "b1")},
+				{dbTestDelete("a")},
+				{dbTestPut("c", "c1")},
+			},
+			level2: [][]dbTestOp{
+				{dbTestPut("a", "a0")},
+			},
+			files:        [3]int{2, 1, 1},
+			output:       []string{"a@4:del"},
+			output_level: 1,
+			model:        map[string]string{"b": "b1", "c": "c1"},
+			deleted:      []string{"a"},
+		},
+		{
+			name: "trivial move",
+			level1: [][]dbTestOp{
+				{dbTestPut("a", "a1")},
+				{dbTestPut("x", "x1")},
+			},
+			seek_compact: true,
+			files:        [3]int{0, 1, 1},
+			output:       []string{"a@1=a1"},
+			output_level: 2,
+			model:        map[string]string{"a": "a1", "x": "x1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbname := t.TempDir() + "/db"
+			db, err := Open(dbname, &Options{CreateIfMissing: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			db.lock.Lock()
+			db.background_compaction_scheduled_ = true
+
+			seq := SequenceNumber(1)
+			for level, tables := range [][][]dbTestOp{tt.level2, tt.level1, tt.level0} {
+				for _, ops := range tables {
+					seq = addTestTable(t, db, 2-level, seq, ops)
+				}
+			}
+			db.versions.Finalize(db.versions.current_)
+			input_files := map[uint64]bool{}
+			for level := 0; level < 3; level++ {
+				for _, f := range db.versions.current_.files_[level] {
+					input_files[f.number] = true
+				}
+			}
+			if tt.seek_compact {
+				db.versions.current_.file_to_compact_ = db.versions.current_.files_[1][0]
+				db.versions.current_.file_to_compact_level = 1
+			}
+
+			db.BackgroundCompaction()
+			if db.bg_error != nil {
+				t.Fatal(db.bg_error)
+			}
+			current := db.versions.current_
+			for level, want := range tt.files {
+				if got := len(current.files_[level]); got != want {
+					t.Errorf("level %d has %d files, want %d", level, got, want)
+				}
+			}
+			// The output is the only file not among the inputs, except
+			// for a trivial move which keeps its file.
+			var output *FileMetaData
+			for _, f := range current.files_[tt.output_level] {
+				if !input_files[f.number] || tt.seek_compact {
+					output = f
+				}
+			}
+			if output == nil {
+				t.Fatalf("no output file at level %d", tt.output_level)
+			}
+			if got := readTestTable(t, db, output); strings.Join(got, " ") != strings.Join(tt.output, " ") {
+				t.Errorf("output = %q, want %q", got, tt.output)
+			}
+			live := map[string]bool{}
+			for level := 0; level < levelNum; level++ {
+				for _, f := range current.files_[level] {
+					live[TableFileName(dbname, f.number)] = true
+				}
+			}
+			for number := range input_files {
+				fname := TableFileName(dbname, number)
+				if _, err := os.Stat(fname); (err == nil) != live[fname] {
+					t.Errorf("%s exists: %v, live: %v", fname, err == nil, live[fname])
+				}
+			}
+			db.lock.Unlock()
+
+			checkDBContents(t, db, tt.model)
+			for _, key := range tt.deleted {
+				if got, err := db.Get(nil, []byte(key)); err == nil {
+					t.Errorf("Get(%q) = %q after deletion", key, got)
+				}
+			}
+		})
+	}
+}
+
+func TestDBVersionRefs(t *testing.T) {
+	dbname := t.TempDir() + "/db"
+	db, err := Open(dbname, &Options{CreateIfMissing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.background_compaction_scheduled_ = true
+
+	seq := SequenceNumber(1)
+	for _, key := range []string{"a", "b", "a", "b"} {
+		seq = addTestTable(t, db, 0, seq, []dbTestOp{dbTestPut(key, key)})
+	}
+	db.versions.Finalize(db.versions.current_)
+	old := db.versions.current_
+
+	// A reader pinning the old version keeps its files alive across the
+	// compaction that replaces them.
+	old.Ref()
+	db.BackgroundCompaction()
+	if db.bg_error != nil {
+		t.Fatal(db.bg_error)
+	}
+	if db.versions.current_ == old {
+		t.Fatal("compaction did not install a new version")
+	}
+	var inputs []*FileMetaData
+	for _, f := range old.files_[0] {
+		if f.refs == 1 {
+			inputs = append(inputs, f)
+		}
+	}
+	if len(inputs) != 2 {
+		t.Fatalf("%d files only referenced by the old version, want 2", len(inputs))
+	}
+	live := db.versions.AddLiveFiles()
+	for _, f := range inputs {
+		if _, ok := live[f.number]; !ok {
+			t.Errorf("file %d of a pinned version is not live", f.number)
+		}
+		if _, err := os.Stat(TableFileName(dbname, f.number)); err != nil {
+			t.Errorf("file %d of a pinned version was deleted: %v", f.number, err)
+		}
+	}
+
+	old.Unref()
+	if old.next_ != old || old.prev_ != old {
+		t.Error("unreferenced version is still linked into the version list")
+	}
+	for _, f := range inputs {
+		if f.refs != 0 {
+			t.Errorf("file %d has %d refs after its last version was released", f.number, f.refs)
+		}
+	}
+	db.DeleteObsoleteFiles()
+	for _, f := range inputs {
+		if _, err := os.Stat(TableFileName(dbname, f.number)); !os.IsNotExist(err) {
+			t.Errorf("obsolete file %d was not deleted: %v", f.number, err)
+		}
+	}
+	for _, f := range db.versions.current_.files_[1] {
+		if f.refs != 1 {
+			t.Errorf("output file %d has %d refs, want 1", f.number, f.refs)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Unref of an unreferenced version did not panic")
+		}
+	}()
+	old.Unref()
+}
+
+func TestDBSnapshot(t *testing.T) {
+	db, err := Open(t.TempDir()+"/db", &Options{CreateIfMissing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(snapshot *Snapshot, key string) string {
+		t.Helper()
+		value, err := db.Get(&ReadOptions{Snapshot: snapshot}, []byte(key))
+		if err == ErrNotFound {
+			return "NOT_FOUND"
+		} else if err != nil {
+			t.Fatalf("Get(%q): %v", key, err)
+		}
+		return string(value)
+	}
+
+	applyDBTestOps(t, db, []dbTestOp{dbTestPut("foo", "v1")}, map[string]string{})
+	s1 := db.GetSnapshot()
+	applyDBTestOps(t, db, []dbTestOp{dbTestPut("foo", "v2")}, map[string]string{})
+	s2 := db.GetSnapshot()
+	applyDBTestOps(t, db, []dbTestOp{dbTestDelete("foo"), dbTestPut("bar", "v3")}, map[string]string{})
+	s3 := db.GetSnapshot()
+
+	tests := []struct {
+		snapshot *Snapshot
+		foo, bar string
+	}{
+		{s1, "v1", "NOT_FOUND"},
+		{s2, "v2", "NOT_FOUND"},
+		{s3, "NOT_FOUND", "v3"},
+		{nil, "NOT_FOUND", "v3"},
+	}
+	for i, tt := range tests {
+		if got := get(tt.snapshot, "foo"); got != tt.foo {
+			t.Errorf("%d: foo = %q, want %q", i, got, tt.foo)
+		}
+		if got := get(tt.snapshot, "bar"); got != tt.bar {
+			t.Errorf("%d: bar = %q, want %q", i, got, tt.bar)
+		}
+	}
+	if s1.Sequence() != 1 || s2.Sequence() != 2 || s3.Sequence() != 4 {
+		t.Errorf("snapshot sequences = %d, %d, %d, want 1, 2, 4", s1.Sequence(), s2.Sequence(), s3.Sequence())
+	}
+
+	// Released snapshots leave the list in any order; the oldest live
+	// one bounds what compactions may drop.
+	db.ReleaseSnapshot(s2)
+	db.lock.Lock()
+	if db.snapshots_.oldest() != s1 || db.snapshots_.newest() != s3 {
+		t.Error("releasing the middle snapshot changed the oldest or newest")
+	}
+	db.lock.Unlock()
+	db.ReleaseSnapshot(s1)
+	db.ReleaseSnapshot(s3)
+	db.lock.Lock()
+	if !db.snapshots_.empty() {
+		t.Error("snapshot list not empty after releasing every snapshot")
+	}
+	db.lock.Unlock()
+}
+
+func TestDBCompactionKeepsSnapshots(t *testing.T) {
+	tests := []struct {
+		name     string
+		snapshot bool
+		output   []string
+	}{
+		{"without snapshot", false, []string{"a@4=a4"}},
+		{"with snapshot", true, []string{"a@4=a4", "a@3:del", "a@2=a2", "a@1=a1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, err := Open(t.TempDir()+"/db", &Options{CreateIfMissing: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			db.lock.Lock()
+			db.background_compaction_scheduled_ = true
+
+			seq := addTestTable(t, db, 0, 1, []dbTestOp{dbTestPut("a", "a1")})
+			var snapshot *Snapshot
+			if tt.snapshot {
+				snapshot = db.snapshots_.New(db.versions.LastSequence())
+			}
+			seq = addTestTable(t, db, 0, seq, []dbTestOp{dbTestPut("a", "a2")})
+			seq = addTestTable(t, db, 0, seq, []dbTestOp{dbTestDelete("a")})
+			addTestTable(t, db, 0, seq, []dbTestOp{dbTestPut("a", "a4")})
+			db.versions.Finalize(db.versions.current_)
+
+			db.BackgroundCompaction()
+			if db.bg_error != nil {
+				t.Fatal(db.bg_error)
+			}
+			var output []string
+			for _, f := range db.versions.current_.files_[1] {
+				output = append(output, readTestTable(t, db, f)...)
+			}
+			if strings.Join(output, " ") != strings.Join(tt.output, " ") {
+				t.Errorf("output = %q, want %q", output, tt.output)
+			}
+			db.lock.Unlock()
+
+			if snapshot != nil {
+				if value, err := db.Get(&ReadOptions{Snapshot: snapshot}, []byte("a")); err != nil || string(value) != "a1" {
+					t.Errorf("Get(a) at snapshot = (%q, %v), want a1", value, err)
+				}
+				db.ReleaseSnapshot(snapshot)
+			}
+			if value, err := db.Get(nil, []byte("a")); err != nil || string(value) != "a4" {
+				t.Errorf("Get(a) = (%q, %v), want a4", value, err)
+			}
+		})
+	}
+}
+
+func TestDBIterator(t *testing.T) {
+	db, err := Open(t.TempDir()+"/db", &Options{CreateIfMissing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The entries are spread over a level-1 table, a level-0 table and
+	// the memtable, newer data hiding older data for the same key.
+	db.lock.Lock()
+	db.background_compaction_scheduled_ = true
+	seq := addTestTable(t, db, 1, 1, []dbTestOp{
+		dbTestPut("a", "a0"), dbTestPut("c", "c0"), dbTestPut("e", "e0"), dbTestPut("g", "g0"),
+	})
+	addTestTable(t, db, 0, seq, []dbTestOp{dbTestDelete("c"), dbTestPut("d", "d1")})
+	db.versions.Finalize(db.versions.current_)
+	db.lock.Unlock()
+	snapshot := db.GetSnapshot()
+	defer db.ReleaseSnapshot(snapshot)
+	applyDBTestOps(t, db, []dbTestOp{dbTestPut("e", "e2"), dbTestPut("b", "b2"), dbTestDelete("g")}, map[string]string{})
+
+	entry := func(iter Iterator) string {
+		if !iter.Valid() {
+			return "(invalid)"
+		}
+		return string(iter.Key()) + "=" + string(iter.Value())
+	}
+	scan := func(options *ReadOptions) (forward, backward []string) {
+		iter := db.NewIterator(options)
+		defer iter.Close()
+		for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+			forward = append(forward, entry(iter))
+		}
+		for iter.SeekToLast(); iter.Valid(); iter.Prev() {
+			backward = append(backward, entry(iter))
+		}
+		if err := iter.Error(); err != nil {
+			t.Error(err)
+		}
+		return forward, backward
+	}
+
+	scans := []struct {
+		name    string
+		options *ReadOptions
+		want    []string
+	}{
+		{"latest", nil, []string{"a=a0", "b=b2", "d=d1", "e=e2"}},
+		{"snapshot", &ReadOptions{Snapshot: snapshot}, []string{"a=a0", "d=d1", "e=e0", "g=g0"}},
+	}
+	for _, tt := range scans {
+		forward, backward := scan(tt.options)
+		if strings.Join(forward, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%s: forward scan = %q, want %q", tt.name, forward, tt.want)
+		}
+		for i, j := 0, len(backward)-1; i < j; i, j = i+1, j-1 {
+			backward[i], backward[j] = backward[j], backward[i]
+		}
+		if strings.Join(backward, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%s: backward scan = %q, want reverse of %q", tt.name, backward, tt.want)
+		}
+	}
+
+	seek := func(target string) func(Iterator) {
+		return func(iter Iterator) { iter.Seek([]byte(target)) }
+	}
+	next := func(iter Iterator) { iter.Next() }
+	prev := func(iter Iterator) { iter.Prev() }
+	first := func(iter Iterator) { iter.SeekToFirst() }
+	last := func(iter Iterator) { iter.SeekToLast() }
+	moves := []struct {
+		name  string
+		steps []func(Iterator)
+		want  []string // entry after each step
+	}{
+		{"seek to existing key", []func(Iterator){seek("b")}, []string{"b=b2"}},
+		{"seek to deleted key", []func(Iterator){seek("c")}, []string{"d=d1"}},
+		{"seek before first", []func(Iterator){seek("")}, []string{"a=a0"}},
+		{"seek past deleted last", []func(Iterator){seek("f")}, []string{"(invalid)"}},
+		{"seek past last", []func(Iterator){seek("z")}, []string{"(invalid)"}},
+		{
+			"switch directions",
+			[]func(Iterator){seek("d"), prev, next, next, prev, prev, prev},
+			[]string{"d=d1", "b=b2", "d=d1", "e=e2", "d=d1", "b=b2", "a=a0"},
+		},
+		{"prev from first", []func(Iterator){first, prev}, []string{"a=a0", "(invalid)"}},
+		{"next from last", []func(Iterator){last, next}, []string{"e=e2", "(invalid)"}},
+		{
+			"switch directions at the ends",
+			[]func(Iterator){last, prev, next, first, next, prev},
+			[]string{"e=e2", "d=d1", "e=e2", "a=a0", "b=b2", "a=a0"},
+		},
+	}
+	for _, tt := range moves {
+		iter := db.NewIterator(nil)
+		if iter.Valid() {
+			t.Errorf("%s: new iterator is valid", tt.name)
+		}
+		var got []string
+		for _, step := range tt.steps {
+			step(iter)
+			got = append(got, entry(iter))
+		}
+		if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%s: entries = %q, want %q", tt.name, got, tt.want)
+		}
+		iter.Close()
+	}
+}
+
+func TestDBIteratorBounds(t *testing.T) {
+	db, err := Open(t.TempDir()+"/db", &Options{CreateIfMissing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.lock.Lock()
+	db.background_compaction_scheduled_ = true
+	seq := addTestTable(t, db, 1, 1, []dbTestOp{
+		dbTestPut("a", "1"), dbTestPut("ab", "1"), dbTestPut("abc", "1"), dbTestPut("b", "1"),
+	})
+	addTestTable(t, db, 0, seq, []dbTestOp{dbTestPut("ab\xff", "2"), dbTestDelete("abc"), dbTestPut("ac", "2")})
+	db.versions.Finalize(db.versions.current_)
+	db.lock.Unlock()
+	applyDBTestOps(t, db, []dbTestOp{
+		dbTestPut("aa", "3"), dbTestPut("abd", "3"), dbTestPut("\xff", "3"), dbTestPut("\xff\xff", "3"),
+	}, map[string]string{})
+
+	keys := func(iter Iterator) (forward, backward []string) {
+		defer iter.Close()
+		for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+			forward = append(forward, string(iter.Key()))
+		}
+		for iter.SeekToLast(); iter.Valid(); iter.Prev() {
+			backward = append([]string{string(iter.Key())}, backward...)
+		}
+		if err := iter.Error(); err != nil {
+			t.Error(err)
+		}
+		return forward, backward
+	}
+	check := func(name string, iter Iterator, want []string) {
+		t.Helper()
+		forward, backward := keys(iter)
+		if strings.Join(forward, " ") != strings.Join(want, " ") {
+			t.Errorf("%s: forward keys = %q, want %q", name, forward, want)
+		}
+		if strings.Join(backward, " ") != strings.Join(want, " ") {
+			t.Errorf("%s: backward keys = %q, want %q", name, backward, want)
+		}
+	}
+
+	bounds := []struct {
+		lower, upper string // "" for no bound
+		want         []string
+	}{
+		{"", "", []string{"a", "aa", "ab", "abd", "ab\xff", "ac", "b", "\xff", "\xff\xff"}},
+		{"ab", "", []string{"ab", "abd", "ab\xff", "ac", "b", "\xff", "\xff\xff"}},
+		{"", "ac", []string{"a", "aa", "ab", "abd", "ab\xff"}},
+		{"aa", "abd", []string{"aa", "ab"}},
+		{"abc", "abd", nil},
+		{"c", "d", nil},
+		{"b", "a", nil},
+	}
+	for _, tt := range bounds {
+		options := NewReadOptions()
+		if tt.lower != "" {
+			options.LowerBound = []byte(tt.lower)
+		}
+		if tt.upper != "" {
+			options.UpperBound = []byte(tt.upper)
+		}
+		check(fmt.Sprintf("[%q, %q)", tt.lower, tt.upper), db.NewIterator(options), tt.want)
+
+		// Seek never leaves the bounds.
+		iter := db.NewIterator(options)
+		iter.Seek([]byte(""))
+		if len(tt.want) == 0 {
+			if iter.Valid() {
+				t.Errorf("[%q, %q): Seek landed on %q", tt.lower, tt.upper, iter.Key())
+			}
+		} else if !iter.Valid() || string(iter.Key()) != tt.want[0] {
+			t.Errorf("[%q, %q): Seek before the lower bound did not land on %q", tt.lower, tt.upper, tt.want[0])
+		}
+		iter.Close()
+	}
+
+	prefixes := []struct {
+		prefix string
+		want   []string
+	}{
+		{"ab", []string{"ab", "abd", "ab\xff"}},
+		{"ab\xff", []string{"ab\xff"}},
+		{"a", []string{"a", "aa", "ab", "abd", "ab\xff", "ac"}},
+		{"\xff", []string{"\xff", "\xff\xff"}},
+		{"abc", nil},
+		{"", []string{"a", "aa", "ab", "abd", "ab\xff", "ac", "b", "\xff", "\xff\xff"}},
+	}
+	for _, tt := range prefixes {
+		check(fmt.Sprintf("prefix %q", tt.prefix), db.PrefixIterator(nil, []byte(tt.prefix)), tt.want)
+	}
+	// The bounds of the options narrow the prefix further.
+	check("prefix with bounds", db.PrefixIterator(&ReadOptions{LowerBound: []byte("aa"), UpperBound: []byte("abd")}, []byte("a")),
+		[]string{"aa", "ab"})
+}
+
+func TestPrefixSuccessor(t *testing.T) {
+	tests := []struct {
+		prefix string
+		want   []byte
+	}{
+		{"", nil},
+		{"a", []byte("b")},
+		{"ab", []byte("ac")},
+		{"a\xff", []byte("b")},
+		{"a\xff\xff", []byte("b")},
+		{"\xff\xff", nil},
+	}
+	for _, tt := range tests {
+		if got := prefixSuccessor([]byte(tt.prefix)); !bytes.Equal(got, tt.want) {
+			t.Errorf("prefixSuccessor(%q) = %q, want %q", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+// recordingFilterPolicy wraps a filter policy and records the keys it
+// is asked about.
+type recordingFilterPolicy struct {
+	utils.FilterPolicy
+	probes []string
+}
+
+func (p *recordingFilterPolicy) KeyMayMatch(key []byte, filter []byte) bool {
+	p.probes = append(p.probes, string(key))
+	return p.FilterPolicy.KeyMayMatch(key, filter)
+}
+
+func TestDBFilterPolicy(t *testing.T) {
+	policy := &recordingFilterPolicy{FilterPolicy: utils.NewBloomFilterPolicy(10)}
+	db, err := Open(t.TempDir()+"/db", &Options{CreateIfMissing: true, FilterPolicy: policy})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 1000
+	var ops []dbTestOp
+	model := map[string]string{}
+	for i := 0; i < n; i += 1 {
+		key := fmt.Sprintf("key%04d", 2*i)
+		ops = append(ops, dbTestPut(key, "v"+key))
+		model[key] = "v" + key
+	}
+	db.lock.Lock()
+	db.background_compaction_scheduled_ = true
+	addTestTable(t, db, 1, 1, ops)
+	db.versions.Finalize(db.versions.current_)
+	db.lock.Unlock()
+
+	checkDBContents(t, db, model)
+	if len(policy.probes) != n {
+		t.Errorf("%d filter probes for %d lookups", len(policy.probes), n)
+	}
+	// The filters are built on, and probed with, the user keys.
+	for _, probe := range policy.probes {
+		if _, ok := model[probe]; !ok {
+			t.Fatalf("filter probed with %q, not a user key", probe)
+		}
+	}
+	for i := 0; i < n; i += 1 {
+		key := fmt.Sprintf("key%04d", 2*i+1)
+		if _, err := db.Get(nil, []byte(key)); err != ErrNotFound {
+			t.Fatalf("Get(%q) = %v, want ErrNotFound", key, err)
+		}
+	}
+}
+
+func TestDBCompression(t *testing.T) {
+	value := strings.Repeat("0123456789", 100)
+	var ops []dbTestOp
+	model := map[string]string{}
+	for i := 0; i < 100; i += 1 {
+		key := fmt.Sprintf("key%03d", i)
+		ops = append(ops, dbTestPut(key, value))
+		model[key] = value
+	}
+	sizes := map[CompressionType]uint64{}
+	for _, compression := range []CompressionType{NoCompression, SnappyCompression, ZstdCompression} {
+		db, err := Open(t.TempDir()+"/db", &Options{CreateIfMissing: true, Compression: compression})
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.lock.Lock()
+		db.background_compaction_scheduled_ = true
+		addTestTable(t, db, 1, 1, ops)
+		db.versions.Finalize(db.versions.current_)
+		sizes[compression] = db.versions.current_.files_[1][0].file_size
+		db.lock.Unlock()
+		checkDBContents(t, db, model)
+	}
+	for _, compression := range []CompressionType{SnappyCompression, ZstdCompression} {
+		if sizes[compression] >= sizes[NoCompression]/4 {
+			t.Errorf("compression %d: table is %d bytes, %d uncompressed", compression, sizes[compression], sizes[NoCompression])
+		}
+	}
+}
+
+func TestDBBackgroundWork(t *testing.T) {
+	dbname := t.TempDir() + "/db"
+	options := &Options{CreateIfMissing: true, WriteBufferSize: 64 << 10}
+	db, err := Open(dbname, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Enough data to fill the memtable many times over: the background
+	// work flushes it to level 0 and compacts the level-0 files.
+	model := map[string]string{}
+	value := strings.Repeat("v", 200)
+	for i := 0; i < 5000; i += 1 {
+		key := fmt.Sprintf("key%06d", i*7919%5000)
+		if err := db.Put(nil, []byte(key), []byte(value+key)); err != nil {
+			t.Fatal(err)
+		}
+		model[key] = value + key
+	}
+	for i := 0; i < 5000; i += 3 {
+		key := fmt.Sprintf("key%06d", i)
+		if err := db.Delete(nil, []byte(key)); err != nil {
+			t.Fatal(err)
+		}
+		delete(model, key)
+	}
+	checkDBContents(t, db, model)
+
+	// CompactRange pushes everything out of the memtable and level 0.
+	if err := db.CompactRange(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	db.lock.Lock()
+	for db.background_compaction_scheduled_ {
+		db.background_work_finished_signal_.Wait()
+	}
+	if db.bg_error != nil {
+		t.Error(db.bg_error)
+	}
+	files := 0
+	for level := 0; level < levelNum; level += 1 {
+		files += db.versions.NumLevelFiles(level)
+	}
+	if n := db.versions.NumLevelFiles(0); n != 0 || files == 0 {
+		t.Errorf("%d level-0 files of %d after CompactRange, want 0 of some", n, files)
+	}
+	db.lock.Unlock()
+	checkDBContents(t, db, model)
+
+	iter := db.NewIterator(nil)
+	n := 0
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		if model[string(iter.Key())] != string(iter.Value()) {
+			t.Fatalf("iterator entry %q = %q", iter.Key(), iter.Value())
+		}
+		n += 1
+	}
+	iter.Close()
+	if n != len(model) {
+		t.Errorf("iterator saw %d entries, want %d", n, len(model))
+	}
+}
+
+// openDBFiles returns the number of files under dbname the process
+// holds open.
+func openDBFiles(t *testing.T, dbname string) int {
+	t.Helper()
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("cannot list the open files:", err)
+	}
+	n := 0
+	for _, fd := range fds {
+		target, err := os.Readlink("/proc/self/fd/" + fd.Name())
+		if err == nil && strings.HasPrefix(target, dbname+"/") {
+			n += 1
+		}
+	}
+	return n
+}
+
+func TestDBClose(t *testing.T) {
+	tests := []struct {
+		name  string
+		puts  int
+		value int
+	}{
+		{"empty", 0, 0},
+		{"memtable only", 10, 10},
+		// Close while the background work flushes and compacts.
+		{"during compactions", 3000, 1000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbname := t.TempDir() + "/db"
+			options := &Options{CreateIfMissing: true, WriteBufferSize: 64 << 10}
+			db, err := Open(dbname, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			model := map[string]string{}
+			for i := 0; i < tt.puts; i += 1 {
+				key := fmt.Sprintf("key%06d", i)
+				value := fmt.Sprintf("%0*d", tt.value, i)
+				if err := db.Put(nil, []byte(key), []byte(value)); err != nil {
+					t.Fatal(err)
+				}
+				model[key] = value
+			}
+			if err := db.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+			if n := openDBFiles(t, dbname); n != 0 {
+				t.Errorf("%d files of the DB still open after Close", n)
+			}
+			if err := db.Close(); err != nil {
+				t.Errorf("second Close: %v", err)
+			}
+
+			db, err = Open(dbname, options)
+			if err != nil {
+				t.Fatalf("reopen: %v", err)
+			}
+			checkDBContents(t, db, model)
+			if err := db.Close(); err != nil {
+				t.Errorf("Close after reopen: %v", err)
+			}
+			if n := openDBFiles(t, dbname); n != 0 {
+				t.Errorf("%d files of the DB still open after the second Close", n)
+			}
+		})
+	}
+}
+
+// unlockHookEnv calls on_unlock before it releases a lock.
+type unlockHookEnv struct {
+	env.Env
+	on_unlock func()
+}
+
+func (e *unlockHookEnv) UnlockFile(lock env.FileLock) error {
+	e.on_unlock()
+	return e.Env.UnlockFile(lock)
+}
+
+func TestDBCloseUnlocksLast(t *testing.T) {
+	dbname := t.TempDir() + "/db"
+	unlocked := false
+	options := &Options{CreateIfMissing: true, WriteBufferSize: 64 << 10}
+	options.Env = &unlockHookEnv{Env: env.Default(), on_unlock: func() {
+		unlocked = true
+		// Only the LOCK file itself is left open.
+		if n := openDBFiles(t, dbname); n != 1 {
+			t.Errorf("%d files of the DB open when the lock is released, want 1", n)
+		}
+	}}
+	db, err := Open(dbname, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i += 1 {
+		if err := db.Put(nil, []byte(fmt.Sprintf("key%06d", i)), bytes.Repeat([]byte("v"), 1000)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !unlocked {
+		t.Error("Close did not release the lock")
+	}
+}
@@ -1,17 +1,158 @@
 package leveldb
 
+import (
+	"fmt"
+
+	"github.com/lemonwx/goleveldb/leveldb/utils"
+)
+
+type ValueType byte
+
+// Value types encoded as the last component of internal keys.
+// DO NOT CHANGE THESE ENUM VALUES: they are embedded in the on-disk
+// data structures.
+const (
+	kTypeDeletion ValueType = 0x0
+	kTypeValue    ValueType = 0x1
+)
+
+// kValueTypeForSeek defines the ValueType that should be passed when
+// constructing a ParsedInternalKey object for seeking to a particular
+// sequence number (since we sort sequence numbers in decreasing order
+// and the value type is embedded as the low 8 bits in the sequence
+// number in internal keys, we need to use the highest-numbered
+// ValueType, not the lowest).
+const kValueTypeForSeek = kTypeValue
+
+// We leave eight bits empty at the bottom so a type and sequence#
+// can be packed together into 64-bits.
+const kMaxSequenceNumber = SequenceNumber((uint64(1) << 56) - 1)
+
+func PackSequenceAndType(seq SequenceNumber, t ValueType) uint64 {
+	return (uint64(seq) << 8) | uint64(t)
+}
+
+type ParsedInternalKey struct {
+	user_key string
+	sequence SequenceNumber
+	Type     ValueType
+}
+
+func AppendInternalKey(dst *[]byte, key *ParsedInternalKey) {
+	*dst = append(*dst, key.user_key...)
+	utils.PutFixed64(dst, PackSequenceAndType(key.sequence, key.Type))
+}
+
+// ParseInternalKey attempts to parse an internal key,
+// returns false if the key can not be parsed.
+func ParseInternalKey(internal_key string) (*ParsedInternalKey, bool) {
+	n := len(internal_key)
+	if n < 8 {
+		return nil, false
+	}
+	num := utils.DecodeFixed64([]byte(internal_key[n-8:]))
+	c := ValueType(num & 0xff)
+	result := &ParsedInternalKey{
+		user_key: internal_key[:n-8],
+		sequence: SequenceNumber(num >> 8),
+		Type:     c,
+	}
+	return result, c <= kTypeValue
+}
+
 type InternalKey struct {
 	rep string
 }
 
+func NewInternalKey(user_key string, s SequenceNumber, t ValueType) *InternalKey {
+	buf := []byte{}
+	AppendInternalKey(&buf, &ParsedInternalKey{user_key: user_key, sequence: s, Type: t})
+	return &InternalKey{rep: string(buf)}
+}
+
 func (ik *InternalKey) Encode() string {
-	return ""
+	return ik.rep
+}
+
+func (ik *InternalKey) user_key() string {
+	return utils.ExtractUserKey(ik.rep)
 }
 
 func (ik *InternalKey) String() string {
 	return ik.rep
 }
 
+// DebugString returns a human readable form of the key, for logging.
+func (ik *InternalKey) DebugString() string {
+	parsed, ok := ParseInternalKey(ik.rep)
+	if !ok {
+		return fmt.Sprintf("(bad)%q", ik.rep)
+	}
+	return fmt.Sprintf("%q @ %d : %d", parsed.user_key, parsed.sequence, parsed.Type)
+}
+
 func (ik *InternalKey) DecodeFrom(s string) {
 	ik.rep = s
 }
+
+// InternalFilterPolicy is a filter policy wrapper that converts from
+// internal keys to user keys.
+type InternalFilterPolicy struct {
+	user_policy_ utils.FilterPolicy
+}
+
+func NewInternalFilterPolicy(p utils.FilterPolicy) *InternalFilterPolicy {
+	return &InternalFilterPolicy{user_policy_: p}
+}
+
+func (p *InternalFilterPolicy) Name() string {
+	return p.user_policy_.Name()
+}
+
+func (p *InternalFilterPolicy) CreateFilter(keys [][]byte, dst *[]byte) {
+	user_keys := make([][]byte, len(keys))
+	for i, key := range keys {
+		user_keys[i] = key[:len(key)-8]
+	}
+	p.user_policy_.CreateFilter(user_keys, dst)
+}
+
+func (p *InternalFilterPolicy) KeyMayMatch(key []byte, f []byte) bool {
+	return p.user_policy_.KeyMayMatch(key[:len(key)-8], f)
+}
+
+// LookupKey is a helper for DBImpl.Get, it holds the user key
+// in all three encodings the lookup path needs:
+//
+//	klength  varint32               <-- start_
+//	userkey  char[klength]          <-- kstart_
+//	tag      uint64
+//	                                <-- end_
+type LookupKey struct {
+	rep     string
+	kstart_ int
+}
+
+func NewLookupKey(user_key []byte, s SequenceNumber) *LookupKey {
+	buf := make([]byte, 0, len(user_key)+13)
+	utils.PutVarint32(&buf, uint32(len(user_key)+8))
+	kstart := len(buf)
+	buf = append(buf, user_key...)
+	utils.PutFixed64(&buf, PackSequenceAndType(s, kValueTypeForSeek))
+	return &LookupKey{rep: string(buf), kstart_: kstart}
+}
+
+// memtable_key returns a key suitable for lookup in a MemTable.
+func (lk *LookupKey) memtable_key() string {
+	return lk.rep
+}
+
+// internal_key returns an internal key (suitable for passing to an internal iterator)
+func (lk *LookupKey) internal_key() string {
+	return lk.rep[lk.kstart_:]
+}
+
+// user_key returns the user key
+func (lk *LookupKey) user_key() string {
+	return lk.rep[lk.kstart_ : len(lk.rep)-8]
+}
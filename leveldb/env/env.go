@@ -0,0 +1,206 @@
+package env
+
+import (
+	"io"
+)
+
+// An Env is an interface used by the leveldb implementation to access
+// operating system functionality like the filesystem etc.  Callers
+// may wish to provide a custom Env object when opening a database to
+// get fine grain control; e.g., to rate limit file system operations.
+//
+// All Env implementations are safe for concurrent access from
+// multiple goroutines without any external synchronization.
+type Env interface {
+	// Create an object that sequentially reads the file with the
+	// specified name.  On failure returns a non-nil error.  If the file
+	// does not exist, the error satisfies os.IsNotExist.
+	//
+	// The returned file will only be accessed by one goroutine at a time.
+	NewSequentialFile(fname string) (SequentialFile, error)
+
+	// Create an object supporting random-access reads from the file
+	// with the specified name.  On failure returns a non-nil error.  If
+	// the file does not exist, the error satisfies os.IsNotExist.
+	//
+	// The returned file may be concurrently accessed by multiple
+	// goroutines.
+	NewRandomAccessFile(fname string) (RandomAccessFile, error)
+
+	// Create an object that writes to a new file with the specified
+	// name.  Deletes any existing file with the same name and creates a
+	// new file.  On failure returns a non-nil error.
+	//
+	// The returned file will only be accessed by one goroutine at a time.
+	NewWritableFile(fname string) (WritableFile, error)
+
+	// Create an object that either appends to an existing file, or
+	// writes to a new file (if the file does not exist to begin with).
+	// On failure returns a non-nil error.
+	//
+	// The returned file will only be accessed by one goroutine at a time.
+	NewAppendableFile(fname string) (WritableFile, error)
+
+	// Returns true iff the named file exists.
+	FileExists(fname string) bool
+
+	// Return the names of the children of the specified directory.
+	// The names are relative to "dir".
+	GetChildren(dir string) ([]string, error)
+
+	// Delete the named file.
+	DeleteFile(fname string) error
+
+	// Create the specified directory.
+	CreateDir(dirname string) error
+
+	// Delete the specified directory.
+	DeleteDir(dirname string) error
+
+	// Return the size of fname.
+	GetFileSize(fname string) (uint64, error)
+
+	// Rename file src to target.
+	RenameFile(src, target string) error
+
+	// Lock the specified file.  Used to prevent concurrent access to
+	// the same db by multiple processes.  On failure returns a non-nil
+	// error.
+	//
+	// On success, returns a handle that represents the acquired lock.
+	// The caller should call UnlockFile(lock) to release the lock.  If
+	// the process exits, the lock will be automatically released.
+	//
+	// If somebody else already holds the lock, finishes immediately
+	// with a failure.  I.e., this call does not wait for existing locks
+	// to go away.
+	//
+	// May create the named file if it does not already exist.
+	LockFile(fname string) (FileLock, error)
+
+	// Release the lock acquired by a previous successful call to
+	// LockFile.
+	// REQUIRES: lock was returned by a successful LockFile() call
+	// REQUIRES: lock has not already been unlocked.
+	UnlockFile(lock FileLock) error
+
+	// Arrange to run f once in a background goroutine.
+	//
+	// f may run in an unspecified goroutine.  Multiple functions added
+	// to the same Env may run concurrently in different goroutines.
+	// I.e., the caller may not assume that background work items are
+	// serialized.
+	Schedule(f func())
+
+	// Start a new goroutine, invoking f.  When f returns, the goroutine
+	// will be destroyed.
+	StartThread(f func())
+
+	// Returns the number of micro-seconds since some fixed point in
+	// time.  Only useful for computing deltas of time.
+	NowMicros() uint64
+
+	// Sleep/delay the goroutine for the prescribed number of
+	// micro-seconds.
+	SleepForMicroseconds(micros int)
+}
+
+// A file abstraction for reading sequentially through a file.
+type SequentialFile interface {
+	// Read up to "n" bytes from the file.  "scratch" must have length
+	// "n", it may be used to hold the data read.  Returns the data
+	// read, which may be shorter than "n" only at the end of the file.
+	//
+	// REQUIRES: External synchronization
+	Read(n int, scratch []byte) ([]byte, error)
+
+	// Skip "n" bytes from the file. This is guaranteed to be no
+	// slower that reading the same data, but may be faster.
+	//
+	// If end of file is reached, skipping will stop at the end of the
+	// file, and Skip will return nil.
+	//
+	// REQUIRES: External synchronization
+	Skip(n uint64) error
+
+	io.Closer
+}
+
+// A file abstraction for randomly reading the contents of a file.
+type RandomAccessFile interface {
+	// ReadAt reads len(p) bytes starting at offset off, a short read
+	// returns the bytes read along with io.EOF.
+	//
+	// Safe for concurrent use by multiple goroutines.
+	ReadAt(p []byte, off int64) (int, error)
+
+	io.Closer
+}
+
+// A file abstraction for sequential writing.  The implementation
+// must provide buffering since callers may append small fragments
+// at a time to the file.
+type WritableFile interface {
+	Append(data []byte) error
+	Close() error
+	Flush() error
+	Sync() error
+}
+
+// Identifies a locked file.
+type FileLock interface {
+	// Name of the locked file.
+	Name() string
+}
+
+// A utility routine: write "data" to the named file.
+func WriteStringToFile(env Env, data string, fname string) error {
+	return DoWriteStringToFile(env, data, fname, false)
+}
+
+// A utility routine: write "data" to the named file and Sync() it.
+func WriteStringToFileSync(env Env, data string, fname string) error {
+	return DoWriteStringToFile(env, data, fname, true)
+}
+
+func DoWriteStringToFile(env Env, data string, fname string, should_sync bool) error {
+	file, err := env.NewWritableFile(fname)
+	if err != nil {
+		return err
+	}
+	err = file.Append([]byte(data))
+	if err == nil && should_sync {
+		err = file.Sync()
+	}
+	if close_err := file.Close(); err == nil {
+		err = close_err
+	}
+	if err != nil {
+		env.DeleteFile(fname)
+	}
+	return err
+}
+
+// A utility routine: read contents of named file into a string.
+func ReadFileToString(env Env, fname string) (string, error) {
+	file, err := env.NewSequentialFile(fname)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	const kBufferSize = 8192
+	space := make([]byte, kBufferSize)
+	var data []byte
+	for {
+		fragment, err := file.Read(kBufferSize, space)
+		if err != nil {
+			return "", err
+		}
+		if len(fragment) == 0 {
+			break
+		}
+		data = append(data, fragment...)
+	}
+	return string(data), nil
+}
@@ -0,0 +1,369 @@
+package env
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lemonwx/log"
+)
+
+const kWritableFileBufferSize = 65536
+
+// Implements sequential read access in a file using read().
+type posixSequentialFile struct {
+	file_ *os.File
+}
+
+func (f *posixSequentialFile) Read(n int, scratch []byte) ([]byte, error) {
+	if len(scratch) != n {
+		err := errors.New("unexpected read size not equal with lens of buffer")
+		log.Error(err)
+		return nil, err
+	}
+	// A short read is not an error, it means the end of file was reached.
+	read, err := io.ReadFull(f.file_, scratch)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		log.Errorf("read file: %s failed: %v", f.file_.Name(), err)
+		return nil, err
+	}
+	result := make([]byte, read)
+	copy(result, scratch)
+	return result, nil
+}
+
+func (f *posixSequentialFile) Skip(n uint64) error {
+	if _, err := f.file_.Seek(int64(n), io.SeekCurrent); err != nil {
+		log.Errorf("skip file: %s failed: %v", f.file_.Name(), err)
+		return err
+	}
+	return nil
+}
+
+func (f *posixSequentialFile) Close() error {
+	return f.file_.Close()
+}
+
+// Implements random read access in a file using pread().
+type posixRandomAccessFile struct {
+	file_ *os.File
+}
+
+func (f *posixRandomAccessFile) ReadAt(p []byte, off int64) (int, error) {
+	n, err := f.file_.ReadAt(p, off)
+	if err != nil && err != io.EOF {
+		log.Errorf("read file: %s at: %d failed: %v", f.file_.Name(), off, err)
+	}
+	return n, err
+}
+
+func (f *posixRandomAccessFile) Close() error {
+	return f.file_.Close()
+}
+
+type posixWritableFile struct {
+	// buf_ holds data that must be written to file_.
+	buf_         []byte
+	file_        *os.File
+	is_manifest_ bool // True if the file's name starts with MANIFEST.
+}
+
+func newPosixWritableFile(file *os.File) *posixWritableFile {
+	return &posixWritableFile{
+		buf_:         make([]byte, 0, kWritableFileBufferSize),
+		file_:        file,
+		is_manifest_: strings.HasPrefix(filepath.Base(file.Name()), "MANIFEST"),
+	}
+}
+
+func (f *posixWritableFile) Append(data []byte) error {
+	// Fit as much as possible into buffer.
+	copy_size := kWritableFileBufferSize - len(f.buf_)
+	if copy_size > len(data) {
+		copy_size = len(data)
+	}
+	f.buf_ = append(f.buf_, data[:copy_size]...)
+	data = data[copy_size:]
+	if len(data) == 0 {
+		return nil
+	}
+
+	// Can't fit rest of data in buffer, need to do at least one write.
+	if err := f.FlushBuffer(); err != nil {
+		return err
+	}
+
+	// Small writes go to buffer, large writes are written directly.
+	if len(data) < kWritableFileBufferSize {
+		f.buf_ = append(f.buf_, data...)
+		return nil
+	}
+	return f.WriteUnbuffered(data)
+}
+
+func (f *posixWritableFile) Close() error {
+	err := f.FlushBuffer()
+	if close_err := f.file_.Close(); err == nil {
+		err = close_err
+	}
+	return err
+}
+
+func (f *posixWritableFile) Flush() error {
+	return f.FlushBuffer()
+}
+
+func (f *posixWritableFile) Sync() error {
+	// Ensure new files referred to by the manifest are in the filesystem.
+	//
+	// This needs to happen before the manifest file is flushed to disk, to
+	// avoid crashing in a state where the manifest refers to files that are
+	// not yet on disk.
+	if err := f.SyncDirIfManifest(); err != nil {
+		return err
+	}
+	if err := f.FlushBuffer(); err != nil {
+		return err
+	}
+	if err := f.file_.Sync(); err != nil {
+		log.Errorf("sync file: %s failed: %v", f.file_.Name(), err)
+		return err
+	}
+	return nil
+}
+
+func (f *posixWritableFile) FlushBuffer() error {
+	err := f.WriteUnbuffered(f.buf_)
+	f.buf_ = f.buf_[:0]
+	return err
+}
+
+func (f *posixWritableFile) WriteUnbuffered(data []byte) error {
+	if _, err := f.file_.Write(data); err != nil {
+		log.Errorf("write to %s failed: %v", f.file_.Name(), err)
+		return err
+	}
+	return nil
+}
+
+func (f *posixWritableFile) SyncDirIfManifest() error {
+	if !f.is_manifest_ {
+		return nil
+	}
+	dir, err := os.Open(filepath.Dir(f.file_.Name()))
+	if err != nil {
+		return err
+	}
+	err = dir.Sync()
+	if close_err := dir.Close(); err == nil {
+		err = close_err
+	}
+	return err
+}
+
+// Instances are returned by LockFile() and released by UnlockFile().
+type posixFileLock struct {
+	file_     *os.File
+	filename_ string
+}
+
+func (l *posixFileLock) Name() string {
+	return l.filename_
+}
+
+// Tracks the files locked by posixEnv.LockFile().
+//
+// We maintain a separate set instead of relying on fcntl(F_SETLK) because
+// fcntl(F_SETLK) does not provide any protection against multiple uses from
+// the same process.
+type posixLockTable struct {
+	mu_           sync.Mutex
+	locked_files_ map[string]struct{}
+}
+
+func (t *posixLockTable) Insert(fname string) bool {
+	t.mu_.Lock()
+	defer t.mu_.Unlock()
+	if _, ok := t.locked_files_[fname]; ok {
+		return false
+	}
+	t.locked_files_[fname] = struct{}{}
+	return true
+}
+
+func (t *posixLockTable) Remove(fname string) {
+	t.mu_.Lock()
+	defer t.mu_.Unlock()
+	delete(t.locked_files_, fname)
+}
+
+type posixEnv struct {
+	locks_ posixLockTable
+}
+
+func (e *posixEnv) NewSequentialFile(fname string) (SequentialFile, error) {
+	f, err := os.OpenFile(fname, os.O_RDONLY, 0644)
+	if err != nil {
+		log.Errorf("open file: %s failed: %v", fname, err)
+		return nil, err
+	}
+	return &posixSequentialFile{file_: f}, nil
+}
+
+func (e *posixEnv) NewRandomAccessFile(fname string) (RandomAccessFile, error) {
+	f, err := os.OpenFile(fname, os.O_RDONLY, 0644)
+	if err != nil {
+		log.Errorf("open file: %s failed: %v", fname, err)
+		return nil, err
+	}
+	return &posixRandomAccessFile{file_: f}, nil
+}
+
+func (e *posixEnv) NewWritableFile(fname string) (WritableFile, error) {
+	f, err := os.OpenFile(fname, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		log.Errorf("open file: %s failed: %v", fname, err)
+		return nil, err
+	}
+	return newPosixWritableFile(f), nil
+}
+
+func (e *posixEnv) NewAppendableFile(fname string) (WritableFile, error) {
+	f, err := os.OpenFile(fname, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		log.Errorf("open file: %s failed: %v", fname, err)
+		return nil, err
+	}
+	return newPosixWritableFile(f), nil
+}
+
+func (e *posixEnv) FileExists(fname string) bool {
+	_, err := os.Stat(fname)
+	return err == nil
+}
+
+func (e *posixEnv) GetChildren(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, entry.Name())
+	}
+	return result, nil
+}
+
+func (e *posixEnv) DeleteFile(fname string) error {
+	if err := os.Remove(fname); err != nil {
+		log.Errorf("remove file: %s failed: %v", fname, err)
+		return err
+	}
+	return nil
+}
+
+func (e *posixEnv) CreateDir(dirname string) error {
+	return os.Mkdir(dirname, 0755)
+}
+
+func (e *posixEnv) DeleteDir(dirname string) error {
+	if err := syscall.Rmdir(dirname); err != nil {
+		return &os.PathError{Op: "rmdir", Path: dirname, Err: err}
+	}
+	return nil
+}
+
+func (e *posixEnv) GetFileSize(fname string) (uint64, error) {
+	info, err := os.Stat(fname)
+	if err != nil {
+		log.Errorf("stat file: %s failed: %v", fname, err)
+		return 0, err
+	}
+	return uint64(info.Size()), nil
+}
+
+func (e *posixEnv) RenameFile(src, target string) error {
+	if err := os.Rename(src, target); err != nil {
+		log.Errorf("rename from: %s to: %s failed: %v", src, target, err)
+		return err
+	}
+	return nil
+}
+
+func (e *posixEnv) LockFile(fname string) (FileLock, error) {
+	f, err := os.OpenFile(fname, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if !e.locks_.Insert(fname) {
+		f.Close()
+		return nil, fmt.Errorf("lock %s: already held by process", fname)
+	}
+
+	if err := syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &syscall.Flock_t{Start: 0, Len: 0, Type: syscall.F_WRLCK, Whence: io.SeekStart}); err != nil {
+		log.Errorf("lock file: %s fd: %v failed: %v", fname, f.Fd(), err)
+		e.locks_.Remove(fname)
+		f.Close()
+		return nil, fmt.Errorf("lock %s: %v", fname, err)
+	}
+	return &posixFileLock{file_: f, filename_: fname}, nil
+}
+
+func (e *posixEnv) UnlockFile(lock FileLock) error {
+	posix_file_lock := lock.(*posixFileLock)
+	f := posix_file_lock.file_
+	err := syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &syscall.Flock_t{Start: 0, Len: 0, Type: syscall.F_UNLCK, Whence: io.SeekStart})
+	if err != nil {
+		log.Errorf("unlock file: %s fd: %v failed: %v", posix_file_lock.filename_, f.Fd(), err)
+	}
+	e.locks_.Remove(posix_file_lock.filename_)
+	if close_err := f.Close(); err == nil {
+		err = close_err
+	}
+	return err
+}
+
+func (e *posixEnv) Schedule(f func()) {
+	background_work_queue_.Schedule(f)
+}
+
+func (e *posixEnv) StartThread(f func()) {
+	go f()
+}
+
+func (e *posixEnv) NowMicros() uint64 {
+	return uint64(time.Now().UnixNano() / 1000)
+}
+
+func (e *posixEnv) SleepForMicroseconds(micros int) {
+	time.Sleep(time.Duration(micros) * time.Microsecond)
+}
+
+func GetFileLockPid(fd uintptr) (int32, error) {
+	t := &syscall.Flock_t{Start: 0, Len: 0, Type: syscall.F_WRLCK, Whence: io.SeekStart}
+	if err := syscall.FcntlFlock(fd, syscall.F_GETLK, t); err != nil {
+		log.Errorf("get flock of fd: %v failed: %v", fd, err)
+		return 0, err
+	}
+	return t.Pid, nil
+}
+
+var default_env_ Env = &posixEnv{locks_: posixLockTable{locked_files_: map[string]struct{}{}}}
+
+// Default returns a default environment suitable for the current
+// operating system.  Sophisticated users may wish to provide their
+// own Env implementation instead of relying on this default
+// environment.
+//
+// The result of Default() belongs to leveldb and must never be
+// replaced.
+func Default() Env {
+	return default_env_
+}
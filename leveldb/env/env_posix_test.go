@@ -0,0 +1,188 @@
+package env
+
+import (
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultEnvFiles(t *testing.T) {
+	env := Default()
+	dir := t.TempDir() + "/dir"
+
+	if err := env.CreateDir(dir); err != nil {
+		t.Fatal(err)
+	}
+	if env.FileExists(dir + "/f") {
+		t.Error("f exists before it was written")
+	}
+	if _, err := env.GetFileSize(dir + "/f"); !os.IsNotExist(err) {
+		t.Errorf("GetFileSize(missing f) = %v, want not exist", err)
+	}
+
+	// Append goes through the write buffer, Close flushes it.
+	if err := WriteStringToFileSync(env, "hello ", dir+"/f"); err != nil {
+		t.Fatal(err)
+	}
+	file, err := env.NewAppendableFile(dir + "/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Append([]byte("world"))
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if size, err := env.GetFileSize(dir + "/f"); err != nil || size != 11 {
+		t.Errorf("GetFileSize(f) = (%d, %v), want 11", size, err)
+	}
+	if data, err := ReadFileToString(env, dir+"/f"); err != nil || data != "hello world" {
+		t.Errorf("ReadFileToString(f) = (%q, %v), want \"hello world\"", data, err)
+	}
+
+	// A write larger than the buffer is written through.
+	large := strings.Repeat("x", kWritableFileBufferSize+10)
+	if err := WriteStringToFile(env, large, dir+"/large"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ReadFileToString(env, dir+"/large"); err != nil || data != large {
+		t.Errorf("ReadFileToString(large) = (%d bytes, %v), want %d bytes", len(data), err, len(large))
+	}
+
+	if err := env.RenameFile(dir+"/large", dir+"/g"); err != nil {
+		t.Fatal(err)
+	}
+	children, err := env.GetChildren(dir)
+	sort.Strings(children)
+	if err != nil || strings.Join(children, ",") != "f,g" {
+		t.Errorf("GetChildren = (%q, %v), want [f g]", children, err)
+	}
+
+	// Sequential and random reads.
+	seq_file, err := env.NewSequentialFile(dir + "/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := seq_file.Skip(6); err != nil {
+		t.Fatal(err)
+	}
+	if result, err := seq_file.Read(100, make([]byte, 100)); err != nil || string(result) != "world" {
+		t.Errorf("Read after Skip = (%q, %v), want \"world\"", result, err)
+	}
+	seq_file.Close()
+	rand_file, err := env.NewRandomAccessFile(dir + "/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, 5)
+	if n, err := rand_file.ReadAt(p, 0); err != nil || string(p[:n]) != "hello" {
+		t.Errorf("ReadAt(0) = (%q, %v), want \"hello\"", p[:n], err)
+	}
+	if n, err := rand_file.ReadAt(p, 8); err == nil || string(p[:n]) != "rld" {
+		t.Errorf("ReadAt(8) = (%q, %v), want a short read of \"rld\"", p[:n], err)
+	}
+	rand_file.Close()
+
+	for _, fname := range []string{dir + "/f", dir + "/g"} {
+		if err := env.DeleteFile(fname); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := env.DeleteFile(dir + "/f"); !os.IsNotExist(err) {
+		t.Errorf("DeleteFile(deleted f) = %v, want not exist", err)
+	}
+	if err := env.DeleteDir(dir); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDefaultEnvLocks(t *testing.T) {
+	env := Default()
+	fname := t.TempDir() + "/LOCK"
+
+	lock, err := env.LockFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock.Name() != fname {
+		t.Errorf("lock.Name() = %q, want %q", lock.Name(), fname)
+	}
+	// fcntl locks do not exclude the owning process, the lock table does.
+	if _, err := env.LockFile(fname); err == nil {
+		t.Error("second LockFile in the same process succeeded")
+	}
+	if err := env.UnlockFile(lock); err != nil {
+		t.Fatal(err)
+	}
+	lock, err = env.LockFile(fname)
+	if err != nil {
+		t.Fatalf("LockFile after UnlockFile = %v", err)
+	}
+	env.UnlockFile(lock)
+}
+
+// TestDefaultEnvLockHelper is run in a child process by
+// TestDefaultEnvLockHeldByOtherProcess: it tries to lock the file named
+// by LEVELDB_TEST_LOCK_FILE and reports the result on stdout.
+func TestDefaultEnvLockHelper(t *testing.T) {
+	fname := os.Getenv("LEVELDB_TEST_LOCK_FILE")
+	if fname == "" {
+		t.Skip("only run by TestDefaultEnvLockHeldByOtherProcess")
+	}
+	if lock, err := Default().LockFile(fname); err != nil {
+		os.Stdout.WriteString("lock failed\n")
+	} else {
+		Default().UnlockFile(lock)
+		os.Stdout.WriteString("locked\n")
+	}
+}
+
+func TestDefaultEnvLockHeldByOtherProcess(t *testing.T) {
+	fname := t.TempDir() + "/LOCK"
+	lockInChild := func() string {
+		t.Helper()
+		cmd := exec.Command(os.Args[0], "-test.run=^TestDefaultEnvLockHelper$")
+		cmd.Env = append(os.Environ(), "LEVELDB_TEST_LOCK_FILE="+fname)
+		done := make(chan struct{})
+		var out []byte
+		var err error
+		go func() {
+			out, err = cmd.Output()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			cmd.Process.Kill()
+			<-done
+			t.Fatal("LockFile in the child process waited for the lock")
+		}
+		if err != nil {
+			t.Fatalf("child process: %v\n%s", err, out)
+		}
+		// The output holds the test framework's lines as well.
+		for _, line := range strings.Split(string(out), "\n") {
+			if line == "locked" || line == "lock failed" {
+				return line
+			}
+		}
+		t.Fatalf("unexpected child output:\n%s", out)
+		return ""
+	}
+
+	lock, err := Default().LockFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lockInChild(); got != "lock failed" {
+		t.Errorf("LockFile of a lock held by another process: %q, want \"lock failed\"", got)
+	}
+	if err := Default().UnlockFile(lock); err != nil {
+		t.Fatal(err)
+	}
+	if got := lockInChild(); got != "locked" {
+		t.Errorf("LockFile of a released lock: %q, want \"locked\"", got)
+	}
+}
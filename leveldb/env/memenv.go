@@ -0,0 +1,280 @@
+package env
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// memFileState holds the contents of a file of a memEnv.  It is
+// shared by the files opened on it and outlives its deletion from the
+// file system while they use it.
+type memFileState struct {
+	mutex_ sync.RWMutex
+	data_  []byte
+}
+
+func (f *memFileState) Size() uint64 {
+	f.mutex_.RLock()
+	defer f.mutex_.RUnlock()
+	return uint64(len(f.data_))
+}
+
+func (f *memFileState) Truncate() {
+	f.mutex_.Lock()
+	defer f.mutex_.Unlock()
+	f.data_ = nil
+}
+
+func (f *memFileState) ReadAt(p []byte, off int64) (int, error) {
+	f.mutex_.RLock()
+	defer f.mutex_.RUnlock()
+	if off >= int64(len(f.data_)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data_[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (f *memFileState) Append(data []byte) {
+	f.mutex_.Lock()
+	defer f.mutex_.Unlock()
+	f.data_ = append(f.data_, data...)
+}
+
+type memSequentialFile struct {
+	file_ *memFileState
+	pos_  uint64
+}
+
+func (f *memSequentialFile) Read(n int, scratch []byte) ([]byte, error) {
+	if len(scratch) != n {
+		return nil, fmt.Errorf("unexpected read size %d, buffer has %d bytes", n, len(scratch))
+	}
+	read, err := f.file_.ReadAt(scratch, int64(f.pos_))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	f.pos_ += uint64(read)
+	result := make([]byte, read)
+	copy(result, scratch)
+	return result, nil
+}
+
+func (f *memSequentialFile) Skip(n uint64) error {
+	size := f.file_.Size()
+	if f.pos_ > size {
+		return fmt.Errorf("skip: position %d past the end of the file (%d bytes)", f.pos_, size)
+	}
+	available := size - f.pos_
+	if n > available {
+		n = available
+	}
+	f.pos_ += n
+	return nil
+}
+
+func (f *memSequentialFile) Close() error {
+	return nil
+}
+
+type memRandomAccessFile struct {
+	file_ *memFileState
+}
+
+func (f *memRandomAccessFile) ReadAt(p []byte, off int64) (int, error) {
+	return f.file_.ReadAt(p, off)
+}
+
+func (f *memRandomAccessFile) Close() error {
+	return nil
+}
+
+type memWritableFile struct {
+	file_ *memFileState
+}
+
+func (f *memWritableFile) Append(data []byte) error {
+	f.file_.Append(data)
+	return nil
+}
+
+func (f *memWritableFile) Close() error {
+	return nil
+}
+
+func (f *memWritableFile) Flush() error {
+	return nil
+}
+
+func (f *memWritableFile) Sync() error {
+	return nil
+}
+
+type memFileLock struct {
+	fname_ string
+}
+
+func (l *memFileLock) Name() string {
+	return l.fname_
+}
+
+// memEnv keeps its files in a map keyed by file name.  Directories are
+// implicit: a directory lists the files whose names start with its
+// name followed by a "/".
+type memEnv struct {
+	Env // base env, runs the work that does not touch the file system
+
+	mutex_        sync.Mutex
+	file_map_     map[string]*memFileState
+	locked_files_ map[string]struct{}
+}
+
+// NewMemEnv returns a new environment that stores its data in memory
+// and delegates all non-file-storage tasks to base_env, which must
+// remain usable while the result is in use.
+func NewMemEnv(base_env Env) Env {
+	return &memEnv{
+		Env:           base_env,
+		file_map_:     map[string]*memFileState{},
+		locked_files_: map[string]struct{}{},
+	}
+}
+
+func memNotFound(op string, fname string) error {
+	return &os.PathError{Op: op, Path: fname, Err: os.ErrNotExist}
+}
+
+func (e *memEnv) NewSequentialFile(fname string) (SequentialFile, error) {
+	e.mutex_.Lock()
+	defer e.mutex_.Unlock()
+	file, ok := e.file_map_[fname]
+	if !ok {
+		return nil, memNotFound("open", fname)
+	}
+	return &memSequentialFile{file_: file}, nil
+}
+
+func (e *memEnv) NewRandomAccessFile(fname string) (RandomAccessFile, error) {
+	e.mutex_.Lock()
+	defer e.mutex_.Unlock()
+	file, ok := e.file_map_[fname]
+	if !ok {
+		return nil, memNotFound("open", fname)
+	}
+	return &memRandomAccessFile{file_: file}, nil
+}
+
+func (e *memEnv) NewWritableFile(fname string) (WritableFile, error) {
+	e.mutex_.Lock()
+	defer e.mutex_.Unlock()
+	file, ok := e.file_map_[fname]
+	if !ok {
+		file = &memFileState{}
+		e.file_map_[fname] = file
+	} else {
+		file.Truncate()
+	}
+	return &memWritableFile{file_: file}, nil
+}
+
+func (e *memEnv) NewAppendableFile(fname string) (WritableFile, error) {
+	e.mutex_.Lock()
+	defer e.mutex_.Unlock()
+	file, ok := e.file_map_[fname]
+	if !ok {
+		file = &memFileState{}
+		e.file_map_[fname] = file
+	}
+	return &memWritableFile{file_: file}, nil
+}
+
+func (e *memEnv) FileExists(fname string) bool {
+	e.mutex_.Lock()
+	defer e.mutex_.Unlock()
+	_, ok := e.file_map_[fname]
+	return ok
+}
+
+func (e *memEnv) GetChildren(dir string) ([]string, error) {
+	e.mutex_.Lock()
+	defer e.mutex_.Unlock()
+	result := []string{}
+	prefix := dir + "/"
+	for filename := range e.file_map_ {
+		if len(filename) > len(prefix) && strings.HasPrefix(filename, prefix) {
+			result = append(result, filename[len(prefix):])
+		}
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// REQUIRES: mutex_ held
+func (e *memEnv) DeleteFileInternal(fname string) error {
+	if _, ok := e.file_map_[fname]; !ok {
+		return memNotFound("remove", fname)
+	}
+	delete(e.file_map_, fname)
+	return nil
+}
+
+func (e *memEnv) DeleteFile(fname string) error {
+	e.mutex_.Lock()
+	defer e.mutex_.Unlock()
+	return e.DeleteFileInternal(fname)
+}
+
+func (e *memEnv) CreateDir(dirname string) error {
+	return nil
+}
+
+func (e *memEnv) DeleteDir(dirname string) error {
+	return nil
+}
+
+func (e *memEnv) GetFileSize(fname string) (uint64, error) {
+	e.mutex_.Lock()
+	defer e.mutex_.Unlock()
+	file, ok := e.file_map_[fname]
+	if !ok {
+		return 0, memNotFound("stat", fname)
+	}
+	return file.Size(), nil
+}
+
+func (e *memEnv) RenameFile(src, target string) error {
+	e.mutex_.Lock()
+	defer e.mutex_.Unlock()
+	file, ok := e.file_map_[src]
+	if !ok {
+		return memNotFound("rename", src)
+	}
+	e.DeleteFileInternal(target)
+	e.file_map_[target] = file
+	delete(e.file_map_, src)
+	return nil
+}
+
+func (e *memEnv) LockFile(fname string) (FileLock, error) {
+	e.mutex_.Lock()
+	defer e.mutex_.Unlock()
+	if _, ok := e.locked_files_[fname]; ok {
+		return nil, fmt.Errorf("lock %s: already held", fname)
+	}
+	e.locked_files_[fname] = struct{}{}
+	return &memFileLock{fname_: fname}, nil
+}
+
+func (e *memEnv) UnlockFile(lock FileLock) error {
+	e.mutex_.Lock()
+	defer e.mutex_.Unlock()
+	delete(e.locked_files_, lock.Name())
+	return nil
+}
@@ -0,0 +1,313 @@
+package env
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMemEnvBasics(t *testing.T) {
+	env := NewMemEnv(Default())
+
+	if err := env.CreateDir("/dir"); err != nil {
+		t.Fatal(err)
+	}
+	if env.FileExists("/dir/non_existent") {
+		t.Error("non_existent exists")
+	}
+	if _, err := env.GetFileSize("/dir/non_existent"); !os.IsNotExist(err) {
+		t.Errorf("GetFileSize(non_existent) = %v, want not exist", err)
+	}
+	if children, err := env.GetChildren("/dir"); err != nil || len(children) != 0 {
+		t.Errorf("GetChildren(/dir) = (%v, %v), want none", children, err)
+	}
+
+	// Create a file.
+	if err := WriteStringToFile(env, "", "/dir/f"); err != nil {
+		t.Fatal(err)
+	}
+	if !env.FileExists("/dir/f") {
+		t.Error("f does not exist")
+	}
+	if size, err := env.GetFileSize("/dir/f"); err != nil || size != 0 {
+		t.Errorf("GetFileSize(f) = (%d, %v), want 0", size, err)
+	}
+
+	// Write to the file.
+	file, err := env.NewAppendableFile("/dir/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Append([]byte("abc"))
+	file.Close()
+	if size, err := env.GetFileSize("/dir/f"); err != nil || size != 3 {
+		t.Errorf("GetFileSize(f) = (%d, %v), want 3", size, err)
+	}
+
+	// Rewriting a file truncates it.
+	if err := WriteStringToFile(env, "xy", "/dir/f"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ReadFileToString(env, "/dir/f"); err != nil || data != "xy" {
+		t.Errorf("ReadFileToString(f) = (%q, %v), want \"xy\"", data, err)
+	}
+
+	// Check that deleting works.
+	if err := env.DeleteFile("/dir/non_existent"); !os.IsNotExist(err) {
+		t.Errorf("DeleteFile(non_existent) = %v, want not exist", err)
+	}
+	if err := env.DeleteFile("/dir/f"); err != nil {
+		t.Fatal(err)
+	}
+	if env.FileExists("/dir/f") {
+		t.Error("f exists after DeleteFile")
+	}
+	if _, err := env.NewSequentialFile("/dir/f"); !os.IsNotExist(err) {
+		t.Errorf("NewSequentialFile(deleted f) = %v, want not exist", err)
+	}
+	if _, err := env.NewRandomAccessFile("/dir/f"); !os.IsNotExist(err) {
+		t.Errorf("NewRandomAccessFile(deleted f) = %v, want not exist", err)
+	}
+	if err := env.DeleteDir("/dir"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMemEnvGetChildren(t *testing.T) {
+	env := NewMemEnv(Default())
+	for _, fname := range []string{"/dir/b", "/dir/a", "/dir/sub/c", "/dirx/d", "/other/e"} {
+		if err := WriteStringToFile(env, fname, fname); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		dir  string
+		want []string
+	}{
+		{dir: "/dir", want: []string{"a", "b", "sub/c"}},
+		{dir: "/dir/sub", want: []string{"c"}},
+		{dir: "/dirx", want: []string{"d"}},
+		{dir: "/missing", want: []string{}},
+	}
+	for _, tt := range tests {
+		children, err := env.GetChildren(tt.dir)
+		if err != nil || !reflect.DeepEqual(children, tt.want) {
+			t.Errorf("GetChildren(%s) = (%q, %v), want %q", tt.dir, children, err, tt.want)
+		}
+	}
+}
+
+func TestMemEnvRename(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		src     string
+		target  string
+		wantErr bool
+		want    map[string]string
+	}{
+		{
+			name:   "to new name",
+			files:  map[string]string{"/dir/a": "aaa"},
+			src:    "/dir/a",
+			target: "/dir/b",
+			want:   map[string]string{"/dir/b": "aaa"},
+		},
+		{
+			name:   "over existing file",
+			files:  map[string]string{"/dir/a": "aaa", "/dir/b": "bbbbbb"},
+			src:    "/dir/a",
+			target: "/dir/b",
+			want:   map[string]string{"/dir/b": "aaa"},
+		},
+		{
+			name:    "missing source",
+			files:   map[string]string{"/dir/b": "bbb"},
+			src:     "/dir/a",
+			target:  "/dir/b",
+			wantErr: true,
+			want:    map[string]string{"/dir/b": "bbb"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := NewMemEnv(Default())
+			for fname, data := range tt.files {
+				if err := WriteStringToFile(env, data, fname); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := env.RenameFile(tt.src, tt.target)
+			if tt.wantErr {
+				if !os.IsNotExist(err) {
+					t.Errorf("RenameFile = %v, want not exist", err)
+				}
+			} else if err != nil {
+				t.Errorf("RenameFile = %v", err)
+			}
+
+			children, err := env.GetChildren("/dir")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := map[string]string{}
+			for _, child := range children {
+				got["/dir/"+child], _ = ReadFileToString(env, "/dir/"+child)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("files = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemEnvReadWrite(t *testing.T) {
+	env := NewMemEnv(Default())
+
+	file, err := env.NewWritableFile("/dir/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Append([]byte("hello "))
+	file.Append([]byte("world"))
+	file.Close()
+
+	// Read sequentially.
+	seq_file, err := env.NewSequentialFile("/dir/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := []struct {
+		skip uint64
+		n    int
+		want string
+	}{
+		{n: 5, want: "hello"},          // Read "hello".
+		{skip: 1, n: 3, want: "wor"},   // Skip " ", read "wor".
+		{skip: 0, n: 1000, want: "ld"}, // Read "ld", a short read at the end.
+		{skip: 100, n: 5, want: ""},    // Skip past end of file.
+	}
+	for _, step := range steps {
+		if err := seq_file.Skip(step.skip); err != nil {
+			t.Fatalf("Skip(%d) = %v", step.skip, err)
+		}
+		result, err := seq_file.Read(step.n, make([]byte, step.n))
+		if err != nil || string(result) != step.want {
+			t.Errorf("Read(%d) = (%q, %v), want %q", step.n, result, err, step.want)
+		}
+	}
+	if _, err := seq_file.Read(4, make([]byte, 3)); err == nil {
+		t.Error("Read with a short scratch buffer succeeded")
+	}
+	seq_file.Close()
+
+	// Random reads.
+	rand_file, err := env.NewRandomAccessFile("/dir/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rand_file.Close()
+	reads := []struct {
+		off   int64
+		n     int
+		want  string
+		short bool
+	}{
+		{off: 6, n: 5, want: "world"},
+		{off: 0, n: 5, want: "hello"},
+		{off: 10, n: 100, want: "d", short: true},
+		{off: 1000, n: 5, want: "", short: true},
+	}
+	for _, r := range reads {
+		p := make([]byte, r.n)
+		n, err := rand_file.ReadAt(p, r.off)
+		if string(p[:n]) != r.want || (err != nil) != r.short {
+			t.Errorf("ReadAt(%d, %d) = (%q, %v), want %q", r.off, r.n, p[:n], err, r.want)
+		}
+	}
+
+	// Open files keep reading the data of a file that was deleted.
+	if err := env.DeleteFile("/dir/f"); err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, 5)
+	if n, err := rand_file.ReadAt(p, 0); err != nil || string(p[:n]) != "hello" {
+		t.Errorf("ReadAt after DeleteFile = (%q, %v), want \"hello\"", p[:n], err)
+	}
+}
+
+func TestMemEnvLocks(t *testing.T) {
+	env := NewMemEnv(Default())
+
+	lock, err := env.LockFile("/dir/LOCK")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock.Name() != "/dir/LOCK" {
+		t.Errorf("lock.Name() = %q", lock.Name())
+	}
+	if _, err := env.LockFile("/dir/LOCK"); err == nil || !strings.Contains(err.Error(), "already held") {
+		t.Errorf("second LockFile = %v, want already held", err)
+	}
+	other, err := env.LockFile("/other/LOCK")
+	if err != nil {
+		t.Fatalf("LockFile of another file = %v", err)
+	}
+	if err := env.UnlockFile(lock); err != nil {
+		t.Fatal(err)
+	}
+	relock, err := env.LockFile("/dir/LOCK")
+	if err != nil {
+		t.Fatalf("LockFile after UnlockFile = %v", err)
+	}
+	env.UnlockFile(relock)
+	env.UnlockFile(other)
+
+	// Each MemEnv has its own locks.
+	other_env := NewMemEnv(Default())
+	if lock, err := other_env.LockFile("/dir/LOCK"); err != nil {
+		t.Errorf("LockFile in another MemEnv = %v", err)
+	} else {
+		other_env.UnlockFile(lock)
+	}
+}
+
+func TestMemEnvLargeWrite(t *testing.T) {
+	const kWriteSize = 300 * 1024
+	env := NewMemEnv(Default())
+	write_data := make([]byte, kWriteSize)
+	for i := range write_data {
+		write_data[i] = byte(i)
+	}
+
+	file, err := env.NewWritableFile("/dir/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Append([]byte("foo"))
+	file.Append(write_data)
+	file.Close()
+
+	seq_file, err := env.NewSequentialFile("/dir/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer seq_file.Close()
+	result, err := seq_file.Read(3, make([]byte, 3))
+	if err != nil || string(result) != "foo" {
+		t.Fatalf("Read(3) = (%q, %v), want \"foo\"", result, err)
+	}
+	var read_data []byte
+	scratch := make([]byte, kWriteSize)
+	for len(read_data) < kWriteSize {
+		result, err := seq_file.Read(kWriteSize, scratch)
+		if err != nil || len(result) == 0 {
+			t.Fatalf("Read = (%d bytes, %v)", len(result), err)
+		}
+		read_data = append(read_data, result...)
+	}
+	if string(read_data) != string(write_data) {
+		t.Error("read data differs from the written data")
+	}
+}
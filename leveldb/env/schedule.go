@@ -1,5 +1,91 @@
 package env
 
-func Schedule(f func()) {
-	f()
+import "sync"
+
+// WorkQueue runs the work scheduled on it in FIFO order on a fixed
+// number of worker goroutines, started on first use.
+type WorkQueue struct {
+	mutex_   sync.Mutex
+	cv_      *sync.Cond
+	queue_   []func()
+	workers_ int // Number of worker goroutines to run
+	started_ int // Number of worker goroutines started so far
+}
+
+// NewWorkQueue returns a queue run by workers goroutines.
+func NewWorkQueue(workers int) *WorkQueue {
+	if workers < 1 {
+		workers = 1
+	}
+	q := &WorkQueue{workers_: workers}
+	q.cv_ = sync.NewCond(&q.mutex_)
+	return q
+}
+
+// Schedule arranges to run f once on a worker goroutine of the queue.
+func (q *WorkQueue) Schedule(f func()) {
+	q.mutex_.Lock()
+	defer q.mutex_.Unlock()
+
+	// Start the worker goroutines, if we haven't done so already.
+	q.startWorkers()
+
+	// The workers may be waiting for work.
+	q.queue_ = append(q.queue_, f)
+	q.cv_.Signal()
+}
+
+// SetWorkers changes the number of worker goroutines of the queue.
+// Workers that are already started keep running, so lowering the
+// number has no effect once the queue is in use.
+func (q *WorkQueue) SetWorkers(workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	q.mutex_.Lock()
+	defer q.mutex_.Unlock()
+	q.workers_ = workers
+	if q.started_ > 0 {
+		q.startWorkers()
+	}
+}
+
+// REQUIRES: mutex_ held
+func (q *WorkQueue) startWorkers() {
+	for q.started_ < q.workers_ {
+		q.started_ += 1
+		go q.BackgroundThreadMain()
+	}
+}
+
+// BackgroundThreadMain runs the scheduled work items, forever.
+func (q *WorkQueue) BackgroundThreadMain() {
+	for {
+		q.mutex_.Lock()
+
+		// Wait until there is work to be done.
+		for len(q.queue_) == 0 {
+			q.cv_.Wait()
+		}
+
+		f := q.queue_[0]
+		q.queue_[0] = nil
+		q.queue_ = q.queue_[1:]
+
+		q.mutex_.Unlock()
+		f()
+	}
+}
+
+// The background work scheduled on the default Env by all the DBs of
+// the process share the workers of this queue, like the single
+// background thread of leveldb.
+var background_work_queue_ = NewWorkQueue(1)
+
+// SetBackgroundWorkers sets the number of goroutines running the work
+// passed to Default().Schedule.  Processes hosting many DBs may raise
+// it so that the compactions of different DBs run in parallel.
+// Default: 1
+func SetBackgroundWorkers(workers int) {
+	background_work_queue_.SetWorkers(workers)
 }
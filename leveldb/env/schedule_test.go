@@ -0,0 +1,81 @@
+package env
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWorkQueueOrder(t *testing.T) {
+	q := NewWorkQueue(1)
+	var mu sync.Mutex
+	var order []int
+	var done sync.WaitGroup
+	for i := 0; i < 100; i += 1 {
+		i := i
+		done.Add(1)
+		q.Schedule(func() {
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			done.Done()
+		})
+	}
+	done.Wait()
+	for i, v := range order {
+		if v != i {
+			t.Fatalf("work item %d ran in position %d", v, i)
+		}
+	}
+}
+
+func TestWorkQueueWorkers(t *testing.T) {
+	// Each item waits for all the others to start, which needs as many
+	// workers as items.
+	const workers = 4
+	q := NewWorkQueue(1)
+	q.SetWorkers(workers)
+	var started, done sync.WaitGroup
+	started.Add(workers)
+	done.Add(workers)
+	for i := 0; i < workers; i += 1 {
+		q.Schedule(func() {
+			started.Done()
+			started.Wait()
+			done.Done()
+		})
+	}
+	waitOrTimeout(t, &done)
+}
+
+func TestWorkQueueReentrant(t *testing.T) {
+	// Work may schedule more work, which runs once it returns.
+	q := NewWorkQueue(1)
+	var done sync.WaitGroup
+	done.Add(3)
+	var schedule func(n int)
+	schedule = func(n int) {
+		q.Schedule(func() {
+			if n > 1 {
+				schedule(n - 1)
+			}
+			done.Done()
+		})
+	}
+	schedule(3)
+	waitOrTimeout(t, &done)
+}
+
+func waitOrTimeout(t *testing.T, wg *sync.WaitGroup) {
+	t.Helper()
+	ch := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+	select {
+	case <-ch:
+	case <-time.After(10 * time.Second):
+		t.Fatal("scheduled work did not complete")
+	}
+}
@@ -26,19 +26,20 @@ func TempFileName(name string, descNum uint64) string {
 	return MakeFileName(name, descNum, "dbtmp")
 }
 
-func SetCurrentFile(dbname string, descNum uint64) error {
+func SetCurrentFile(env_ env.Env, dbname string, descNum uint64) error {
 	// Remove leading "dbname/" and add newline to manifest file name
 	manifest := DescriptorFileName(dbname, descNum)
 	manifest = manifest[len(dbname)+1:]
 	tmp := TempFileName(dbname, descNum)
-	if err := env.WriteStringToFileSync(manifest+"\n", tmp); err != nil {
+	if err := env.WriteStringToFileSync(env_, manifest+"\n", tmp); err != nil {
 		return err
 	}
 
-	if err := env.RenameFile(tmp, CurrentFileName(dbname)); err != nil {
-		env.DeleteFile(tmp)
+	err := env_.RenameFile(tmp, CurrentFileName(dbname))
+	if err != nil {
+		env_.DeleteFile(tmp)
 	}
-	return nil
+	return err
 }
 
 func InfoLogFileName(dbname string) string {
@@ -53,3 +54,12 @@ func OldInfoLogFileName(dbname string) string {
 func LogFileName(dbname string, logNum uint64) string {
 	return MakeFileName(dbname, logNum, "log")
 }
+
+func TableFileName(dbname string, number uint64) string {
+	return MakeFileName(dbname, number, "ldb")
+}
+
+// SSTTableFileName returns the legacy file name for table "number".
+func SSTTableFileName(dbname string, number uint64) string {
+	return MakeFileName(dbname, number, "sst")
+}
@@ -1,57 +1,93 @@
 package leveldb
 
 import (
-	"encoding/binary"
-	"errors"
 	"fmt"
+	"io"
 
 	"github.com/golang/leveldb/crc"
 	"github.com/lemonwx/goleveldb/leveldb/env"
+	"github.com/lemonwx/goleveldb/leveldb/utils"
 	"github.com/lemonwx/log"
 )
 
-type Reporter struct {
+// Reporter is notified when the LogReader drops bytes due to
+// detected corruption.
+type Reporter interface {
+	// Some corruption was detected.  "size" is the approximate number
+	// of bytes dropped due to the corruption.
+	Corruption(size int, err error)
+}
+
+// StatusReporter remembers the first corruption reported to it.
+type StatusReporter struct {
 	err error
 }
 
-func (r *Reporter) Corruption(size int, err error) {
+func (r *StatusReporter) Corruption(size int, err error) {
 	if r.err == nil {
 		r.err = err
 	}
 }
 
 type LogReader struct {
-	src                   *env.SequentialFile
+	src                   env.SequentialFile
 	initial_offset_       uint64
 	last_record_offset_   uint64
 	buffer_               []byte
 	backing_store_        []byte
 	eof_                  bool
 	end_of_buffer_offset_ uint64
-	reporter_             *Reporter
+	reporter_             Reporter
 	checksum_             bool
 	resyncing_            bool
 }
 
-func NewLogReader(f *env.SequentialFile) *LogReader {
-	return &LogReader{src: f, backing_store_: make([]byte, kBlockSize)}
+// NewLogReader creates a reader that will return log records from f.
+//
+// If reporter is non-nil, it is notified whenever some data is
+// dropped due to a detected corruption.
+//
+// If checksum is true, verify checksums if available.
+//
+// The LogReader will start reading at the first record located at physical
+// position >= initial_offset within the file.
+func NewLogReader(f env.SequentialFile, reporter Reporter, checksum bool, initial_offset uint64) *LogReader {
+	return &LogReader{
+		src:             f,
+		reporter_:       reporter,
+		checksum_:       checksum,
+		initial_offset_: initial_offset,
+		resyncing_:      initial_offset > 0,
+		backing_store_:  make([]byte, kBlockSize),
+	}
+}
+
+// LastRecordOffset returns the physical offset of the last record returned by ReadRecord.
+func (lr *LogReader) LastRecordOffset() uint64 {
+	return lr.last_record_offset_
 }
 
+// ReadRecord reads the next record, it returns io.EOF when the end of
+// the input has been reached.
 func (lr *LogReader) ReadRecord() ([]byte, error) {
-	record := []byte{}
-	scratch := []byte{}
 	if lr.last_record_offset_ < lr.initial_offset_ {
 		if !lr.SkipToInitialBlock() {
-			return record, nil // todo: return tell caller continue read or not
+			return nil, io.EOF
 		}
 	}
-	scratch = scratch[:0]
-	record = record[:0]
+	var record []byte
+	scratch := []byte{}
 	in_fragmented_record := false
+	// Record offset of the logical record that we're reading
+	// 0 is a dummy value to make compilers happy
 	prospective_record_offset := uint64(0)
 	for {
 		fragment, record_type := lr.ReadPhysicalRecord()
-		physical_record_offset := lr.end_of_buffer_offset_ - uint64(len(lr.buffer_)) - uint64(kHeaderSize) - uint64(len(record))
+
+		// ReadPhysicalRecord may have only had an empty trailer remaining in its
+		// internal buffer. Calculate the offset of the next physical record now
+		// that it has returned, properly accounting for its header size.
+		physical_record_offset := lr.end_of_buffer_offset_ - uint64(len(lr.buffer_)) - uint64(kHeaderSize) - uint64(len(fragment))
 		if lr.resyncing_ {
 			if record_type == kMiddleType {
 				continue
@@ -66,12 +102,15 @@ func (lr *LogReader) ReadRecord() ([]byte, error) {
 		switch record_type {
 		case kFullType:
 			if in_fragmented_record {
+				// Handle bug in earlier versions of log::Writer where
+				// it could emit an empty kFirstType record at the tail end
+				// of a block followed by a kFullType or kFirstType record
+				// at the beginning of the next block.
 				if len(scratch) != 0 {
 					lr.ReportCorruption(len(scratch), "partial record without end(1)")
 				}
 			}
 			prospective_record_offset = physical_record_offset
-			scratch = scratch[:0]
 			record = fragment
 			lr.last_record_offset_ = prospective_record_offset
 			return record, nil
@@ -82,8 +121,7 @@ func (lr *LogReader) ReadRecord() ([]byte, error) {
 				}
 			}
 			prospective_record_offset = physical_record_offset
-			scratch = make([]byte, len(fragment)) // todo: chk if string.assign is like this in go
-			copy(scratch, fragment)
+			scratch = append(scratch[:0], fragment...)
 			in_fragmented_record = true
 		case kMiddleType:
 			if !in_fragmented_record {
@@ -96,16 +134,18 @@ func (lr *LogReader) ReadRecord() ([]byte, error) {
 				lr.ReportCorruption(len(fragment), "missing start of fragmented record(2)")
 			} else {
 				scratch = append(scratch, fragment...)
-				record = make([]byte, len(scratch))
-				copy(record, scratch)
+				record = scratch
 				lr.last_record_offset_ = prospective_record_offset
 				return record, nil
 			}
 		case kEof:
 			if in_fragmented_record {
+				// This can be caused by the writer dying immediately after
+				// writing a physical record but before completing the next; don't
+				// treat it as a corruption, just ignore the entire logical record.
 				scratch = scratch[:0]
 			}
-			return nil, errors.New("eof")
+			return nil, io.EOF
 		case kBadRecord:
 			if in_fragmented_record {
 				lr.ReportCorruption(len(scratch), "error in middle of record")
@@ -117,16 +157,33 @@ func (lr *LogReader) ReadRecord() ([]byte, error) {
 			if !in_fragmented_record {
 				size = 0
 			}
-			lr.ReportCorruption(len(fragment)+size, fmt.Sprintf("unknown record type of type %u", record_type))
+			lr.ReportCorruption(len(fragment)+size, fmt.Sprintf("unknown record type %d", record_type))
 			in_fragmented_record = false
 			scratch = scratch[:0]
 		}
 	}
-
-	return nil, nil
 }
 
+// SkipToInitialBlock skips all blocks that are completely before
+// initial_offset_, returns false on error.
 func (lr *LogReader) SkipToInitialBlock() bool {
+	offset_in_block := lr.initial_offset_ % kBlockSize
+	block_start_location := lr.initial_offset_ - offset_in_block
+
+	// Don't search a block if we'd be in the trailer
+	if offset_in_block > kBlockSize-6 {
+		block_start_location += kBlockSize
+	}
+
+	lr.end_of_buffer_offset_ = block_start_location
+
+	// Skip to start of first block that can contain the initial record
+	if block_start_location > 0 {
+		if err := lr.src.Skip(block_start_location); err != nil {
+			lr.ReportDrop(int(block_start_location), err)
+			return false
+		}
+	}
 	return true
 }
 
@@ -136,11 +193,11 @@ func (lr *LogReader) ReadPhysicalRecord() ([]byte, int) {
 		// read the header
 		if len(lr.buffer_) < kHeaderSize {
 			if !lr.eof_ {
-				lr.buffer_ = lr.buffer_[:0]
+				// Last read was a full read, so this is a trailer to skip
 				lr.buffer_, err = lr.src.Read(kBlockSize, lr.backing_store_)
 				lr.end_of_buffer_offset_ += uint64(len(lr.buffer_))
 				if err != nil {
-					lr.buffer_ = lr.buffer_
+					lr.buffer_ = lr.buffer_[:0]
 					lr.ReportDrop(kBlockSize, err)
 					lr.eof_ = true
 					return nil, kEof
@@ -150,6 +207,10 @@ func (lr *LogReader) ReadPhysicalRecord() ([]byte, int) {
 				}
 				continue
 			} else {
+				// Note that if buffer_ is non-empty, we have a truncated header at the
+				// end of the file, which can be caused by the writer crashing in the
+				// middle of writing the header. Instead of considering this an error,
+				// just report EOF.
 				lr.buffer_ = lr.buffer_[:0]
 				return nil, kEof
 			}
@@ -161,36 +222,45 @@ func (lr *LogReader) ReadPhysicalRecord() ([]byte, int) {
 		b := uint32(header[5] & 0xff)
 		Type := header[6]
 		length := a | (b << 8)
-		log.Debug(length)
-		if uint32(kHeaderSize)+uint32(length) > uint32(len(lr.buffer_)) {
+		if uint32(kHeaderSize)+length > uint32(len(lr.buffer_)) {
 			drop_size := len(lr.buffer_)
 			lr.buffer_ = lr.buffer_[:0]
 			if !lr.eof_ {
 				lr.ReportCorruption(drop_size, "bad record length")
 				return nil, kBadRecord
 			}
+			// If the end of the file has been reached without reading |length| bytes
+			// of payload, assume the writer died in the middle of writing the record.
+			// Don't report a corruption.
 			return nil, kEof
 		}
 		if Type == kZeroType && length == 0 {
+			// Skip zero length record without reporting any drops since
+			// such records are produced by the mmap based writing code in
+			// env_posix.cc that preallocates file regions.
 			lr.buffer_ = lr.buffer_[:0]
 			return nil, kBadRecord
 		}
 		// check crc
 		if lr.checksum_ {
-			expected_crc := crc.Unmask(binary.LittleEndian.Uint32(header))
-			actual_crc := crc.New(header[6:]).Value()
+			expected_crc := utils.DecodeFixed32(header)
+			actual_crc := crc.New(header[6 : kHeaderSize+length]).Value()
 			if actual_crc != expected_crc {
+				// Drop the rest of the buffer since "length" itself may have
+				// been corrupted and if we trust it, we could find some
+				// fragment of a real log record that just happens to look
+				// like a valid log record.
 				drop_size := len(lr.buffer_)
 				lr.buffer_ = lr.buffer_[:0]
-				lr.ReportCorruption(drop_size, "checksum crc mismatch")
+				lr.ReportCorruption(drop_size, "checksum mismatch")
 				return nil, kBadRecord
 			}
 		}
 		lr.remove_prefix(uint32(kHeaderSize) + length)
+		// Skip physical record that started before initial_offset_
 		if lr.end_of_buffer_offset_-uint64(len(lr.buffer_))-uint64(kHeaderSize)-uint64(length) < lr.initial_offset_ {
 			return nil, kBadRecord
 		}
-		log.Debug(length)
 		result := make([]byte, length)
 		copy(result, header[kHeaderSize:])
 		return result, int(Type)
@@ -204,12 +274,12 @@ func (lr *LogReader) ReportDrop(size int, err error) {
 }
 
 func (lr *LogReader) ReportCorruption(size int, reason string) {
-	lr.ReportDrop(size, errors.New(reason))
+	lr.ReportDrop(size, utils.NewCorruption(reason))
 }
 
 func (lr *LogReader) remove_prefix(size uint32) {
 	if size > uint32(len(lr.buffer_)) {
-		log.Fatal("prefix: %d should less than size: %d", size, len(lr.buffer_))
+		log.Fatal(fmt.Sprintf("prefix: %d should less than size: %d", size, len(lr.buffer_)))
 	}
 	lr.buffer_ = lr.buffer_[size:]
 }
@@ -5,7 +5,6 @@ import (
 
 	"github.com/golang/leveldb/crc"
 	"github.com/lemonwx/goleveldb/leveldb/env"
-	"github.com/lemonwx/log"
 )
 
 const (
@@ -27,25 +26,31 @@ const (
 
 type LogWriter struct {
 	block_offset_ int
-	dest_         *env.WritableFile
+	dest_         env.WritableFile
 	type_crc      []uint32
 }
 
-func NewLogWriter(dest_ *env.WritableFile) *LogWriter {
+func NewLogWriter(dest_ env.WritableFile) *LogWriter {
 	return &LogWriter{dest_: dest_}
 }
 
+// NewLogWriterWithLength creates a writer that will append data to
+// dest_, which must have initial length dest_length.
+func NewLogWriterWithLength(dest_ env.WritableFile, dest_length uint64) *LogWriter {
+	return &LogWriter{dest_: dest_, block_offset_: int(dest_length % kBlockSize)}
+}
+
 func (w *LogWriter) AddRecord(record []byte) error {
-	log.Debug(len(record), record)
 	left := len(record)
 	begin := true
 	for left > 0 {
 		leftover := kBlockSize - w.block_offset_
 		if leftover < kHeaderSize {
 			if leftover > 0 {
-				buf := []byte{0, 0, 0, 0, 0, 0}
-				if _, err := w.dest_.F.Write(buf); err != nil {
-					log.Errorf("write to %s failed: %v", w.dest_.F.Name(), err)
+				// Fill the trailer with zeroes
+				buf := make([]byte, leftover)
+				if err := w.dest_.Append(buf); err != nil {
+					return err
 				}
 			}
 			w.block_offset_ = 0
@@ -82,12 +87,13 @@ func (w *LogWriter) EmitPhysicalRecord(Type int, record []byte, n int) error {
 	buf[4] = byte(n & 0xff)
 	buf[5] = byte(n >> 8)
 	buf[6] = byte(Type)
-	buf = append(buf, record...)
+	buf = append(buf, record[:n]...)
 	CRC := crc.New(buf[6:]).Value()
 	binary.LittleEndian.PutUint32(buf[:4], CRC)
-	log.Debug(buf[:kHeaderSize])
-	log.Debug(buf[kHeaderSize:])
-	w.dest_.F.Write(buf)
-	w.dest_.F.Sync()
-	return nil
+	err := w.dest_.Append(buf)
+	if err == nil {
+		err = w.dest_.Flush()
+	}
+	w.block_offset_ += kHeaderSize + n
+	return err
 }
@@ -1,13 +1,217 @@
 package leveldb
 
+import (
+	"sync/atomic"
+
+	"github.com/lemonwx/goleveldb/leveldb/table"
+	"github.com/lemonwx/goleveldb/leveldb/utils"
+)
+
+// GetLengthPrefixedSlice decodes a varint32 length prefixed slice
+// stored at the beginning of data.
+func GetLengthPrefixedSlice(data string) string {
+	ret, _ := decodeLengthPrefixedSlice(data)
+	return ret
+}
+
+// decodeLengthPrefixedSlice is like GetLengthPrefixedSlice but also
+// returns the number of bytes consumed from data.
+func decodeLengthPrefixedSlice(data string) (string, int) {
+	length := uint32(0)
+	idx := 0
+	for shift := uint32(0); shift <= 28 && idx < len(data); shift += 7 {
+		b := uint32(data[idx])
+		idx += 1
+		length |= (b & 127) << shift
+		if b&128 == 0 {
+			break
+		}
+	}
+	return data[idx : idx+int(length)], idx + int(length)
+}
+
+// MemTableKeyComparator compares the length prefixed internal keys
+// stored in the skiplist of a MemTable.
+type MemTableKeyComparator struct {
+	comparator *utils.InternalKeyComparator
+}
+
+func (kc *MemTableKeyComparator) Compare(a, b string) int {
+	// Internal keys are encoded as length-prefixed strings.
+	return kc.comparator.Compare(GetLengthPrefixedSlice(a), GetLengthPrefixedSlice(b))
+}
+
+func (kc *MemTableKeyComparator) Name() string {
+	return kc.comparator.Name()
+}
+
+func (kc *MemTableKeyComparator) FindShortestSeparator(start string, limit string) string {
+	return start
+}
+
+func (kc *MemTableKeyComparator) FindShortSuccessor(key string) string {
+	return key
+}
+
 type MemTable struct {
-	refs int
+	comparator_   *MemTableKeyComparator
+	refs          int
+	memory_usage_ int64
+	table_        *SkipList
 }
 
-func NewMemTable() *MemTable {
-	return &MemTable{}
+// NewMemTable creates a MemTable ordered by cmp, MemTables are
+// reference counted.  The initial reference count is zero and the
+// caller must call Ref() at least once.
+func NewMemTable(cmp *utils.InternalKeyComparator) *MemTable {
+	m := &MemTable{comparator_: &MemTableKeyComparator{comparator: cmp}}
+	m.table_ = NewSkipList(m.comparator_)
+	return m
 }
 
 func (m *MemTable) Ref() {
 	m.refs += 1
 }
+
+// Unref drops reference count, the MemTable must not be used once
+// the count reaches zero.
+func (m *MemTable) Unref() {
+	m.refs -= 1
+	if m.refs < 0 {
+		panic("memtable: negative refs")
+	}
+}
+
+// ApproximateMemoryUsage returns an estimate of the number of bytes of
+// data in use by this data structure. It is safe to call when
+// MemTable is being modified.
+func (m *MemTable) ApproximateMemoryUsage() int {
+	return int(atomic.LoadInt64(&m.memory_usage_))
+}
+
+// Add an entry into memtable that maps key to value at the
+// specified sequence number and with the specified type.
+// Typically value will be empty if Type==kTypeDeletion.
+func (m *MemTable) Add(s SequenceNumber, Type ValueType, key, value []byte) {
+	// Format of an entry is concatenation of:
+	//  key_size     : varint32 of internal_key.size()
+	//  key bytes    : char[internal_key.size()]
+	//  tag          : uint64((sequence << 8) | type)
+	//  value_size   : varint32 of value.size()
+	//  value bytes  : char[value.size()]
+	key_size := len(key)
+	val_size := len(value)
+	internal_key_size := key_size + 8
+	buf := make([]byte, 0, internal_key_size+val_size+10)
+	utils.PutVarint32(&buf, uint32(internal_key_size))
+	buf = append(buf, key...)
+	utils.PutFixed64(&buf, PackSequenceAndType(s, Type))
+	utils.PutVarint32(&buf, uint32(val_size))
+	buf = append(buf, value...)
+	m.table_.Insert(string(buf))
+	atomic.AddInt64(&m.memory_usage_, int64(len(buf)))
+}
+
+// Get looks up key in the memtable. If memtable contains a value for
+// key, returns it with found set to true. If memtable contains a
+// deletion for key, returns found set to true and ErrNotFound.
+// Else, returns found set to false.
+func (m *MemTable) Get(key *LookupKey) ([]byte, bool, error) {
+	memkey := key.memtable_key()
+	iter := NewSkipListIterator(m.table_)
+	iter.Seek(memkey)
+	if !iter.Valid() {
+		return nil, false, nil
+	}
+	// entry format is:
+	//    klength  varint32
+	//    userkey  char[klength-8]
+	//    tag      uint64
+	//    vlength  varint32
+	//    value    char[vlength]
+	// Check that it belongs to same user key.  We do not check the
+	// sequence number since the Seek() call above should have skipped
+	// all entries with overly large sequence numbers.
+	entry := iter.Key()
+	internal_key, n := decodeLengthPrefixedSlice(entry)
+	if m.comparator_.comparator.User_comparator().Compare(utils.ExtractUserKey(internal_key), key.user_key()) != 0 {
+		return nil, false, nil
+	}
+	tag := utils.DecodeFixed64([]byte(internal_key[len(internal_key)-8:]))
+	switch ValueType(tag & 0xff) {
+	case kTypeValue:
+		return []byte(GetLengthPrefixedSlice(entry[n:])), true, nil
+	case kTypeDeletion:
+		return nil, true, ErrNotFound
+	}
+	return nil, false, nil
+}
+
+// NewIterator returns an iterator that yields the contents of the
+// memtable.
+//
+// The caller must ensure that the underlying MemTable remains live
+// while the returned iterator is live.  The keys returned by this
+// iterator are internal keys encoded by AppendInternalKey in the
+// dbformat.go module.
+func (m *MemTable) NewIterator() table.Iterator {
+	return &MemTableIterator{iter_: NewSkipListIterator(m.table_)}
+}
+
+// MemTableIterator adapts a SkipListIterator over encoded memtable
+// entries to the table.Iterator interface.
+type MemTableIterator struct {
+	iter_ *SkipListIterator
+	tmp_  []byte // For passing to EncodeKey
+}
+
+// EncodeKey encodes a suitable internal key target for "target" and
+// returns it.  Uses tmp_ as scratch space.
+func (it *MemTableIterator) EncodeKey(target []byte) string {
+	it.tmp_ = it.tmp_[:0]
+	utils.PutVarint32(&it.tmp_, uint32(len(target)))
+	it.tmp_ = append(it.tmp_, target...)
+	return string(it.tmp_)
+}
+
+func (it *MemTableIterator) Valid() bool {
+	return it.iter_.Valid()
+}
+
+func (it *MemTableIterator) Seek(k []byte) {
+	it.iter_.Seek(it.EncodeKey(k))
+}
+
+func (it *MemTableIterator) SeekToFirst() {
+	it.iter_.SeekToFirst()
+}
+
+func (it *MemTableIterator) SeekToLast() {
+	it.iter_.SeekToLast()
+}
+
+func (it *MemTableIterator) Next() {
+	it.iter_.Next()
+}
+
+func (it *MemTableIterator) Prev() {
+	it.iter_.Prev()
+}
+
+func (it *MemTableIterator) Key() []byte {
+	return []byte(GetLengthPrefixedSlice(it.iter_.Key()))
+}
+
+func (it *MemTableIterator) Value() []byte {
+	entry := it.iter_.Key()
+	_, n := decodeLengthPrefixedSlice(entry)
+	return []byte(GetLengthPrefixedSlice(entry[n:]))
+}
+
+func (it *MemTableIterator) Error() error {
+	return nil
+}
+
+func (it *MemTableIterator) Close() error {
+	return nil
+}
@@ -0,0 +1,210 @@
+package leveldb
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/lemonwx/goleveldb/leveldb/utils"
+)
+
+func TestSkipList(t *testing.T) {
+	list := NewSkipList(&utils.BytewiseComparator{})
+	iter := NewSkipListIterator(list)
+	iter.SeekToFirst()
+	if iter.Valid() {
+		t.Fatal("empty list has a first entry")
+	}
+
+	rnd := rand.New(rand.NewSource(301))
+	keys := map[string]struct{}{}
+	for i := 0; i < 2000; i += 1 {
+		key := fmt.Sprintf("%05d", rnd.Intn(5000))
+		if _, ok := keys[key]; ok {
+			continue
+		}
+		keys[key] = struct{}{}
+		list.Insert(key)
+	}
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	for i := 0; i < 5000; i += 1 {
+		key := fmt.Sprintf("%05d", i)
+		_, want := keys[key]
+		if list.Contains(key) != want {
+			t.Fatalf("Contains(%q) = %v, want %v", key, !want, want)
+		}
+	}
+
+	// Forward and backward iteration.
+	i := 0
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		if iter.Key() != sorted[i] {
+			t.Fatalf("entry %d = %q, want %q", i, iter.Key(), sorted[i])
+		}
+		i += 1
+	}
+	if i != len(sorted) {
+		t.Fatalf("forward iteration saw %d entries, want %d", i, len(sorted))
+	}
+	for iter.SeekToLast(); iter.Valid(); iter.Prev() {
+		i -= 1
+		if iter.Key() != sorted[i] {
+			t.Fatalf("backward entry %d = %q, want %q", i, iter.Key(), sorted[i])
+		}
+	}
+	if i != 0 {
+		t.Fatalf("backward iteration stopped at entry %d", i)
+	}
+
+	// Seek lands on the first entry at or after the target.
+	for _, target := range []string{"", "00000", "02500", "04999", "5"} {
+		want := sort.SearchStrings(sorted, target)
+		iter.Seek(target)
+		if want == len(sorted) {
+			if iter.Valid() {
+				t.Errorf("Seek(%q) = %q, want end", target, iter.Key())
+			}
+		} else if !iter.Valid() || iter.Key() != sorted[want] {
+			t.Errorf("Seek(%q) landed on the wrong entry, want %q", target, sorted[want])
+		}
+	}
+}
+
+// Readers iterate while a single writer inserts, every reader must
+// see a sorted list whose entries were all inserted.
+func TestSkipListConcurrentReads(t *testing.T) {
+	const kNumKeys = 5000
+	list := NewSkipList(&utils.BytewiseComparator{})
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for r := 0; r < 4; r += 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			iter := NewSkipListIterator(list)
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				prev := ""
+				for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+					if iter.Key() <= prev {
+						t.Errorf("reader saw %q after %q", iter.Key(), prev)
+						return
+					}
+					prev = iter.Key()
+				}
+			}
+		}()
+	}
+	for _, i := range rand.New(rand.NewSource(7)).Perm(kNumKeys) {
+		list.Insert(fmt.Sprintf("%06d", i))
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestMemTableGet(t *testing.T) {
+	mem := NewMemTable(utils.NewInternalKeyComparator(&utils.BytewiseComparator{}))
+	mem.Ref()
+	defer mem.Unref()
+
+	mem.Add(1, kTypeValue, []byte("a"), []byte("a1"))
+	mem.Add(2, kTypeValue, []byte("b"), []byte("b2"))
+	mem.Add(3, kTypeDeletion, []byte("a"), nil)
+	mem.Add(4, kTypeValue, []byte("a"), []byte("a4"))
+	mem.Add(5, kTypeDeletion, []byte("b"), nil)
+	mem.Add(6, kTypeValue, []byte("c"), []byte(""))
+
+	tests := []struct {
+		key   string
+		seq   SequenceNumber
+		value string
+		found bool
+		err   error
+	}{
+		{key: "a", seq: 0, found: false},
+		{key: "a", seq: 1, value: "a1", found: true},
+		{key: "a", seq: 2, value: "a1", found: true},
+		{key: "a", seq: 3, found: true, err: ErrNotFound},
+		{key: "a", seq: 4, value: "a4", found: true},
+		{key: "a", seq: kMaxSequenceNumber, value: "a4", found: true},
+		{key: "b", seq: 1, found: false},
+		{key: "b", seq: 4, value: "b2", found: true},
+		{key: "b", seq: 5, found: true, err: ErrNotFound},
+		{key: "b", seq: kMaxSequenceNumber, found: true, err: ErrNotFound},
+		{key: "c", seq: kMaxSequenceNumber, value: "", found: true},
+		{key: "", seq: kMaxSequenceNumber, found: false},
+		{key: "aa", seq: kMaxSequenceNumber, found: false},
+		{key: "d", seq: kMaxSequenceNumber, found: false},
+	}
+	for _, tt := range tests {
+		value, found, err := mem.Get(NewLookupKey([]byte(tt.key), tt.seq))
+		if found != tt.found || err != tt.err || string(value) != tt.value {
+			t.Errorf("Get(%q@%d) = (%q, %v, %v), want (%q, %v, %v)",
+				tt.key, tt.seq, value, found, err, tt.value, tt.found, tt.err)
+		}
+	}
+	if mem.ApproximateMemoryUsage() == 0 {
+		t.Error("ApproximateMemoryUsage() = 0 after Add")
+	}
+}
+
+func TestMemTableOrder(t *testing.T) {
+	mem := NewMemTable(utils.NewInternalKeyComparator(&utils.BytewiseComparator{}))
+	mem.Ref()
+	defer mem.Unref()
+
+	mem.Add(1, kTypeValue, []byte("b"), []byte("b1"))
+	mem.Add(2, kTypeValue, []byte("a"), []byte("a2"))
+	mem.Add(3, kTypeDeletion, []byte("b"), nil)
+
+	// Entries of the same user key are ordered by decreasing sequence.
+	want := []struct {
+		user_key string
+		sequence SequenceNumber
+		Type     ValueType
+	}{
+		{"a", 2, kTypeValue},
+		{"b", 3, kTypeDeletion},
+		{"b", 1, kTypeValue},
+	}
+	iter := mem.NewIterator()
+	defer iter.Close()
+	i := 0
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		ikey, ok := ParseInternalKey(string(iter.Key()))
+		if !ok {
+			t.Fatalf("entry %d: bad internal key", i)
+		}
+		if i >= len(want) {
+			t.Fatalf("entry %d: unexpected %q", i, ikey.user_key)
+		}
+		if ikey.user_key != want[i].user_key || ikey.sequence != want[i].sequence || ikey.Type != want[i].Type {
+			t.Errorf("entry %d = %q@%d type %d, want %q@%d type %d", i,
+				ikey.user_key, ikey.sequence, ikey.Type, want[i].user_key, want[i].sequence, want[i].Type)
+		}
+		if ikey.Type == kTypeValue && string(iter.Value()) != ikey.user_key+fmt.Sprint(ikey.sequence) {
+			t.Errorf("entry %d: value %q", i, iter.Value())
+		}
+		i += 1
+	}
+	if i != len(want) {
+		t.Errorf("iterated %d entries, want %d", i, len(want))
+	}
+
+	// Seek takes an internal key.
+	iter.Seek([]byte(NewInternalKey("b", 2, kValueTypeForSeek).Encode()))
+	if !iter.Valid() || string(iter.Value()) != "b1" {
+		t.Errorf("Seek(b@2) did not land on b@1")
+	}
+}
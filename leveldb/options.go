@@ -1,14 +1,194 @@
 package leveldb
 
 import (
+	"github.com/lemonwx/goleveldb/leveldb/cache"
+	"github.com/lemonwx/goleveldb/leveldb/env"
+	"github.com/lemonwx/goleveldb/leveldb/table"
 	"github.com/lemonwx/goleveldb/leveldb/utils"
 	"github.com/lemonwx/log"
 )
 
+// CompressionType is the compression applied to the blocks of the
+// tables, each block is compressed on its own.
+type CompressionType = table.CompressionType
+
+const (
+	NoCompression     = table.NoCompression
+	SnappyCompression = table.SnappyCompression
+	ZstdCompression   = table.ZstdCompression
+)
+
 type Options struct {
 	Comparator      utils.Comparator
 	CreateIfMissing bool
-	ReuseLogs       bool
-	MaxFileSize     int
-	info_log        log.Logger
+
+	// If true, the implementation will do aggressive checking of the
+	// data it is processing and will stop early if it detects any
+	// errors, e.g. a corrupted record in the log is fatal to recovery
+	// instead of being skipped.
+	// Default: false
+	ParanoidChecks bool
+
+	// Use the specified object to interact with the environment,
+	// e.g. to read/write files, schedule background work, etc.
+	// Default: env.Default()
+	Env env.Env
+
+	// Amount of data to build up in memory (backed by an unsorted log
+	// on disk) before converting to a sorted on-disk file.
+	//
+	// Larger values increase performance, especially during bulk loads.
+	// Up to two write buffers may be held in memory at the same time,
+	// so you may wish to adjust this parameter to control memory usage.
+	// Also, a larger write buffer will result in a longer recovery time
+	// the next time the database is opened.
+	// Default: 4MB
+	WriteBufferSize int
+
+	// Number of open files that can be used by the DB.  You may need to
+	// increase this if your database has a large working set (budget
+	// one open file per 2MB of working set).
+	// Default: 1000
+	MaxOpenFiles int
+
+	// Approximate size of user data packed per block.  Note that the
+	// block size specified here corresponds to uncompressed data.  The
+	// actual size of the unit read from disk may be smaller if
+	// compression is enabled.  This parameter can be changed dynamically.
+	// Default: 4K
+	BlockSize int
+
+	// If non-nil, use the specified cache for blocks.
+	// If nil, leveldb will automatically create and use an 8MB internal cache.
+	// Default: nil
+	BlockCache cache.Cache
+
+	// Number of keys between restart points for delta encoding of keys.
+	// This parameter can be changed dynamically.  Most clients should
+	// leave this parameter alone.
+	// Default: 16
+	BlockRestartInterval int
+
+	ReuseLogs bool
+
+	// Leveldb will write up to this amount of bytes to a file before
+	// switching to a new one.
+	// Most clients should leave this parameter alone.  However if your
+	// filesystem is more efficient with larger files, you could
+	// consider increasing the value.  The downside will be longer
+	// compactions and hence longer latency/performance hiccups.
+	// Another reason to increase this parameter might be when you are
+	// initially populating a large database.
+	// Default: 2MB
+	MaxFileSize int
+
+	// Compress blocks using the specified compression algorithm.  This
+	// parameter can be changed dynamically.
+	//
+	// SnappyCompression is usually faster than the persistent storage,
+	// and the blocks it fails to shrink are stored uncompressed.
+	// ZstdCompression compresses better at a higher CPU cost.  Both
+	// codecs are pure Go.
+	// Default: NoCompression
+	Compression CompressionType
+
+	// If non-nil, use the specified filter policy to reduce disk reads.
+	// Many applications will benefit from passing the result of
+	// utils.NewBloomFilterPolicy() here.
+	// Default: nil
+	FilterPolicy utils.FilterPolicy
+
+	info_log log.Logger
+}
+
+// tableOptions returns the options for the tables of a DB whose keys
+// are ordered by icmp.
+func (o *Options) tableOptions(icmp *utils.InternalKeyComparator) *table.Options {
+	options := &table.Options{
+		Comparator:           icmp,
+		BlockSize:            o.BlockSize,
+		BlockRestartInterval: o.BlockRestartInterval,
+		ParanoidChecks:       o.ParanoidChecks,
+		Compression:          o.Compression,
+		BlockCache:           o.BlockCache,
+	}
+	// The tables hold internal keys, the filters are built on the user keys
+	if o.FilterPolicy != nil {
+		options.FilterPolicy = NewInternalFilterPolicy(o.FilterPolicy)
+	}
+	return options
+}
+
+// ReadOptions control read operations.
+type ReadOptions struct {
+	// If true, all data read from underlying storage will be
+	// verified against corresponding checksums.
+	// Default: false
+	VerifyChecksums bool
+
+	// Should the data read for this iteration be cached in memory?
+	// Callers may wish to set this field to false for bulk scans.
+	// Default: true, but only in NewReadOptions(): the zero value of
+	// ReadOptions does not fill the cache.
+	FillCache bool
+
+	// If "Snapshot" is non-nil, read as of the supplied snapshot
+	// (which must belong to the DB that is being read and which must
+	// not have been released).  If "Snapshot" is nil, use an implicit
+	// snapshot of the state at the beginning of this read operation.
+	// Default: nil
+	Snapshot *Snapshot
+
+	// If non-nil, iterators only yield the keys that are >= LowerBound,
+	// and stop without reading the blocks and files that hold only
+	// smaller keys.
+	// Default: nil
+	LowerBound []byte
+
+	// If non-nil, iterators only yield the keys that are < UpperBound,
+	// and stop without reading the blocks and files that hold only
+	// larger keys.
+	// Default: nil
+	UpperBound []byte
+}
+
+// NewReadOptions returns ReadOptions filled with the defaults.  Use it
+// rather than a zero ReadOptions, which leaves FillCache false.
+func NewReadOptions() *ReadOptions {
+	return &ReadOptions{FillCache: true}
+}
+
+func (o *ReadOptions) tableReadOptions() *table.ReadOptions {
+	options := &table.ReadOptions{VerifyChecksums: o.VerifyChecksums, FillCache: o.FillCache}
+	// The tables hold internal keys: the internal key of a bound is the
+	// first of the entries for its user key.
+	if o.LowerBound != nil {
+		options.LowerBound = []byte(NewInternalKey(string(o.LowerBound), kMaxSequenceNumber, kValueTypeForSeek).Encode())
+	}
+	if o.UpperBound != nil {
+		options.UpperBound = []byte(NewInternalKey(string(o.UpperBound), kMaxSequenceNumber, kValueTypeForSeek).Encode())
+	}
+	return options
+}
+
+// WriteOptions control write operations.
+type WriteOptions struct {
+	// If true, the write will be flushed from the operating system
+	// buffer cache (by calling WritableFile::Sync()) before the write
+	// is considered complete.  If this flag is true, writes will be
+	// slower.
+	//
+	// If this flag is false, and the machine crashes, some recent
+	// writes may be lost.  Note that if it is just the process that
+	// crashes (i.e., the machine does not reboot), no writes will be
+	// lost even if Sync==false, since every record is handed to the
+	// operating system before Write returns.
+	//
+	// In other words, a DB write with Sync==false has similar
+	// crash semantics as the "write()" system call.  A DB write
+	// with Sync==true has similar crash semantics to a "write()"
+	// system call followed by "fsync()".
+	//
+	// Default: false
+	Sync bool
 }
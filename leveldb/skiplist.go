@@ -0,0 +1,264 @@
+package leveldb
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/lemonwx/goleveldb/leveldb/utils"
+)
+
+// Thread safety
+// -------------
+//
+// Writes require external synchronization, most likely a mutex.
+// Reads require a guarantee that the SkipList will not be destroyed
+// while the read is in progress.  Apart from that, reads progress
+// without any internal locking or synchronization.
+//
+// Invariants:
+//
+// (1) Allocated nodes are never deleted until the SkipList is
+// destroyed.  This is trivially guaranteed by the code since we
+// never delete any skip list nodes.
+//
+// (2) The contents of a Node except for the next/prev pointers are
+// immutable after the Node has been linked into the SkipList.
+// Only Insert() modifies the list, and it is careful to initialize
+// a node and use release-stores to publish the nodes in one or
+// more lists.
+
+const kMaxHeight = 12
+
+type skipNode struct {
+	key  string
+	next []unsafe.Pointer
+}
+
+func newSkipNode(key string, height int) *skipNode {
+	return &skipNode{key: key, next: make([]unsafe.Pointer, height)}
+}
+
+func (n *skipNode) Next(level int) *skipNode {
+	return (*skipNode)(atomic.LoadPointer(&n.next[level]))
+}
+
+func (n *skipNode) SetNext(level int, x *skipNode) {
+	atomic.StorePointer(&n.next[level], unsafe.Pointer(x))
+}
+
+func (n *skipNode) NoBarrier_Next(level int) *skipNode {
+	return (*skipNode)(n.next[level])
+}
+
+func (n *skipNode) NoBarrier_SetNext(level int, x *skipNode) {
+	n.next[level] = unsafe.Pointer(x)
+}
+
+type SkipList struct {
+	compare_ utils.Comparator
+	head_    *skipNode
+	// Modified only by Insert().  Read racily by readers, but stale
+	// values are ok.
+	max_height_ int32
+	// Read/written only by Insert().
+	rnd_ *rand.Rand
+}
+
+func NewSkipList(cmp utils.Comparator) *SkipList {
+	return &SkipList{
+		compare_:    cmp,
+		head_:       newSkipNode("", kMaxHeight),
+		max_height_: 1,
+		rnd_:        rand.New(rand.NewSource(0xdeadbeef)),
+	}
+}
+
+func (sl *SkipList) GetMaxHeight() int {
+	return int(atomic.LoadInt32(&sl.max_height_))
+}
+
+func (sl *SkipList) RandomHeight() int {
+	// Increase height with probability 1 in kBranching
+	const kBranching = 4
+	height := 1
+	for height < kMaxHeight && sl.rnd_.Intn(kBranching) == 0 {
+		height += 1
+	}
+	return height
+}
+
+func (sl *SkipList) Equal(a, b string) bool {
+	return sl.compare_.Compare(a, b) == 0
+}
+
+// KeyIsAfterNode returns true if key is greater than the data stored in "n"
+func (sl *SkipList) KeyIsAfterNode(key string, n *skipNode) bool {
+	// nil n is considered infinite
+	return n != nil && sl.compare_.Compare(n.key, key) < 0
+}
+
+// FindGreaterOrEqual returns the earliest node that comes at or after key.
+// Return nil if there is no such node.
+//
+// If prev is non-nil, fills prev[level] with pointer to previous
+// node at "level" for every level in [0..max_height_-1].
+func (sl *SkipList) FindGreaterOrEqual(key string, prev []*skipNode) *skipNode {
+	x := sl.head_
+	level := sl.GetMaxHeight() - 1
+	for {
+		next := x.Next(level)
+		if sl.KeyIsAfterNode(key, next) {
+			// Keep searching in this list
+			x = next
+		} else {
+			if prev != nil {
+				prev[level] = x
+			}
+			if level == 0 {
+				return next
+			}
+			// Switch to next list
+			level -= 1
+		}
+	}
+}
+
+// FindLessThan returns the latest node with a key < key.
+// Return head_ if there is no such node.
+func (sl *SkipList) FindLessThan(key string) *skipNode {
+	x := sl.head_
+	level := sl.GetMaxHeight() - 1
+	for {
+		next := x.Next(level)
+		if next == nil || sl.compare_.Compare(next.key, key) >= 0 {
+			if level == 0 {
+				return x
+			}
+			// Switch to next list
+			level -= 1
+		} else {
+			x = next
+		}
+	}
+}
+
+// FindLast returns the last node in the list.
+// Return head_ if list is empty.
+func (sl *SkipList) FindLast() *skipNode {
+	x := sl.head_
+	level := sl.GetMaxHeight() - 1
+	for {
+		next := x.Next(level)
+		if next == nil {
+			if level == 0 {
+				return x
+			}
+			// Switch to next list
+			level -= 1
+		} else {
+			x = next
+		}
+	}
+}
+
+// Insert key into the list.
+// REQUIRES: nothing that compares equal to key is currently in the list.
+func (sl *SkipList) Insert(key string) {
+	prev := make([]*skipNode, kMaxHeight)
+	x := sl.FindGreaterOrEqual(key, prev)
+
+	// Our data structure does not allow duplicate insertion
+	if x != nil && sl.Equal(key, x.key) {
+		panic("skiplist: duplicate insertion")
+	}
+
+	height := sl.RandomHeight()
+	if height > sl.GetMaxHeight() {
+		for i := sl.GetMaxHeight(); i < height; i += 1 {
+			prev[i] = sl.head_
+		}
+		// It is ok to mutate max_height_ without any synchronization
+		// with concurrent readers.  A concurrent reader that observes
+		// the new value of max_height_ will see either the old value of
+		// new level pointers from head_ (nil), or a new value set in
+		// the loop below.  In the former case the reader will
+		// immediately drop to the next level since nil sorts after all
+		// keys.  In the latter case the reader will use the new node.
+		atomic.StoreInt32(&sl.max_height_, int32(height))
+	}
+
+	x = newSkipNode(key, height)
+	for i := 0; i < height; i += 1 {
+		// NoBarrier_SetNext() suffices since we will add a barrier when
+		// we publish a pointer to "x" in prev[i].
+		x.NoBarrier_SetNext(i, prev[i].NoBarrier_Next(i))
+		prev[i].SetNext(i, x)
+	}
+}
+
+// Contains returns true iff an entry that compares equal to key is in the list.
+func (sl *SkipList) Contains(key string) bool {
+	x := sl.FindGreaterOrEqual(key, nil)
+	return x != nil && sl.Equal(key, x.key)
+}
+
+// SkipListIterator iterates over the contents of a skip list
+type SkipListIterator struct {
+	list_ *SkipList
+	node_ *skipNode
+}
+
+// NewSkipListIterator initialize an iterator over the specified list.
+// The returned iterator is not valid.
+func NewSkipListIterator(list *SkipList) *SkipListIterator {
+	return &SkipListIterator{list_: list}
+}
+
+// Valid returns true iff the iterator is positioned at a valid node.
+func (it *SkipListIterator) Valid() bool {
+	return it.node_ != nil
+}
+
+// Key returns the key at the current position.
+// REQUIRES: Valid()
+func (it *SkipListIterator) Key() string {
+	return it.node_.key
+}
+
+// Next advances to the next position.
+// REQUIRES: Valid()
+func (it *SkipListIterator) Next() {
+	it.node_ = it.node_.Next(0)
+}
+
+// Prev advances to the previous position.
+// REQUIRES: Valid()
+func (it *SkipListIterator) Prev() {
+	// Instead of using explicit "prev" links, we just search for the
+	// last node that falls before key.
+	it.node_ = it.list_.FindLessThan(it.node_.key)
+	if it.node_ == it.list_.head_ {
+		it.node_ = nil
+	}
+}
+
+// Seek advances to the first entry with a key >= target
+func (it *SkipListIterator) Seek(target string) {
+	it.node_ = it.list_.FindGreaterOrEqual(target, nil)
+}
+
+// SeekToFirst position at the first entry in list.
+// Final state of iterator is Valid() iff list is not empty.
+func (it *SkipListIterator) SeekToFirst() {
+	it.node_ = it.list_.head_.Next(0)
+}
+
+// SeekToLast position at the last entry in list.
+// Final state of iterator is Valid() iff list is not empty.
+func (it *SkipListIterator) SeekToLast() {
+	it.node_ = it.list_.FindLast()
+	if it.node_ == it.list_.head_ {
+		it.node_ = nil
+	}
+}
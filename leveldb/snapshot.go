@@ -0,0 +1,80 @@
+package leveldb
+
+// Snapshot is an immutable view of the DB as of a sequence number.
+// Snapshots are kept in a doubly-linked circular list within each
+// DBImpl.
+type Snapshot struct {
+	sequence_ SequenceNumber
+
+	// Snapshot is kept in a doubly-linked circular list. The SnapshotList
+	// implementation operates on the next/previous fields directly.
+	prev_ *Snapshot
+	next_ *Snapshot
+
+	list_ *SnapshotList
+}
+
+func (s *Snapshot) Sequence() SequenceNumber {
+	return s.sequence_
+}
+
+// SnapshotList is the list of the snapshots a DBImpl has handed out,
+// ordered from oldest to newest.
+// REQUIRES: external synchronization (DBImpl.lock)
+type SnapshotList struct {
+	// Dummy head of doubly-linked list of snapshots
+	head_ Snapshot
+}
+
+func NewSnapshotList() *SnapshotList {
+	l := &SnapshotList{}
+	l.head_.prev_ = &l.head_
+	l.head_.next_ = &l.head_
+	return l
+}
+
+func (l *SnapshotList) empty() bool {
+	return l.head_.next_ == &l.head_
+}
+
+func (l *SnapshotList) oldest() *Snapshot {
+	if l.empty() {
+		panic("leveldb: oldest of an empty snapshot list")
+	}
+	return l.head_.next_
+}
+
+func (l *SnapshotList) newest() *Snapshot {
+	if l.empty() {
+		panic("leveldb: newest of an empty snapshot list")
+	}
+	return l.head_.prev_
+}
+
+// New creates a Snapshot and appends it to the end of the list.
+func (l *SnapshotList) New(sequence_number SequenceNumber) *Snapshot {
+	if !l.empty() && l.newest().sequence_ > sequence_number {
+		panic("leveldb: snapshots must be created in sequence order")
+	}
+
+	snapshot := &Snapshot{sequence_: sequence_number, list_: l}
+	snapshot.next_ = &l.head_
+	snapshot.prev_ = l.head_.prev_
+	snapshot.prev_.next_ = snapshot
+	snapshot.next_.prev_ = snapshot
+	return snapshot
+}
+
+// Delete removes a snapshot from this list.
+//
+// The snapshot must have been created by calling New() on this list.
+func (l *SnapshotList) Delete(snapshot *Snapshot) {
+	if snapshot.list_ != l {
+		panic("leveldb: snapshot released to the wrong list")
+	}
+	snapshot.prev_.next_ = snapshot.next_
+	snapshot.next_.prev_ = snapshot.prev_
+	snapshot.prev_ = nil
+	snapshot.next_ = nil
+	snapshot.list_ = nil
+}
@@ -1,5 +1,9 @@
 package leveldb
 
+import "errors"
+
+var ErrNotFound = errors.New("leveldb: not found")
+
 type Status struct {
 }
 
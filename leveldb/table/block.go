@@ -0,0 +1,256 @@
+package table
+
+import (
+	"sort"
+
+	"github.com/lemonwx/goleveldb/leveldb/utils"
+)
+
+// Block is the parsed form of a block written by BlockBuilder.
+type Block struct {
+	data_           []byte
+	restart_offset_ int // Offset in data_ of restart array
+}
+
+// NewBlock initializes the block with the specified contents.
+func NewBlock(contents []byte) *Block {
+	b := &Block{data_: contents}
+	if len(contents) < 4 {
+		b.data_ = nil // Error marker
+	} else {
+		max_restarts_allowed := (len(contents) - 4) / 4
+		if b.NumRestarts() > max_restarts_allowed {
+			// The size is too small for NumRestarts()
+			b.data_ = nil
+		} else {
+			b.restart_offset_ = len(contents) - (1+b.NumRestarts())*4
+		}
+	}
+	return b
+}
+
+func (b *Block) Size() int {
+	return len(b.data_)
+}
+
+func (b *Block) NumRestarts() int {
+	return int(utils.DecodeFixed32(b.data_[len(b.data_)-4:]))
+}
+
+func (b *Block) NewIterator(comparator utils.Comparator) Iterator {
+	if len(b.data_) < 4 {
+		return NewErrorIterator(utils.NewCorruption("bad block contents"))
+	}
+	num_restarts := b.NumRestarts()
+	if num_restarts == 0 {
+		return NewEmptyIterator()
+	}
+	return &BlockIterator{
+		comparator_:    comparator,
+		data_:          b.data_,
+		restarts_:      b.restart_offset_,
+		num_restarts_:  num_restarts,
+		current_:       b.restart_offset_,
+		restart_index_: num_restarts,
+	}
+}
+
+// DecodeEntry decodes the next block entry starting at p, storing the
+// number of shared key bytes, non_shared key bytes and the length of
+// the value.  Returns the offset of the key delta, or -1 on error.
+func DecodeEntry(data []byte, p int, limit int) (int, uint32, uint32, uint32) {
+	if limit-p < 3 {
+		return -1, 0, 0, 0
+	}
+	shared := uint32(data[p])
+	non_shared := uint32(data[p+1])
+	value_length := uint32(data[p+2])
+	if (shared | non_shared | value_length) < 128 {
+		// Fast path: all three values are encoded in one byte each
+		p += 3
+	} else {
+		var l int
+		var err error
+		if shared, l, err = utils.GetVarInt32(data[p:limit]); err != nil {
+			return -1, 0, 0, 0
+		}
+		p += l
+		if non_shared, l, err = utils.GetVarInt32(data[p:limit]); err != nil {
+			return -1, 0, 0, 0
+		}
+		p += l
+		if value_length, l, err = utils.GetVarInt32(data[p:limit]); err != nil {
+			return -1, 0, 0, 0
+		}
+		p += l
+	}
+
+	if uint32(limit-p) < (non_shared + value_length) {
+		return -1, 0, 0, 0
+	}
+	return p, shared, non_shared, value_length
+}
+
+// BlockIterator iterates over the entries of a Block.
+type BlockIterator struct {
+	comparator_   utils.Comparator
+	data_         []byte // underlying block contents
+	restarts_     int    // Offset of restart array (list of fixed32)
+	num_restarts_ int    // Number of uint32 entries in restart array
+
+	// current_ is offset in data_ of current entry.  >= restarts_ if !Valid
+	current_       int
+	restart_index_ int // Index of restart block in which current_ falls
+	key_           []byte
+	value_offset_  int
+	value_len_     int
+	status_        error
+}
+
+func (it *BlockIterator) Compare(a, b []byte) int {
+	return it.comparator_.Compare(string(a), string(b))
+}
+
+// NextEntryOffset returns the offset in data_ just past the end of the current entry.
+func (it *BlockIterator) NextEntryOffset() int {
+	return it.value_offset_ + it.value_len_
+}
+
+func (it *BlockIterator) GetRestartPoint(index int) int {
+	return int(utils.DecodeFixed32(it.data_[it.restarts_+index*4:]))
+}
+
+func (it *BlockIterator) SeekToRestartPoint(index int) {
+	it.key_ = it.key_[:0]
+	it.restart_index_ = index
+	// current_ will be fixed by ParseNextKey();
+
+	// ParseNextKey() starts at the end of value_, so set value_ accordingly
+	it.value_offset_ = it.GetRestartPoint(index)
+	it.value_len_ = 0
+}
+
+func (it *BlockIterator) Valid() bool {
+	return it.current_ < it.restarts_
+}
+
+func (it *BlockIterator) Error() error {
+	return it.status_
+}
+
+func (it *BlockIterator) Key() []byte {
+	return it.key_
+}
+
+func (it *BlockIterator) Value() []byte {
+	return it.data_[it.value_offset_ : it.value_offset_+it.value_len_]
+}
+
+func (it *BlockIterator) Next() {
+	it.ParseNextKey()
+}
+
+func (it *BlockIterator) Prev() {
+	// Scan backwards to a restart point before current_
+	original := it.current_
+	for it.GetRestartPoint(it.restart_index_) >= original {
+		if it.restart_index_ == 0 {
+			// No more entries
+			it.current_ = it.restarts_
+			it.restart_index_ = it.num_restarts_
+			return
+		}
+		it.restart_index_ -= 1
+	}
+
+	it.SeekToRestartPoint(it.restart_index_)
+	// Loop until end of current entry hits the start of original entry
+	for it.ParseNextKey() && it.NextEntryOffset() < original {
+	}
+}
+
+func (it *BlockIterator) Seek(target []byte) {
+	// Binary search in restart array to find the last restart point
+	// with a key < target
+	failed := false
+	left := sort.Search(it.num_restarts_, func(mid int) bool {
+		if failed {
+			return true
+		}
+		region_offset := it.GetRestartPoint(mid)
+		key_offset, shared, non_shared, _ := DecodeEntry(it.data_, region_offset, it.restarts_)
+		if key_offset < 0 || shared != 0 {
+			failed = true
+			return true
+		}
+		mid_key := it.data_[key_offset : key_offset+int(non_shared)]
+		return it.Compare(mid_key, target) >= 0
+	}) - 1
+	if failed {
+		it.CorruptionError()
+		return
+	}
+	if left < 0 {
+		left = 0
+	}
+
+	// Linear search (within restart block) for first key >= target
+	it.SeekToRestartPoint(left)
+	for {
+		if !it.ParseNextKey() {
+			return
+		}
+		if it.Compare(it.key_, target) >= 0 {
+			return
+		}
+	}
+}
+
+func (it *BlockIterator) SeekToFirst() {
+	it.SeekToRestartPoint(0)
+	it.ParseNextKey()
+}
+
+func (it *BlockIterator) SeekToLast() {
+	it.SeekToRestartPoint(it.num_restarts_ - 1)
+	for it.ParseNextKey() && it.NextEntryOffset() < it.restarts_ {
+		// Keep skipping
+	}
+}
+
+func (it *BlockIterator) Close() error {
+	return nil
+}
+
+func (it *BlockIterator) CorruptionError() {
+	it.current_ = it.restarts_
+	it.restart_index_ = it.num_restarts_
+	it.status_ = utils.NewCorruption("bad entry in block")
+	it.key_ = it.key_[:0]
+	it.value_offset_ = it.restarts_
+	it.value_len_ = 0
+}
+
+func (it *BlockIterator) ParseNextKey() bool {
+	it.current_ = it.NextEntryOffset()
+	if it.current_ >= it.restarts_ {
+		// No more entries to return.  Mark as invalid.
+		it.current_ = it.restarts_
+		it.restart_index_ = it.num_restarts_
+		return false
+	}
+
+	// Decode next entry
+	p, shared, non_shared, value_length := DecodeEntry(it.data_, it.current_, it.restarts_)
+	if p < 0 || len(it.key_) < int(shared) {
+		it.CorruptionError()
+		return false
+	}
+	it.key_ = append(it.key_[:shared], it.data_[p:p+int(non_shared)]...)
+	it.value_offset_ = p + int(non_shared)
+	it.value_len_ = int(value_length)
+	for it.restart_index_+1 < it.num_restarts_ && it.GetRestartPoint(it.restart_index_+1) < it.current_ {
+		it.restart_index_ += 1
+	}
+	return true
+}
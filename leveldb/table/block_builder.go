@@ -0,0 +1,121 @@
+package table
+
+import "github.com/lemonwx/goleveldb/leveldb/utils"
+
+// BlockBuilder generates blocks where keys are prefix-compressed:
+//
+// When we store a key, we drop the prefix shared with the previous
+// string.  This helps reduce the space requirement significantly.
+// Furthermore, once every K keys, we do not apply the prefix
+// compression and store the entire key.  We call this a "restart
+// point".  The tail end of the block stores the offsets of all of the
+// restart points, and can be used to do a binary search when looking
+// for a particular key.  Values are stored as-is (without compression)
+// immediately following the corresponding key.
+//
+// An entry for a particular key-value pair has the form:
+//
+//	shared_bytes: varint32
+//	unshared_bytes: varint32
+//	value_length: varint32
+//	key_delta: char[unshared_bytes]
+//	value: char[value_length]
+//
+// shared_bytes == 0 for restart points.
+//
+// The trailer of the block has the form:
+//
+//	restarts: uint32[num_restarts]
+//	num_restarts: uint32
+//
+// restarts[i] contains the offset within the block of the ith restart point.
+type BlockBuilder struct {
+	options_  *Options
+	buffer_   []byte   // Destination buffer
+	restarts_ []uint32 // Restart points
+	counter_  int      // Number of entries emitted since restart
+	finished_ bool     // Has Finish() been called?
+	last_key_ []byte
+}
+
+func NewBlockBuilder(options *Options) *BlockBuilder {
+	if options.BlockRestartInterval < 1 {
+		panic("table: BlockRestartInterval must be positive")
+	}
+	b := &BlockBuilder{options_: options}
+	b.Reset()
+	return b
+}
+
+// Reset the contents as if the BlockBuilder was just constructed.
+func (b *BlockBuilder) Reset() {
+	b.buffer_ = b.buffer_[:0]
+	b.restarts_ = append(b.restarts_[:0], 0) // First restart point is at offset 0
+	b.counter_ = 0
+	b.finished_ = false
+	b.last_key_ = b.last_key_[:0]
+}
+
+// CurrentSizeEstimate returns an estimate of the current (uncompressed)
+// size of the block we are building.
+func (b *BlockBuilder) CurrentSizeEstimate() int {
+	return len(b.buffer_) + // Raw data buffer
+		len(b.restarts_)*4 + // Restart array
+		4 // Restart array length
+}
+
+// Finish building the block and return a slice that refers to the
+// block contents.  The returned slice will remain valid for the
+// lifetime of this builder or until Reset() is called.
+func (b *BlockBuilder) Finish() []byte {
+	// Append restart array
+	for _, r := range b.restarts_ {
+		utils.PutFixed32(&b.buffer_, r)
+	}
+	utils.PutFixed32(&b.buffer_, uint32(len(b.restarts_)))
+	b.finished_ = true
+	return b.buffer_
+}
+
+// Add appends key and value to the block.
+// REQUIRES: Finish() has not been called since the last call to Reset().
+// REQUIRES: key is larger than any previously added key
+func (b *BlockBuilder) Add(key, value []byte) {
+	if b.finished_ {
+		panic("table: BlockBuilder.Add after Finish")
+	}
+	shared := 0
+	if b.counter_ < b.options_.BlockRestartInterval {
+		// See how much sharing to do with previous string
+		min_length := len(b.last_key_)
+		if len(key) < min_length {
+			min_length = len(key)
+		}
+		for shared < min_length && b.last_key_[shared] == key[shared] {
+			shared += 1
+		}
+	} else {
+		// Restart compression
+		b.restarts_ = append(b.restarts_, uint32(len(b.buffer_)))
+		b.counter_ = 0
+	}
+	non_shared := len(key) - shared
+
+	// Add "<shared><non_shared><value_size>" to buffer_
+	utils.PutVarint32(&b.buffer_, uint32(shared))
+	utils.PutVarint32(&b.buffer_, uint32(non_shared))
+	utils.PutVarint32(&b.buffer_, uint32(len(value)))
+
+	// Add string delta to buffer_ followed by value
+	b.buffer_ = append(b.buffer_, key[shared:]...)
+	b.buffer_ = append(b.buffer_, value...)
+
+	// Update state
+	b.last_key_ = append(b.last_key_[:shared], key[shared:]...)
+	b.counter_ += 1
+}
+
+// Empty returns true iff no entries have been added since the last Reset()
+func (b *BlockBuilder) Empty() bool {
+	return len(b.buffer_) == 0
+}
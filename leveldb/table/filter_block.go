@@ -0,0 +1,129 @@
+package table
+
+import "github.com/lemonwx/goleveldb/leveldb/utils"
+
+// Generate new filter every 2KB of data
+const (
+	kFilterBaseLg = 11
+	kFilterBase   = 1 << kFilterBaseLg
+)
+
+// A FilterBlockBuilder is used to construct all of the filters for a
+// particular Table.  It generates a single string which is stored as
+// a special block in the Table.
+//
+// The sequence of calls to FilterBlockBuilder must match the regexp
+// (StartBlock AddKey*)* Finish.
+type FilterBlockBuilder struct {
+	policy_         utils.FilterPolicy
+	keys_           []byte   // Flattened key contents
+	start_          []int    // Starting index in keys_ of each key
+	result_         []byte   // Filter data computed so far
+	tmp_keys_       [][]byte // policy_.CreateFilter() argument
+	filter_offsets_ []uint32
+}
+
+func NewFilterBlockBuilder(policy utils.FilterPolicy) *FilterBlockBuilder {
+	return &FilterBlockBuilder{policy_: policy}
+}
+
+func (b *FilterBlockBuilder) StartBlock(block_offset uint64) {
+	filter_index := block_offset / kFilterBase
+	if filter_index < uint64(len(b.filter_offsets_)) {
+		panic("table: FilterBlockBuilder.StartBlock offset goes backwards")
+	}
+	for filter_index > uint64(len(b.filter_offsets_)) {
+		b.GenerateFilter()
+	}
+}
+
+func (b *FilterBlockBuilder) AddKey(key []byte) {
+	b.start_ = append(b.start_, len(b.keys_))
+	b.keys_ = append(b.keys_, key...)
+}
+
+func (b *FilterBlockBuilder) Finish() []byte {
+	if len(b.start_) != 0 {
+		b.GenerateFilter()
+	}
+
+	// Append array of per-filter offsets
+	array_offset := uint32(len(b.result_))
+	for _, offset := range b.filter_offsets_ {
+		utils.PutFixed32(&b.result_, offset)
+	}
+
+	utils.PutFixed32(&b.result_, array_offset)
+	b.result_ = append(b.result_, kFilterBaseLg) // Save encoding parameter in result
+	return b.result_
+}
+
+func (b *FilterBlockBuilder) GenerateFilter() {
+	num_keys := len(b.start_)
+	if num_keys == 0 {
+		// Fast path if there are no keys for this filter
+		b.filter_offsets_ = append(b.filter_offsets_, uint32(len(b.result_)))
+		return
+	}
+
+	// Make list of keys from flattened key structure
+	b.start_ = append(b.start_, len(b.keys_)) // Simplify length computation
+	b.tmp_keys_ = b.tmp_keys_[:0]
+	for i := 0; i < num_keys; i += 1 {
+		b.tmp_keys_ = append(b.tmp_keys_, b.keys_[b.start_[i]:b.start_[i+1]])
+	}
+
+	// Generate filter for current set of keys and append to result_.
+	b.filter_offsets_ = append(b.filter_offsets_, uint32(len(b.result_)))
+	b.policy_.CreateFilter(b.tmp_keys_, &b.result_)
+
+	b.tmp_keys_ = b.tmp_keys_[:0]
+	b.keys_ = b.keys_[:0]
+	b.start_ = b.start_[:0]
+}
+
+// FilterBlockReader answers whether a key may be in the data block at
+// a given offset, using the filters built by a FilterBlockBuilder.
+type FilterBlockReader struct {
+	policy_  utils.FilterPolicy
+	data_    []byte // Filter data (at block-start)
+	offset_  int    // Beginning of offset array in data_ (at block-end)
+	num_     int    // Number of entries in offset array
+	base_lg_ uint   // Encoding parameter (see kFilterBaseLg)
+}
+
+// NewFilterBlockReader returns a reader of the filter block contents.
+// REQUIRES: "contents" and policy must stay live while the reader is
+// live.
+func NewFilterBlockReader(policy utils.FilterPolicy, contents []byte) *FilterBlockReader {
+	r := &FilterBlockReader{policy_: policy}
+	n := len(contents)
+	if n < 5 {
+		return r // 1 byte for base_lg_ and 4 for start of offset array
+	}
+	r.base_lg_ = uint(contents[n-1])
+	last_word := int(utils.DecodeFixed32(contents[n-5:]))
+	if last_word > n-5 {
+		return r
+	}
+	r.data_ = contents
+	r.offset_ = last_word
+	r.num_ = (n - 5 - last_word) / 4
+	return r
+}
+
+func (r *FilterBlockReader) KeyMayMatch(block_offset uint64, key []byte) bool {
+	index := block_offset >> r.base_lg_
+	if index < uint64(r.num_) {
+		start := int(utils.DecodeFixed32(r.data_[r.offset_+int(index)*4:]))
+		limit := int(utils.DecodeFixed32(r.data_[r.offset_+int(index)*4+4:]))
+		if start <= limit && limit <= r.offset_ {
+			filter := r.data_[start:limit]
+			return r.policy_.KeyMayMatch(key, filter)
+		} else if start == limit {
+			// Empty filters do not match any keys
+			return false
+		}
+	}
+	return true // Errors are treated as potential matches
+}
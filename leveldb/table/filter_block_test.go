@@ -0,0 +1,102 @@
+package table
+
+import (
+	"testing"
+
+	"github.com/lemonwx/goleveldb/leveldb/utils"
+)
+
+// testHashFilter is a filter policy whose filters list the hashes of
+// their keys, so it matches exactly the keys it was built from.
+type testHashFilter struct{}
+
+func (testHashFilter) Name() string {
+	return "TestHashFilter"
+}
+
+func (testHashFilter) CreateFilter(keys [][]byte, dst *[]byte) {
+	for _, key := range keys {
+		utils.PutFixed32(dst, utils.Hash(key, 1))
+	}
+}
+
+func (testHashFilter) KeyMayMatch(key []byte, filter []byte) bool {
+	h := utils.Hash(key, 1)
+	for i := 0; i+4 <= len(filter); i += 4 {
+		if h == utils.DecodeFixed32(filter[i:]) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestFilterBlockEmpty(t *testing.T) {
+	builder := NewFilterBlockBuilder(testHashFilter{})
+	block := builder.Finish()
+	if string(block) != "\x00\x00\x00\x00\x0b" {
+		t.Errorf("empty filter block = %q", block)
+	}
+	reader := NewFilterBlockReader(testHashFilter{}, block)
+	if !reader.KeyMayMatch(0, []byte("foo")) || !reader.KeyMayMatch(100000, []byte("foo")) {
+		t.Error("a filter block without filters must match every key")
+	}
+}
+
+func TestFilterBlock(t *testing.T) {
+	builder := NewFilterBlockBuilder(testHashFilter{})
+
+	// First filter
+	builder.StartBlock(0)
+	builder.AddKey([]byte("foo"))
+	builder.StartBlock(2000)
+	builder.AddKey([]byte("bar"))
+
+	// Second filter
+	builder.StartBlock(3100)
+	builder.AddKey([]byte("box"))
+
+	// Third filter is empty
+
+	// Last filter
+	builder.StartBlock(9000)
+	builder.AddKey([]byte("box"))
+	builder.AddKey([]byte("hello"))
+
+	reader := NewFilterBlockReader(testHashFilter{}, builder.Finish())
+	tests := []struct {
+		block_offset uint64
+		key          string
+		want         bool
+	}{
+		{0, "foo", true},
+		{2000, "bar", true},
+		{0, "box", false},
+		{0, "hello", false},
+		{3100, "box", true},
+		{3100, "foo", false},
+		{3100, "bar", false},
+		{3100, "hello", false},
+		{4100, "foo", false},
+		{4100, "bar", false},
+		{4100, "box", false},
+		{4100, "hello", false},
+		{9000, "box", true},
+		{9000, "hello", true},
+		{9000, "foo", false},
+		{9000, "bar", false},
+		// Offsets past the last filter are treated as potential matches.
+		{100000, "foo", true},
+	}
+	for _, tt := range tests {
+		if got := reader.KeyMayMatch(tt.block_offset, []byte(tt.key)); got != tt.want {
+			t.Errorf("KeyMayMatch(%d, %q) = %v, want %v", tt.block_offset, tt.key, got, tt.want)
+		}
+	}
+
+	// A corrupted block is treated as matching every key.
+	for _, contents := range [][]byte{nil, []byte("\x00\x00"), []byte("\xff\x00\x00\x00\x0b")} {
+		if !NewFilterBlockReader(testHashFilter{}, contents).KeyMayMatch(0, []byte("foo")) {
+			t.Errorf("filter block %q does not match", contents)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package table
+
+import (
+	"io"
+
+	"github.com/golang/leveldb/crc"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/lemonwx/goleveldb/leveldb/env"
+	"github.com/lemonwx/goleveldb/leveldb/utils"
+)
+
+// kTableMagicNumber was picked by running
+//
+//	echo http://code.google.com/p/leveldb/ | sha1sum
+//
+// and taking the leading 64 bits.
+const kTableMagicNumber = uint64(0xdb4775248b80fb57)
+
+// 1-byte type + 32-bit crc
+const kBlockTrailerSize = 5
+
+// DO NOT CHANGE THESE ENUM VALUES: they are embedded in the on-disk
+// data structures.
+type CompressionType byte
+
+const (
+	NoCompression     CompressionType = 0x0
+	SnappyCompression CompressionType = 0x1
+	ZstdCompression   CompressionType = 0x2
+)
+
+// The zstd codecs are safe for concurrent EncodeAll and DecodeAll
+// calls, so they are shared by all the tables.  Level 1 of zstd is
+// favored: it compresses well and is about as fast as snappy.
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(1)))
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// Maximum encoding length of a BlockHandle
+const kMaxEncodedLength = 10 + 10
+
+// Encoded length of a Footer.  Note that the serialization of a
+// Footer will always occupy exactly this many bytes.  It consists
+// of two block handles and a magic number.
+const kEncodedLength = 2*kMaxEncodedLength + 8
+
+// BlockHandle is a pointer to the extent of a file that stores a data
+// block or a meta block.
+type BlockHandle struct {
+	offset_ uint64
+	size_   uint64
+}
+
+func NewBlockHandle(offset, size uint64) *BlockHandle {
+	return &BlockHandle{offset_: offset, size_: size}
+}
+
+// The offset of the block in the file.
+func (h *BlockHandle) Offset() uint64 {
+	return h.offset_
+}
+
+// The size of the stored block
+func (h *BlockHandle) Size() uint64 {
+	return h.size_
+}
+
+func (h *BlockHandle) EncodeTo(dst *[]byte) {
+	utils.PutVarint64(dst, h.offset_)
+	utils.PutVarint64(dst, h.size_)
+}
+
+// DecodeFrom decodes a handle from input, returns the number of bytes
+// consumed.
+func (h *BlockHandle) DecodeFrom(input []byte) (int, error) {
+	offset, l1, err := utils.GetVarInt64(input)
+	if err != nil {
+		return 0, utils.NewCorruption("bad block handle")
+	}
+	size, l2, err := utils.GetVarInt64(input[l1:])
+	if err != nil {
+		return 0, utils.NewCorruption("bad block handle")
+	}
+	h.offset_ = offset
+	h.size_ = size
+	return l1 + l2, nil
+}
+
+// Footer encapsulates the fixed information stored at the tail
+// end of every table file.
+type Footer struct {
+	metaindex_handle_ BlockHandle
+	index_handle_     BlockHandle
+}
+
+func (f *Footer) EncodeTo(dst *[]byte) {
+	original_size := len(*dst)
+	f.metaindex_handle_.EncodeTo(dst)
+	f.index_handle_.EncodeTo(dst)
+	// Padding
+	for len(*dst) < original_size+2*kMaxEncodedLength {
+		*dst = append(*dst, 0)
+	}
+	utils.PutFixed32(dst, uint32(kTableMagicNumber&0xffffffff))
+	utils.PutFixed32(dst, uint32(kTableMagicNumber>>32))
+}
+
+func (f *Footer) DecodeFrom(input []byte) error {
+	if len(input) < kEncodedLength {
+		return utils.NewCorruption("file is too short to be an sstable")
+	}
+	magic_ptr := input[kEncodedLength-8:]
+	magic_lo := uint64(utils.DecodeFixed32(magic_ptr))
+	magic_hi := uint64(utils.DecodeFixed32(magic_ptr[4:]))
+	magic := (magic_hi << 32) | magic_lo
+	if magic != kTableMagicNumber {
+		return utils.NewCorruption("not an sstable (bad magic number)")
+	}
+
+	n, err := f.metaindex_handle_.DecodeFrom(input)
+	if err != nil {
+		return err
+	}
+	_, err = f.index_handle_.DecodeFrom(input[n:])
+	return err
+}
+
+// ReadBlock reads the block identified by handle from file, it
+// returns the block contents without the trailer.  On failure
+// it returns a non-nil error.
+func ReadBlock(file env.RandomAccessFile, options *ReadOptions, handle *BlockHandle) ([]byte, error) {
+	// Read the block contents as well as the type/crc footer.
+	// See table_builder.go for the code that built this structure.
+	n := int(handle.Size())
+	buf := make([]byte, n+kBlockTrailerSize)
+	l, err := file.ReadAt(buf, int64(handle.Offset()))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if l != n+kBlockTrailerSize {
+		return nil, utils.NewCorruption("truncated block read")
+	}
+
+	// Check the crc of the type and the block contents
+	if options.VerifyChecksums {
+		expected := utils.DecodeFixed32(buf[n+1:])
+		actual := crc.New(buf[:n+1]).Value()
+		if actual != expected {
+			return nil, utils.NewCorruption("block checksum mismatch")
+		}
+	}
+
+	switch CompressionType(buf[n]) {
+	case NoCompression:
+		return buf[:n], nil
+	case SnappyCompression:
+		ubuf, err := snappy.Decode(nil, buf[:n])
+		if err != nil {
+			return nil, utils.NewCorruption("corrupted snappy compressed block contents")
+		}
+		return ubuf, nil
+	case ZstdCompression:
+		ubuf, err := zstdDecoder.DecodeAll(buf[:n], nil)
+		if err != nil {
+			return nil, utils.NewCorruption("corrupted zstd compressed block contents")
+		}
+		return ubuf, nil
+	default:
+		return nil, utils.NewCorruption("bad block type")
+	}
+}
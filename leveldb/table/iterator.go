@@ -0,0 +1,102 @@
+package table
+
+// Iterator yields a sequence of key/value pairs from a source.
+// An iterator is either positioned at a key/value pair, or
+// not valid.
+//
+// Multiple goroutines can invoke const methods on an Iterator without
+// external synchronization, but if any of the goroutines may call a
+// non-const method, all goroutines accessing the same Iterator must use
+// external synchronization.
+type Iterator interface {
+	// An iterator is either positioned at a key/value pair, or
+	// not valid.  This method returns true iff the iterator is valid.
+	Valid() bool
+
+	// Position at the first key in the source.  The iterator is Valid()
+	// after this call iff the source is not empty.
+	SeekToFirst()
+
+	// Position at the last key in the source.  The iterator is
+	// Valid() after this call iff the source is not empty.
+	SeekToLast()
+
+	// Position at the first key in the source that is at or past target.
+	// The iterator is Valid() after this call iff the source contains
+	// an entry that comes at or past target.
+	Seek(target []byte)
+
+	// Moves to the next entry in the source.  After this call, Valid() is
+	// true iff the iterator was not positioned at the last entry in the source.
+	// REQUIRES: Valid()
+	Next()
+
+	// Moves to the previous entry in the source.  After this call, Valid() is
+	// true iff the iterator was not positioned at the first entry in source.
+	// REQUIRES: Valid()
+	Prev()
+
+	// Return the key for the current entry.  The underlying storage for
+	// the returned slice is valid only until the next modification of
+	// the iterator.
+	// REQUIRES: Valid()
+	Key() []byte
+
+	// Return the value for the current entry.  The underlying storage for
+	// the returned slice is valid only until the next modification of
+	// the iterator.
+	// REQUIRES: Valid()
+	Value() []byte
+
+	// If an error has occurred, return it.  Else return nil.
+	Error() error
+
+	// Release the resources held by the iterator, it must not be used
+	// afterwards.
+	Close() error
+}
+
+type emptyIterator struct {
+	err error
+}
+
+func (it *emptyIterator) Valid() bool        { return false }
+func (it *emptyIterator) SeekToFirst()       {}
+func (it *emptyIterator) SeekToLast()        {}
+func (it *emptyIterator) Seek(target []byte) {}
+func (it *emptyIterator) Next()              { panic("table: Next on empty iterator") }
+func (it *emptyIterator) Prev()              { panic("table: Prev on empty iterator") }
+func (it *emptyIterator) Key() []byte        { panic("table: Key on empty iterator") }
+func (it *emptyIterator) Value() []byte      { panic("table: Value on empty iterator") }
+func (it *emptyIterator) Error() error       { return it.err }
+func (it *emptyIterator) Close() error       { return nil }
+
+// NewEmptyIterator returns an empty iterator (yields nothing).
+func NewEmptyIterator() Iterator {
+	return &emptyIterator{}
+}
+
+// NewErrorIterator returns an empty iterator with the specified error.
+func NewErrorIterator(err error) Iterator {
+	return &emptyIterator{err: err}
+}
+
+type cleanupIterator struct {
+	Iterator
+	cleanup_ func()
+}
+
+func (it *cleanupIterator) Close() error {
+	err := it.Iterator.Close()
+	if it.cleanup_ != nil {
+		it.cleanup_()
+		it.cleanup_ = nil
+	}
+	return err
+}
+
+// NewCleanupIterator returns an iterator that yields the entries of
+// iter and invokes cleanup once the iterator is closed.
+func NewCleanupIterator(iter Iterator, cleanup func()) Iterator {
+	return &cleanupIterator{Iterator: iter, cleanup_: cleanup}
+}
@@ -0,0 +1,177 @@
+package table
+
+import "github.com/lemonwx/goleveldb/leveldb/utils"
+
+type direction int
+
+const (
+	kForward direction = iota
+	kReverse
+)
+
+// MergingIterator yields the union of the entries of its children,
+// ordered by comparator_.
+type MergingIterator struct {
+	// We might want to use a heap in case there are lots of children.
+	// For now we use a simple array since we expect a very small number
+	// of children in leveldb.
+	comparator_ utils.Comparator
+	children_   []Iterator
+	current_    Iterator
+	direction_  direction
+}
+
+// NewMergingIterator returns an iterator that provides the union of the
+// data in children[0,n-1].  Takes ownership of the child iterators and
+// will close them when the result iterator is closed.
+//
+// The result does no duplicate suppression.  I.e., if a particular
+// key is present in K child iterators, it will be yielded K times.
+func NewMergingIterator(comparator utils.Comparator, children []Iterator) Iterator {
+	switch len(children) {
+	case 0:
+		return NewEmptyIterator()
+	case 1:
+		return children[0]
+	}
+	return &MergingIterator{
+		comparator_: comparator,
+		children_:   children,
+		direction_:  kForward,
+	}
+}
+
+func (it *MergingIterator) compare(a, b []byte) int {
+	return it.comparator_.Compare(string(a), string(b))
+}
+
+func (it *MergingIterator) Valid() bool {
+	return it.current_ != nil
+}
+
+func (it *MergingIterator) SeekToFirst() {
+	for _, child := range it.children_ {
+		child.SeekToFirst()
+	}
+	it.FindSmallest()
+	it.direction_ = kForward
+}
+
+func (it *MergingIterator) SeekToLast() {
+	for _, child := range it.children_ {
+		child.SeekToLast()
+	}
+	it.FindLargest()
+	it.direction_ = kReverse
+}
+
+func (it *MergingIterator) Seek(target []byte) {
+	for _, child := range it.children_ {
+		child.Seek(target)
+	}
+	it.FindSmallest()
+	it.direction_ = kForward
+}
+
+func (it *MergingIterator) Next() {
+	// Ensure that all children are positioned after key().
+	// If we are moving in the forward direction, it is already
+	// true for all of the non-current_ children since current_ is
+	// the smallest child and key() == current_.Key().  Otherwise,
+	// we explicitly position the non-current_ children.
+	if it.direction_ != kForward {
+		key := append([]byte{}, it.Key()...)
+		for _, child := range it.children_ {
+			if child != it.current_ {
+				child.Seek(key)
+				if child.Valid() && it.compare(key, child.Key()) == 0 {
+					child.Next()
+				}
+			}
+		}
+		it.direction_ = kForward
+	}
+
+	it.current_.Next()
+	it.FindSmallest()
+}
+
+func (it *MergingIterator) Prev() {
+	// Ensure that all children are positioned before key().
+	// If we are moving in the reverse direction, it is already
+	// true for all of the non-current_ children since current_ is
+	// the largest child and key() == current_.Key().  Otherwise,
+	// we explicitly position the non-current_ children.
+	if it.direction_ != kReverse {
+		key := append([]byte{}, it.Key()...)
+		for _, child := range it.children_ {
+			if child != it.current_ {
+				child.Seek(key)
+				if child.Valid() {
+					// Child is at first entry >= key().  Step back one to be < key()
+					child.Prev()
+				} else {
+					// Child has no entries >= key().  Position at last entry.
+					child.SeekToLast()
+				}
+			}
+		}
+		it.direction_ = kReverse
+	}
+
+	it.current_.Prev()
+	it.FindLargest()
+}
+
+func (it *MergingIterator) Key() []byte {
+	return it.current_.Key()
+}
+
+func (it *MergingIterator) Value() []byte {
+	return it.current_.Value()
+}
+
+func (it *MergingIterator) Error() error {
+	for _, child := range it.children_ {
+		if err := child.Error(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (it *MergingIterator) Close() error {
+	var err error
+	for _, child := range it.children_ {
+		if child_err := child.Close(); err == nil {
+			err = child_err
+		}
+	}
+	it.current_ = nil
+	return err
+}
+
+func (it *MergingIterator) FindSmallest() {
+	var smallest Iterator
+	for _, child := range it.children_ {
+		if child.Valid() {
+			if smallest == nil || it.compare(child.Key(), smallest.Key()) < 0 {
+				smallest = child
+			}
+		}
+	}
+	it.current_ = smallest
+}
+
+func (it *MergingIterator) FindLargest() {
+	var largest Iterator
+	for i := len(it.children_) - 1; i >= 0; i -= 1 {
+		child := it.children_[i]
+		if child.Valid() {
+			if largest == nil || it.compare(child.Key(), largest.Key()) > 0 {
+				largest = child
+			}
+		}
+	}
+	it.current_ = largest
+}
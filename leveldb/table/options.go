@@ -0,0 +1,70 @@
+package table
+
+import (
+	"github.com/lemonwx/goleveldb/leveldb/cache"
+	"github.com/lemonwx/goleveldb/leveldb/utils"
+)
+
+// Options control the layout of the tables written by TableBuilder.
+type Options struct {
+	// Comparator used to define the order of keys in the table,
+	// for a DB this is the internal key comparator.
+	Comparator utils.Comparator
+
+	// Approximate size of user data packed per block.  Note that the
+	// block size specified here corresponds to uncompressed data.  The
+	// actual size of the unit read from disk may be smaller if
+	// compression is enabled.
+	BlockSize int
+
+	// Number of keys between restart points for delta encoding of keys.
+	// Most clients should leave this parameter alone.
+	BlockRestartInterval int
+
+	// If true, the checksums of the index block are verified when a
+	// table is opened.
+	ParanoidChecks bool
+
+	// If non-nil, use the specified filter policy to reduce disk reads.
+	// The filters are built on the keys as ordered by Comparator.
+	FilterPolicy utils.FilterPolicy
+
+	// If non-nil, use the specified cache for the uncompressed blocks.
+	BlockCache cache.Cache
+
+	// Compress blocks using the specified compression algorithm.  A
+	// block is stored uncompressed when compression saves less than
+	// 12.5% of its size.
+	Compression CompressionType
+}
+
+// NewOptions returns Options filled with the defaults.
+func NewOptions() *Options {
+	return &Options{
+		Comparator:           &utils.BytewiseComparator{},
+		BlockSize:            4096,
+		BlockRestartInterval: 16,
+	}
+}
+
+// ReadOptions control the reads from a Table.
+type ReadOptions struct {
+	// If true, all data read from underlying storage will be
+	// verified against corresponding checksums.
+	VerifyChecksums bool
+
+	// Should the data read for this iteration be cached in memory?
+	// Callers may wish to set this field to false for bulk scans.
+	// The zero value does not fill the cache.
+	FillCache bool
+
+	// If non-nil, iterators only need to yield the keys at or after
+	// LowerBound: they stop moving backwards, without reading the
+	// blocks, once the remaining entries all order before it.
+	LowerBound []byte
+
+	// If non-nil, iterators only need to yield the keys before
+	// UpperBound: they stop moving forwards, without reading the
+	// blocks, once the remaining entries all order at or after it.
+	UpperBound []byte
+}
@@ -0,0 +1,222 @@
+package table
+
+import (
+	"io"
+
+	"github.com/lemonwx/goleveldb/leveldb/env"
+	"github.com/lemonwx/goleveldb/leveldb/utils"
+)
+
+// Table is a sorted map from strings to strings.  Tables are
+// immutable and persistent.  A Table may be safely accessed from
+// multiple goroutines without external synchronization.
+type Table struct {
+	options_     *Options
+	file_        env.RandomAccessFile
+	metaindex_   BlockHandle // Handle to metaindex_block: saved from footer
+	index_block_ *Block
+	filter_      *FilterBlockReader
+	cache_id_    uint64 // Partitions the keys of options_.BlockCache
+}
+
+// Open attempts to open the table that is stored in bytes [0..file_size)
+// of "file", and read the metadata entries necessary to allow
+// retrieving data from the table.
+//
+// If successful, returns the newly opened table.  The client should
+// call Close() on it when no longer needed.  If there was an error
+// while initializing the table, returns a nil table and a non-nil
+// error.  Does not take ownership of "file" on failure.
+func Open(options *Options, file env.RandomAccessFile, size uint64) (*Table, error) {
+	if size < kEncodedLength {
+		return nil, utils.NewCorruption("file is too short to be an sstable")
+	}
+
+	footer_space := make([]byte, kEncodedLength)
+	n, err := file.ReadAt(footer_space, int64(size-kEncodedLength))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	footer := &Footer{}
+	if err := footer.DecodeFrom(footer_space[:n]); err != nil {
+		return nil, err
+	}
+
+	// Read the index block
+	opt := &ReadOptions{}
+	if options.ParanoidChecks {
+		opt.VerifyChecksums = true
+	}
+	index_block_contents, err := ReadBlock(file, opt, &footer.index_handle_)
+	if err != nil {
+		return nil, err
+	}
+
+	// We've successfully read the footer and the index block: we're
+	// ready to serve requests.
+	t := &Table{
+		options_:     options,
+		file_:        file,
+		metaindex_:   footer.metaindex_handle_,
+		index_block_: NewBlock(index_block_contents),
+	}
+	if options.BlockCache != nil {
+		t.cache_id_ = options.BlockCache.NewId()
+	}
+	t.ReadMeta(footer)
+	return t, nil
+}
+
+// ReadMeta loads the filter block named in the metaindex block, if the
+// table options carry a filter policy.
+func (t *Table) ReadMeta(footer *Footer) {
+	if t.options_.FilterPolicy == nil {
+		return // Do not need any metadata
+	}
+
+	opt := &ReadOptions{}
+	if t.options_.ParanoidChecks {
+		opt.VerifyChecksums = true
+	}
+	contents, err := ReadBlock(t.file_, opt, &footer.metaindex_handle_)
+	if err != nil {
+		// Do not propagate errors since meta info is not needed for operation
+		return
+	}
+	iter := NewBlock(contents).NewIterator(&utils.BytewiseComparator{})
+	defer iter.Close()
+	key := "filter." + t.options_.FilterPolicy.Name()
+	iter.Seek([]byte(key))
+	if iter.Valid() && string(iter.Key()) == key {
+		t.ReadFilter(iter.Value())
+	}
+}
+
+// ReadFilter loads the filter block at filter_handle_value.
+func (t *Table) ReadFilter(filter_handle_value []byte) {
+	filter_handle := &BlockHandle{}
+	if _, err := filter_handle.DecodeFrom(filter_handle_value); err != nil {
+		return
+	}
+
+	// We might want to unify with ReadBlock() if we start
+	// requiring checksum verification in Open.
+	opt := &ReadOptions{}
+	if t.options_.ParanoidChecks {
+		opt.VerifyChecksums = true
+	}
+	block, err := ReadBlock(t.file_, opt, filter_handle)
+	if err != nil {
+		return
+	}
+	t.filter_ = NewFilterBlockReader(t.options_.FilterPolicy, block)
+}
+
+// Close releases the file of the table.
+func (t *Table) Close() error {
+	return t.file_.Close()
+}
+
+// BlockReader converts an index iterator value (i.e., an encoded
+// BlockHandle) into an iterator over the contents of the corresponding
+// block.
+func (t *Table) BlockReader(options *ReadOptions, index_value []byte) Iterator {
+	block_cache := t.options_.BlockCache
+	handle := &BlockHandle{}
+	// We intentionally allow extra stuff in index_value so that we
+	// can add more features in the future.
+	if _, err := handle.DecodeFrom(index_value); err != nil {
+		return NewErrorIterator(err)
+	}
+
+	if block_cache == nil {
+		contents, err := ReadBlock(t.file_, options, handle)
+		if err != nil {
+			return NewErrorIterator(err)
+		}
+		return NewBlock(contents).NewIterator(t.options_.Comparator)
+	}
+
+	var cache_key [16]byte
+	utils.EncodeFixed64(cache_key[:], t.cache_id_)
+	utils.EncodeFixed64(cache_key[8:], handle.Offset())
+	var block *Block
+	cache_handle := block_cache.Lookup(cache_key[:])
+	if cache_handle != nil {
+		block = block_cache.Value(cache_handle).(*Block)
+	} else {
+		contents, err := ReadBlock(t.file_, options, handle)
+		if err != nil {
+			return NewErrorIterator(err)
+		}
+		block = NewBlock(contents)
+		if options.FillCache {
+			cache_handle = block_cache.Insert(cache_key[:], block, block.Size(), nil)
+		}
+	}
+
+	iter := block.NewIterator(t.options_.Comparator)
+	if cache_handle == nil {
+		return iter
+	}
+	return NewCleanupIterator(iter, func() { block_cache.Release(cache_handle) })
+}
+
+// NewIterator returns a new iterator over the table contents.
+// The result of NewIterator() is initially invalid (caller must
+// call one of the Seek methods on the iterator before using it).
+func (t *Table) NewIterator(options *ReadOptions) Iterator {
+	return NewTwoLevelIterator(t.index_block_.NewIterator(t.options_.Comparator), t.BlockReader, options, t.options_.Comparator)
+}
+
+// InternalGet calls handle_result with the entry found after a call to
+// Seek(key), if there is one.  handle_result is not called if the
+// table does not contain an entry at or past key.
+func (t *Table) InternalGet(options *ReadOptions, k []byte, handle_result func(k, v []byte)) error {
+	iiter := t.index_block_.NewIterator(t.options_.Comparator)
+	defer iiter.Close()
+	iiter.Seek(k)
+	if iiter.Valid() {
+		handle_value := iiter.Value()
+		if t.filter_ != nil {
+			handle := &BlockHandle{}
+			if _, err := handle.DecodeFrom(handle_value); err == nil && !t.filter_.KeyMayMatch(handle.Offset(), k) {
+				// Not found
+				return iiter.Error()
+			}
+		}
+		block_iter := t.BlockReader(options, handle_value)
+		block_iter.Seek(k)
+		if block_iter.Valid() {
+			handle_result(block_iter.Key(), block_iter.Value())
+		}
+		err := block_iter.Error()
+		block_iter.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return iiter.Error()
+}
+
+// ApproximateOffsetOf returns an approximate byte offset in the file
+// where the data for key begins (or would begin if the key were
+// present in the file).
+func (t *Table) ApproximateOffsetOf(key []byte) uint64 {
+	index_iter := t.index_block_.NewIterator(t.options_.Comparator)
+	defer index_iter.Close()
+	index_iter.Seek(key)
+	if index_iter.Valid() {
+		handle := &BlockHandle{}
+		if _, err := handle.DecodeFrom(index_iter.Value()); err == nil {
+			return handle.Offset()
+		}
+		// Strange: we can't decode the block handle in the index block.
+		// We'll just return the offset of the metaindex block, which is
+		// close to the whole file size for this case.
+	}
+	// key is past the last key in the file.  Approximate the offset
+	// by returning the offset of the metaindex block (which is
+	// right near the end of the file).
+	return t.metaindex_.Offset()
+}
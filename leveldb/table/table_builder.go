@@ -0,0 +1,262 @@
+package table
+
+import (
+	"github.com/golang/leveldb/crc"
+	"github.com/golang/snappy"
+	"github.com/lemonwx/goleveldb/leveldb/env"
+	"github.com/lemonwx/goleveldb/leveldb/utils"
+	"github.com/lemonwx/log"
+)
+
+// TableBuilder provides the interface used to build a Table
+// (an immutable and sorted map from keys to values).
+//
+// Multiple goroutines can invoke const methods on a TableBuilder without
+// external synchronization, but if any of the goroutines may call a
+// non-const method, all goroutines accessing the same TableBuilder must use
+// external synchronization.
+type TableBuilder struct {
+	options_             *Options
+	index_block_options_ *Options
+	file_                env.WritableFile
+	offset_              uint64
+	status_              error
+	data_block_          *BlockBuilder
+	index_block_         *BlockBuilder
+	last_key_            []byte
+	num_entries_         int64
+	closed_              bool // Either Finish() or Abandon() has been called.
+	filter_block_        *FilterBlockBuilder
+	compressed_output_   []byte // Reused by the compression of the blocks
+
+	// We do not emit the index entry for a block until we have seen the
+	// first key for the next data block.  This allows us to use shorter
+	// keys in the index block.  For example, consider a block boundary
+	// between the keys "the quick brown fox" and "the who".  We can use
+	// "the r" as the key for the index block entry since it is >= all
+	// entries in the first block and < all entries in subsequent
+	// blocks.
+	//
+	// Invariant: pending_index_entry_ is true only if data_block_ is empty.
+	pending_index_entry_ bool
+	pending_handle_      BlockHandle // Handle to add to index block
+}
+
+// NewTableBuilder creates a builder that will store the contents of the
+// table it is building in file.  Does not close the file.  It is up to
+// the caller to close the file after calling Finish().
+func NewTableBuilder(options *Options, file env.WritableFile) *TableBuilder {
+	index_block_options := *options
+	index_block_options.BlockRestartInterval = 1
+	tb := &TableBuilder{
+		options_:             options,
+		index_block_options_: &index_block_options,
+		file_:                file,
+		data_block_:          NewBlockBuilder(options),
+		index_block_:         NewBlockBuilder(&index_block_options),
+	}
+	if options.FilterPolicy != nil {
+		tb.filter_block_ = NewFilterBlockBuilder(options.FilterPolicy)
+		tb.filter_block_.StartBlock(0)
+	}
+	return tb
+}
+
+// Add key,value to the table being constructed.
+// REQUIRES: key is after any previously added key according to comparator.
+// REQUIRES: Finish(), Abandon() have not been called
+func (tb *TableBuilder) Add(key, value []byte) {
+	if tb.closed_ {
+		panic("table: TableBuilder.Add after close")
+	}
+	if tb.status_ != nil {
+		return
+	}
+	if tb.num_entries_ > 0 && tb.options_.Comparator.Compare(string(key), string(tb.last_key_)) <= 0 {
+		panic("table: keys must be added in increasing order")
+	}
+
+	if tb.pending_index_entry_ {
+		separator := tb.options_.Comparator.FindShortestSeparator(string(tb.last_key_), string(key))
+		handle_encoding := []byte{}
+		tb.pending_handle_.EncodeTo(&handle_encoding)
+		tb.index_block_.Add([]byte(separator), handle_encoding)
+		tb.pending_index_entry_ = false
+	}
+
+	if tb.filter_block_ != nil {
+		tb.filter_block_.AddKey(key)
+	}
+
+	tb.last_key_ = append(tb.last_key_[:0], key...)
+	tb.num_entries_ += 1
+	tb.data_block_.Add(key, value)
+
+	estimated_block_size := tb.data_block_.CurrentSizeEstimate()
+	if estimated_block_size >= tb.options_.BlockSize {
+		tb.Flush()
+	}
+}
+
+// Flush any buffered key/value pairs to file.
+// Can be used to ensure that two adjacent entries never live in
+// the same data block.  Most clients should not need to use this method.
+// REQUIRES: Finish(), Abandon() have not been called
+func (tb *TableBuilder) Flush() {
+	if tb.closed_ {
+		panic("table: TableBuilder.Flush after close")
+	}
+	if tb.status_ != nil {
+		return
+	}
+	if tb.data_block_.Empty() {
+		return
+	}
+	tb.WriteBlock(tb.data_block_, &tb.pending_handle_)
+	if tb.status_ == nil {
+		tb.pending_index_entry_ = true
+		tb.status_ = tb.file_.Flush()
+	}
+	if tb.filter_block_ != nil {
+		tb.filter_block_.StartBlock(tb.offset_)
+	}
+}
+
+// WriteBlock writes the finished block with its trailer and stores its
+// location in handle.
+func (tb *TableBuilder) WriteBlock(block *BlockBuilder, handle *BlockHandle) {
+	// File format contains a sequence of blocks where each block has:
+	//    block_data: uint8[n]
+	//    type: uint8
+	//    crc: uint32
+	raw := block.Finish()
+
+	var block_contents []byte
+	Type := tb.options_.Compression
+	switch Type {
+	case NoCompression:
+		block_contents = raw
+	case SnappyCompression:
+		block_contents = snappy.Encode(tb.compressed_output_[:cap(tb.compressed_output_)], raw)
+	case ZstdCompression:
+		block_contents = zstdEncoder.EncodeAll(raw, tb.compressed_output_[:0])
+	default:
+		panic("table: unknown compression type")
+	}
+	if Type != NoCompression {
+		tb.compressed_output_ = block_contents
+		if len(block_contents) >= len(raw)-len(raw)/8 {
+			// Compressed less than 12.5%, so just store uncompressed form
+			block_contents = raw
+			Type = NoCompression
+		}
+	}
+	tb.WriteRawBlock(block_contents, Type, handle)
+	block.Reset()
+}
+
+func (tb *TableBuilder) WriteRawBlock(block_contents []byte, Type CompressionType, handle *BlockHandle) {
+	handle.offset_ = tb.offset_
+	handle.size_ = uint64(len(block_contents))
+	if err := tb.file_.Append(block_contents); err != nil {
+		log.Errorf("write table block failed: %v", err)
+		tb.status_ = err
+		return
+	}
+	trailer := make([]byte, kBlockTrailerSize)
+	trailer[0] = byte(Type)
+	// Extend crc to cover block type
+	CRC := crc.New(block_contents).Update(trailer[:1]).Value()
+	utils.EncodeFixed32(trailer[1:], CRC)
+	if err := tb.file_.Append(trailer); err != nil {
+		log.Errorf("write table block trailer failed: %v", err)
+		tb.status_ = err
+		return
+	}
+	tb.offset_ += uint64(len(block_contents) + kBlockTrailerSize)
+}
+
+// Status returns non-nil iff some error has been detected.
+func (tb *TableBuilder) Status() error {
+	return tb.status_
+}
+
+// Finish building the table.  Stops using the file passed to the
+// constructor after this function returns.
+// REQUIRES: Finish(), Abandon() have not been called
+func (tb *TableBuilder) Finish() error {
+	tb.Flush()
+	if tb.closed_ {
+		panic("table: TableBuilder.Finish after close")
+	}
+	tb.closed_ = true
+
+	var filter_block_handle, metaindex_block_handle, index_block_handle BlockHandle
+
+	// Write filter block
+	if tb.status_ == nil && tb.filter_block_ != nil {
+		tb.WriteRawBlock(tb.filter_block_.Finish(), NoCompression, &filter_block_handle)
+	}
+
+	// Write metaindex block
+	if tb.status_ == nil {
+		meta_index_block := NewBlockBuilder(tb.options_)
+		if tb.filter_block_ != nil {
+			// Add mapping from "filter.Name" to location of filter data
+			key := "filter." + tb.options_.FilterPolicy.Name()
+			handle_encoding := []byte{}
+			filter_block_handle.EncodeTo(&handle_encoding)
+			meta_index_block.Add([]byte(key), handle_encoding)
+		}
+		tb.WriteBlock(meta_index_block, &metaindex_block_handle)
+	}
+
+	// Write index block
+	if tb.status_ == nil {
+		if tb.pending_index_entry_ {
+			successor := tb.options_.Comparator.FindShortSuccessor(string(tb.last_key_))
+			handle_encoding := []byte{}
+			tb.pending_handle_.EncodeTo(&handle_encoding)
+			tb.index_block_.Add([]byte(successor), handle_encoding)
+			tb.pending_index_entry_ = false
+		}
+		tb.WriteBlock(tb.index_block_, &index_block_handle)
+	}
+
+	// Write footer
+	if tb.status_ == nil {
+		footer := &Footer{metaindex_handle_: metaindex_block_handle, index_handle_: index_block_handle}
+		footer_encoding := []byte{}
+		footer.EncodeTo(&footer_encoding)
+		if err := tb.file_.Append(footer_encoding); err != nil {
+			log.Errorf("write table footer failed: %v", err)
+			tb.status_ = err
+		} else {
+			tb.offset_ += uint64(len(footer_encoding))
+		}
+	}
+	return tb.status_
+}
+
+// Abandon indicates that the contents of this builder should be abandoned.  Stops
+// using the file passed to the constructor after this function returns.
+// If the caller is not going to call Finish(), it must call Abandon()
+// before destroying this builder.
+// REQUIRES: Finish(), Abandon() have not been called
+func (tb *TableBuilder) Abandon() {
+	if tb.closed_ {
+		panic("table: TableBuilder.Abandon after close")
+	}
+	tb.closed_ = true
+}
+
+// NumEntries returns the number of calls to Add() so far.
+func (tb *TableBuilder) NumEntries() int64 {
+	return tb.num_entries_
+}
+
+// FileSize returns the size of the file generated so far.  If invoked after a successful
+// Finish() call, returns the size of the final generated file.
+func (tb *TableBuilder) FileSize() uint64 {
+	return tb.offset_
+}
@@ -0,0 +1,831 @@
+package table
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/lemonwx/goleveldb/leveldb/cache"
+	"github.com/lemonwx/goleveldb/leveldb/env"
+	"github.com/lemonwx/goleveldb/leveldb/utils"
+)
+
+const kNumTestKeys = 2000
+
+func testKey(i int) []byte {
+	return []byte(fmt.Sprintf("key%06d", 2*i))
+}
+
+// testMissingKey returns a key that sorts between testKey(i) and
+// testKey(i+1), it is not in the table.
+func testMissingKey(i int) []byte {
+	return []byte(fmt.Sprintf("key%06d", 2*i+1))
+}
+
+func testValue(i int) []byte {
+	return []byte(strings.Repeat(fmt.Sprintf("value%d-", i%10), 10))
+}
+
+type blockEntry struct {
+	shared int
+	key    string
+	value  string
+}
+
+// decodeBlock splits the contents of a finished block into its entries
+// and restart points.
+func decodeBlock(t *testing.T, contents []byte) ([]blockEntry, []uint32) {
+	t.Helper()
+	num_restarts := int(utils.DecodeFixed32(contents[len(contents)-4:]))
+	restarts_offset := len(contents) - 4 - 4*num_restarts
+	restarts := make([]uint32, num_restarts)
+	for i := range restarts {
+		restarts[i] = utils.DecodeFixed32(contents[restarts_offset+4*i:])
+	}
+
+	var entries []blockEntry
+	var last_key []byte
+	data := contents[:restarts_offset]
+	for len(data) > 0 {
+		var header [3]uint32
+		for i := range header {
+			v, n, err := utils.GetVarInt32(data)
+			if err != nil {
+				t.Fatalf("entry %d: %v", len(entries), err)
+			}
+			header[i] = v
+			data = data[n:]
+		}
+		shared, non_shared, value_length := int(header[0]), int(header[1]), int(header[2])
+		key := append(append([]byte{}, last_key[:shared]...), data[:non_shared]...)
+		data = data[non_shared:]
+		entries = append(entries, blockEntry{shared: shared, key: string(key), value: string(data[:value_length])})
+		data = data[value_length:]
+		last_key = key
+	}
+	return entries, restarts
+}
+
+func TestBlockBuilder(t *testing.T) {
+	tests := []struct {
+		name             string
+		restart_interval int
+		keys             []string
+		shared           []int
+		restarts         []uint32
+	}{
+		{name: "empty", restart_interval: 16, restarts: []uint32{0}},
+		{
+			name:             "prefix compressed",
+			restart_interval: 16,
+			keys:             []string{"apple", "applesauce", "apply", "banana"},
+			shared:           []int{0, 5, 4, 0},
+			restarts:         []uint32{0},
+		},
+		{
+			name:             "restart every two keys",
+			restart_interval: 2,
+			keys:             []string{"apple", "applesauce", "apply", "banana", "band"},
+			shared:           []int{0, 5, 0, 0, 0},
+			restarts:         []uint32{0, 35, 67},
+		},
+		{
+			name:             "no sharing",
+			restart_interval: 1,
+			keys:             []string{"a", "ab", "abc"},
+			shared:           []int{0, 0, 0},
+			restarts:         []uint32{0, 7, 16},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := NewOptions()
+			options.BlockRestartInterval = tt.restart_interval
+			b := NewBlockBuilder(options)
+			if !b.Empty() {
+				t.Error("new block is not empty")
+			}
+			for _, key := range tt.keys {
+				b.Add([]byte(key), []byte("v-"+key))
+			}
+			estimate := b.CurrentSizeEstimate()
+			contents := b.Finish()
+			if len(contents) != estimate {
+				t.Errorf("block is %d bytes, estimated %d", len(contents), estimate)
+			}
+
+			entries, restarts := decodeBlock(t, contents)
+			if fmt.Sprint(restarts) != fmt.Sprint(tt.restarts) {
+				t.Errorf("restarts = %v, want %v", restarts, tt.restarts)
+			}
+			if len(entries) != len(tt.keys) {
+				t.Fatalf("decoded %d entries, want %d", len(entries), len(tt.keys))
+			}
+			for i, e := range entries {
+				if e.key != tt.keys[i] || e.value != "v-"+tt.keys[i] || e.shared != tt.shared[i] {
+					t.Errorf("entry %d = %+v, want key %q sharing %d bytes", i, e, tt.keys[i], tt.shared[i])
+				}
+			}
+
+			b.Reset()
+			if !b.Empty() {
+				t.Error("block is not empty after Reset")
+			}
+		})
+	}
+}
+
+func TestFooterRoundTrip(t *testing.T) {
+	footer := &Footer{
+		metaindex_handle_: BlockHandle{offset_: 1 << 40, size_: 17},
+		index_handle_:     BlockHandle{offset_: 12345, size_: 1 << 20},
+	}
+	encoding := []byte{}
+	footer.EncodeTo(&encoding)
+	if len(encoding) != kEncodedLength {
+		t.Fatalf("footer is %d bytes, want %d", len(encoding), kEncodedLength)
+	}
+	decoded := &Footer{}
+	if err := decoded.DecodeFrom(encoding); err != nil {
+		t.Fatal(err)
+	}
+	if *decoded != *footer {
+		t.Errorf("decoded %+v, want %+v", decoded, footer)
+	}
+
+	bad_magic := append([]byte{}, encoding...)
+	bad_magic[len(bad_magic)-1] ^= 0xff
+	if err := decoded.DecodeFrom(bad_magic); !utils.IsCorruption(err) {
+		t.Errorf("DecodeFrom(bad magic) = %v, want corruption", err)
+	}
+	if err := decoded.DecodeFrom(encoding[1:]); !utils.IsCorruption(err) {
+		t.Errorf("DecodeFrom(short) = %v, want corruption", err)
+	}
+}
+
+// writeTable writes kNumTestKeys entries to the table file fname.
+func writeTable(t *testing.T, fname string, options *Options) {
+	t.Helper()
+	file, err := env.Default().NewWritableFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := NewTableBuilder(options, file)
+	for i := 0; i < kNumTestKeys; i += 1 {
+		builder.Add(testKey(i), testValue(i))
+	}
+	if err := builder.Finish(); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if builder.NumEntries() != kNumTestKeys {
+		t.Fatalf("NumEntries() = %d, want %d", builder.NumEntries(), kNumTestKeys)
+	}
+	size, err := env.Default().GetFileSize(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uint64(size) != builder.FileSize() {
+		t.Fatalf("file is %d bytes, FileSize() = %d", size, builder.FileSize())
+	}
+}
+
+// buildTable writes kNumTestKeys entries to fname and opens the result.
+func buildTable(t *testing.T, fname string, options *Options) *Table {
+	t.Helper()
+	writeTable(t, fname, options)
+	size, err := env.Default().GetFileSize(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := env.Default().NewRandomAccessFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl, err := Open(options, file, uint64(size))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tbl
+}
+
+func TestTableBuilder(t *testing.T) {
+	options := NewOptions()
+	options.BlockSize = 1024
+	fname := t.TempDir() + "/000001.ldb"
+	writeTable(t, fname, options)
+
+	contents, err := os.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	footer := &Footer{}
+	if err := footer.DecodeFrom(contents[len(contents)-kEncodedLength:]); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every index entry separates its data block from the next one and
+	// points at a block that holds the keys up to it.
+	index := footer.index_handle_
+	index_entries, _ := decodeBlock(t, contents[index.offset_:index.offset_+index.size_])
+	if len(index_entries) < 2 {
+		t.Fatalf("%d data blocks, want several with 1KB blocks", len(index_entries))
+	}
+	next := 0
+	offset := uint64(0)
+	for i, e := range index_entries {
+		handle := &BlockHandle{}
+		if _, err := handle.DecodeFrom([]byte(e.value)); err != nil {
+			t.Fatal(err)
+		}
+		if handle.offset_ != offset {
+			t.Errorf("block %d at offset %d, want %d", i, handle.offset_, offset)
+		}
+		offset = handle.offset_ + handle.size_ + kBlockTrailerSize
+		entries, _ := decodeBlock(t, contents[handle.offset_:handle.offset_+handle.size_])
+		for _, entry := range entries {
+			if !bytes.Equal([]byte(entry.key), testKey(next)) || !bytes.Equal([]byte(entry.value), testValue(next)) {
+				t.Fatalf("block %d: entry (%q, %q), want key %q", i, entry.key, entry.value, testKey(next))
+			}
+			if entry.key > e.key {
+				t.Errorf("block %d: key %q past its index key %q", i, entry.key, e.key)
+			}
+			next += 1
+		}
+		if next < kNumTestKeys && e.key >= string(testKey(next)) {
+			t.Errorf("index key %q is not before the next block's key %q", e.key, testKey(next))
+		}
+	}
+	if next != kNumTestKeys {
+		t.Errorf("data blocks hold %d entries, want %d", next, kNumTestKeys)
+	}
+}
+
+func TestBlockIterator(t *testing.T) {
+	options := NewOptions()
+	options.BlockRestartInterval = 3
+	b := NewBlockBuilder(options)
+	keys := []string{"a", "ab", "abc", "b", "bcd", "c", "cat", "d"}
+	for _, key := range keys {
+		b.Add([]byte(key), []byte("v-"+key))
+	}
+	block := NewBlock(append([]byte{}, b.Finish()...))
+	if block.NumRestarts() != 3 {
+		t.Errorf("NumRestarts() = %d, want 3", block.NumRestarts())
+	}
+	iter := block.NewIterator(options.Comparator)
+	defer iter.Close()
+
+	i := 0
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		if string(iter.Key()) != keys[i] || string(iter.Value()) != "v-"+keys[i] {
+			t.Fatalf("entry %d = (%q, %q), want %q", i, iter.Key(), iter.Value(), keys[i])
+		}
+		i += 1
+	}
+	if i != len(keys) {
+		t.Errorf("forward scan saw %d entries, want %d", i, len(keys))
+	}
+	for iter.SeekToLast(); iter.Valid(); iter.Prev() {
+		i -= 1
+		if string(iter.Key()) != keys[i] {
+			t.Fatalf("backward entry %d = %q, want %q", i, iter.Key(), keys[i])
+		}
+	}
+	if i != 0 {
+		t.Errorf("backward scan stopped at entry %d", i)
+	}
+
+	seeks := []struct {
+		target string
+		want   string // "" for the end of the block
+	}{
+		{"", "a"},
+		{"a", "a"},
+		{"aa", "ab"},
+		{"abd", "b"},
+		{"bcd", "bcd"},
+		{"ca", "cat"},
+		{"cb", "d"},
+		{"e", ""},
+	}
+	for _, tt := range seeks {
+		iter.Seek([]byte(tt.target))
+		if tt.want == "" {
+			if iter.Valid() {
+				t.Errorf("Seek(%q) = %q, want end", tt.target, iter.Key())
+			}
+		} else if !iter.Valid() || string(iter.Key()) != tt.want {
+			t.Errorf("Seek(%q) landed on the wrong entry, want %q", tt.target, tt.want)
+		}
+	}
+	if err := iter.Error(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTableRoundTrip(t *testing.T) {
+	tests := []struct {
+		name             string
+		block_size       int
+		restart_interval int
+		paranoid_checks  bool
+		verify_checksums bool
+		filter           bool
+		compression      CompressionType
+	}{
+		{name: "plain", block_size: 4096, restart_interval: 16},
+		{name: "small blocks", block_size: 256, restart_interval: 1},
+		{name: "checksums", block_size: 1024, restart_interval: 4, paranoid_checks: true, verify_checksums: true},
+		{name: "filter", block_size: 1024, restart_interval: 16, paranoid_checks: true, filter: true},
+		{name: "snappy", block_size: 4096, restart_interval: 16, verify_checksums: true, compression: SnappyCompression},
+		{name: "zstd", block_size: 4096, restart_interval: 16, verify_checksums: true, compression: ZstdCompression},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := NewOptions()
+			options.BlockSize = tt.block_size
+			options.BlockRestartInterval = tt.restart_interval
+			options.ParanoidChecks = tt.paranoid_checks
+			options.Compression = tt.compression
+			if tt.filter {
+				options.FilterPolicy = utils.NewBloomFilterPolicy(10)
+			}
+			tbl := buildTable(t, t.TempDir()+"/000001.ldb", options)
+			defer tbl.Close()
+			read_options := &ReadOptions{VerifyChecksums: tt.verify_checksums}
+
+			// Forward and backward scans see every entry in order.
+			iter := tbl.NewIterator(read_options)
+			i := 0
+			for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+				if !bytes.Equal(iter.Key(), testKey(i)) || !bytes.Equal(iter.Value(), testValue(i)) {
+					t.Fatalf("entry %d = (%q, %q)", i, iter.Key(), iter.Value())
+				}
+				i += 1
+			}
+			if i != kNumTestKeys {
+				t.Errorf("forward scan saw %d entries, want %d", i, kNumTestKeys)
+			}
+			for iter.SeekToLast(); iter.Valid(); iter.Prev() {
+				i -= 1
+				if !bytes.Equal(iter.Key(), testKey(i)) {
+					t.Fatalf("backward entry %d = %q", i, iter.Key())
+				}
+			}
+			if i != 0 {
+				t.Errorf("backward scan stopped at entry %d", i)
+			}
+
+			// Seek positions at the first entry at or past the target.
+			iter.Seek(testMissingKey(100))
+			if !iter.Valid() || !bytes.Equal(iter.Key(), testKey(101)) {
+				t.Errorf("Seek(%q) landed on the wrong entry", testMissingKey(100))
+			}
+			iter.Seek(testMissingKey(kNumTestKeys - 1))
+			if iter.Valid() {
+				t.Errorf("Seek past the last key landed on %q", iter.Key())
+			}
+			if err := iter.Error(); err != nil {
+				t.Error(err)
+			}
+			iter.Close()
+
+			// InternalGet hands over the first entry at or past the key,
+			// unless the filter rules the key out of its block.
+			missing_reads := 0
+			for i := 0; i < kNumTestKeys; i += 1 {
+				var found []byte
+				err := tbl.InternalGet(read_options, testKey(i), func(k, v []byte) {
+					if bytes.Equal(k, testKey(i)) {
+						found = append([]byte{}, v...)
+					}
+				})
+				if err != nil || !bytes.Equal(found, testValue(i)) {
+					t.Fatalf("InternalGet(%q) = (%q, %v)", testKey(i), found, err)
+				}
+				missing := testMissingKey(i)
+				err = tbl.InternalGet(read_options, missing, func(k, v []byte) {
+					missing_reads += 1
+					if bytes.Equal(k, missing) {
+						t.Errorf("InternalGet(%q) found the missing key", missing)
+					}
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.filter && missing_reads > kNumTestKeys/50 {
+				t.Errorf("%d of %d lookups of missing keys read a block", missing_reads, kNumTestKeys)
+			} else if !tt.filter && missing_reads < kNumTestKeys/2 {
+				t.Errorf("only %d of %d lookups of missing keys read a block", missing_reads, kNumTestKeys)
+			}
+		})
+	}
+}
+
+func TestTableIteratorBounds(t *testing.T) {
+	options := NewOptions()
+	options.BlockSize = 1024
+	tbl := buildTable(t, t.TempDir()+"/000001.ldb", options)
+	defer tbl.Close()
+	lower, upper := 500, 600
+	read_options := &ReadOptions{LowerBound: testKey(lower), UpperBound: testKey(upper)}
+
+	// Count the data blocks read; blocks holding only keys out of the
+	// bounds must not be read.
+	blocks := 0
+	block_function := func(options *ReadOptions, index_value []byte) Iterator {
+		blocks += 1
+		return tbl.BlockReader(options, index_value)
+	}
+	iter := NewTwoLevelIterator(tbl.index_block_.NewIterator(options.Comparator), block_function, read_options, options.Comparator)
+	defer iter.Close()
+	// Each ~1KB block holds less than 10 entries.
+	max_blocks := (upper-lower)/5 + 2
+
+	in_bounds := 0
+	iter.SeekToFirst()
+	if !iter.Valid() || !bytes.Equal(iter.Key(), testKey(lower)) {
+		t.Fatalf("SeekToFirst did not land on the lower bound")
+	}
+	for ; iter.Valid(); iter.Next() {
+		if bytes.Compare(iter.Key(), testKey(upper)) < 0 {
+			in_bounds += 1
+		}
+	}
+	if in_bounds != upper-lower {
+		t.Errorf("forward scan saw %d entries within the bounds, want %d", in_bounds, upper-lower)
+	}
+	if blocks > max_blocks {
+		t.Errorf("forward scan read %d blocks, want at most %d", blocks, max_blocks)
+	}
+
+	blocks, in_bounds = 0, 0
+	iter.SeekToLast()
+	if !iter.Valid() || !bytes.Equal(iter.Key(), testKey(upper-1)) {
+		t.Fatalf("SeekToLast did not land before the upper bound")
+	}
+	for ; iter.Valid(); iter.Prev() {
+		if bytes.Compare(iter.Key(), testKey(lower)) >= 0 {
+			in_bounds += 1
+		}
+	}
+	if in_bounds != upper-lower {
+		t.Errorf("backward scan saw %d entries within the bounds, want %d", in_bounds, upper-lower)
+	}
+	if blocks > max_blocks {
+		t.Errorf("backward scan read %d blocks, want at most %d", blocks, max_blocks)
+	}
+	if err := iter.Error(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTableCompression(t *testing.T) {
+	rnd := rand.New(rand.NewSource(301))
+	random := func(n int) []byte {
+		b := make([]byte, n)
+		rnd.Read(b)
+		return b
+	}
+	tests := []struct {
+		name        string
+		compression CompressionType
+		value       func(i int) []byte
+		want        CompressionType // type of every data block
+		// saves_little blocks shrink under snappy, by less than 12.5%.
+		saves_little bool
+	}{
+		{"snappy", SnappyCompression, testValue, SnappyCompression, false},
+		{"zstd", ZstdCompression, testValue, ZstdCompression, false},
+		{"snappy incompressible", SnappyCompression, func(int) []byte { return random(100) }, NoCompression, false},
+		{"zstd incompressible", ZstdCompression, func(int) []byte { return random(100) }, NoCompression, false},
+		{"snappy saves little", SnappyCompression, func(int) []byte {
+			return append(random(100), bytes.Repeat([]byte{'a'}, 12)...)
+		}, NoCompression, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := NewOptions()
+			options.Compression = tt.compression
+			fname := t.TempDir() + "/000001.ldb"
+			file, err := env.Default().NewWritableFile(fname)
+			if err != nil {
+				t.Fatal(err)
+			}
+			values := make([][]byte, kNumTestKeys)
+			builder := NewTableBuilder(options, file)
+			for i := range values {
+				values[i] = tt.value(i)
+				builder.Add(testKey(i), values[i])
+			}
+			if err := builder.Finish(); err != nil {
+				t.Fatal(err)
+			}
+			if err := file.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			contents, err := os.ReadFile(fname)
+			if err != nil {
+				t.Fatal(err)
+			}
+			footer := &Footer{}
+			if err := footer.DecodeFrom(contents[len(contents)-kEncodedLength:]); err != nil {
+				t.Fatal(err)
+			}
+			read, err := env.Default().NewRandomAccessFile(fname)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The index block is compressed too.
+			index, err := ReadBlock(read, &ReadOptions{VerifyChecksums: true}, &footer.index_handle_)
+			if err != nil {
+				t.Fatal(err)
+			}
+			index_entries, _ := decodeBlock(t, index)
+			var first *BlockHandle
+			for i, e := range index_entries {
+				handle := &BlockHandle{}
+				if _, err := handle.DecodeFrom([]byte(e.value)); err != nil {
+					t.Fatal(err)
+				}
+				if first == nil {
+					first = handle
+				}
+				block := contents[handle.offset_ : handle.offset_+handle.size_]
+				if got := CompressionType(contents[handle.offset_+handle.size_]); got != tt.want {
+					t.Fatalf("block %d has compression type %d, want %d", i, got, tt.want)
+				}
+				if tt.saves_little {
+					if n := len(snappy.Encode(nil, block)); n >= len(block) || n < len(block)-len(block)/8 {
+						t.Fatalf("block %d: snappy turns %d bytes into %d", i, len(block), n)
+					}
+				}
+			}
+
+			file_size := uint64(len(contents))
+			tbl, err := Open(options, read, file_size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			iter := tbl.NewIterator(&ReadOptions{VerifyChecksums: true})
+			i := 0
+			for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+				if !bytes.Equal(iter.Key(), testKey(i)) || !bytes.Equal(iter.Value(), values[i]) {
+					t.Fatalf("entry %d = (%q, %q)", i, iter.Key(), iter.Value())
+				}
+				i += 1
+			}
+			if err := iter.Error(); err != nil || i != kNumTestKeys {
+				t.Errorf("scan saw %d entries, error %v", i, err)
+			}
+			iter.Close()
+			tbl.Close()
+
+			// Compressed contents that do not decode are a corruption,
+			// even when the checksums are not verified.
+			if tt.want == NoCompression {
+				return
+			}
+			copy(contents[first.offset_:], bytes.Repeat([]byte{0xff}, 8))
+			if err := os.WriteFile(fname, contents, 0644); err != nil {
+				t.Fatal(err)
+			}
+			read, err = env.Default().NewRandomAccessFile(fname)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tbl, err = Open(options, read, file_size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tbl.Close()
+			iter = tbl.NewIterator(&ReadOptions{})
+			defer iter.Close()
+			iter.SeekToFirst()
+			if err := iter.Error(); !utils.IsCorruption(err) {
+				t.Errorf("reading a corrupted compressed block: %v, want corruption", err)
+			}
+		})
+	}
+}
+
+func TestTableBlockCache(t *testing.T) {
+	options := NewOptions()
+	options.BlockSize = 1024
+	options.BlockCache = cache.NewLRUCache(1 << 20)
+	tbl := buildTable(t, t.TempDir()+"/000001.ldb", options)
+	defer tbl.Close()
+	scan := func(read_options *ReadOptions) {
+		t.Helper()
+		iter := tbl.NewIterator(read_options)
+		defer iter.Close()
+		n := 0
+		for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+			n += 1
+		}
+		if err := iter.Error(); err != nil || n != kNumTestKeys {
+			t.Fatalf("scan saw %d entries, error %v", n, err)
+		}
+	}
+
+	// Bulk scans may keep their blocks out of the cache.
+	scan(&ReadOptions{FillCache: false})
+	if got := options.BlockCache.TotalCharge(); got != 0 {
+		t.Errorf("TotalCharge() = %d after a scan that does not fill the cache", got)
+	}
+	scan(&ReadOptions{FillCache: true})
+	filled := options.BlockCache.TotalCharge()
+	if filled == 0 {
+		t.Fatal("scan did not fill the cache")
+	}
+	// A second scan is served from the cache.
+	scan(&ReadOptions{FillCache: true})
+	if got := options.BlockCache.TotalCharge(); got != filled {
+		t.Errorf("TotalCharge() = %d after a cached scan, want %d", got, filled)
+	}
+
+	// A second table of the same cache does not see the first one's blocks.
+	other := buildTable(t, t.TempDir()+"/000002.ldb", options)
+	defer other.Close()
+	iter := other.NewIterator(&ReadOptions{FillCache: true})
+	iter.SeekToFirst()
+	iter.Close()
+	if got := options.BlockCache.TotalCharge(); got <= filled {
+		t.Errorf("TotalCharge() = %d after reading another table, want > %d", got, filled)
+	}
+}
+
+func TestTableApproximateOffsetOf(t *testing.T) {
+	options := NewOptions()
+	options.BlockSize = 1024
+	tbl := buildTable(t, t.TempDir()+"/000001.ldb", options)
+	defer tbl.Close()
+
+	tests := []struct {
+		key  []byte
+		low  uint64
+		high uint64
+	}{
+		{key: []byte("a"), low: 0, high: 0},
+		{key: testKey(0), low: 0, high: 0},
+		{key: testKey(kNumTestKeys / 2), low: 70000, high: 80000},
+		{key: []byte("zzz"), low: 145000, high: 155000},
+	}
+	for _, tt := range tests {
+		offset := tbl.ApproximateOffsetOf(tt.key)
+		if offset < tt.low || offset > tt.high {
+			t.Errorf("ApproximateOffsetOf(%q) = %d, want in [%d, %d]", tt.key, offset, tt.low, tt.high)
+		}
+	}
+}
+
+func TestTableCorruption(t *testing.T) {
+	options := NewOptions()
+	fname := t.TempDir() + "/000001.ldb"
+	writeTable(t, fname, options)
+	contents, err := os.ReadFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		contents []byte
+	}{
+		{name: "truncated footer", contents: contents[:len(contents)-10]},
+		{name: "bad magic", contents: append(append([]byte{}, contents[:len(contents)-1]...), 'x')},
+		{name: "too short", contents: []byte("short")},
+	}
+	for _, tt := range tests {
+		if err := os.WriteFile(fname, tt.contents, 0644); err != nil {
+			t.Fatal(err)
+		}
+		file, err := env.Default().NewRandomAccessFile(fname)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Open(options, file, uint64(len(tt.contents))); err == nil {
+			t.Errorf("%s: Open succeeded", tt.name)
+		}
+		file.Close()
+	}
+
+	// A flipped byte in the first data block is caught by
+	// VerifyChecksums, the scan skips the block and keeps the error.
+	corrupt := append([]byte{}, contents...)
+	corrupt[10] ^= 0xff
+	if err := os.WriteFile(fname, corrupt, 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := env.Default().NewRandomAccessFile(fname)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tbl, err := Open(options, file, uint64(len(corrupt)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tbl.Close()
+	iter := tbl.NewIterator(&ReadOptions{VerifyChecksums: true})
+	iter.SeekToFirst()
+	if iter.Valid() && bytes.Equal(iter.Key(), testKey(0)) {
+		t.Error("scan read the corrupted block")
+	}
+	if !utils.IsCorruption(iter.Error()) {
+		t.Errorf("scan of a corrupted block: Error() = %v, want corruption", iter.Error())
+	}
+	iter.Close()
+}
+
+// blockIterator returns an iterator over a block holding keys, each
+// with the value "v-"+key.
+func blockIterator(keys ...string) Iterator {
+	b := NewBlockBuilder(NewOptions())
+	for _, key := range keys {
+		b.Add([]byte(key), []byte("v-"+key))
+	}
+	return NewBlock(append([]byte{}, b.Finish()...)).NewIterator(&utils.BytewiseComparator{})
+}
+
+func TestMergingIterator(t *testing.T) {
+	iter := NewMergingIterator(&utils.BytewiseComparator{}, []Iterator{
+		blockIterator("a", "d", "g"),
+		blockIterator(),
+		blockIterator("b", "e"),
+		blockIterator("c", "f", "h"),
+	})
+	defer iter.Close()
+	all := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+
+	var got []string
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		if string(iter.Value()) != "v-"+string(iter.Key()) {
+			t.Errorf("value of %q = %q", iter.Key(), iter.Value())
+		}
+		got = append(got, string(iter.Key()))
+	}
+	if strings.Join(got, "") != strings.Join(all, "") {
+		t.Errorf("forward scan = %q, want %q", got, all)
+	}
+	got = got[:0]
+	for iter.SeekToLast(); iter.Valid(); iter.Prev() {
+		got = append([]string{string(iter.Key())}, got...)
+	}
+	if strings.Join(got, "") != strings.Join(all, "") {
+		t.Errorf("backward scan = %q, want %q", got, all)
+	}
+
+	// Direction switches reposition every child.
+	steps := []struct {
+		op   string
+		want string // "" if the iterator is not valid
+	}{
+		{"seek:d", "d"},
+		{"prev", "c"},
+		{"next", "d"},
+		{"next", "e"},
+		{"prev", "d"},
+		{"prev", "c"},
+		{"prev", "b"},
+		{"next", "c"},
+		{"seek:ee", "f"},
+		{"prev", "e"},
+		{"seek:z", ""},
+		{"last", "h"},
+		{"prev", "g"},
+		{"next", "h"},
+		{"next", ""},
+		{"first", "a"},
+		{"prev", ""},
+	}
+	for i, step := range steps {
+		switch {
+		case strings.HasPrefix(step.op, "seek:"):
+			iter.Seek([]byte(strings.TrimPrefix(step.op, "seek:")))
+		case step.op == "first":
+			iter.SeekToFirst()
+		case step.op == "last":
+			iter.SeekToLast()
+		case step.op == "next":
+			iter.Next()
+		case step.op == "prev":
+			iter.Prev()
+		}
+		got := ""
+		if iter.Valid() {
+			got = string(iter.Key())
+		}
+		if got != step.want {
+			t.Fatalf("step %d (%s): at %q, want %q", i, step.op, got, step.want)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package table
+
+import (
+	"bytes"
+
+	"github.com/lemonwx/goleveldb/leveldb/utils"
+)
+
+// BlockFunction converts an index iterator value (i.e., an encoded
+// BlockHandle) into an iterator over the contents of the corresponding
+// block.
+type BlockFunction func(options *ReadOptions, index_value []byte) Iterator
+
+// TwoLevelIterator walks the data iterators produced by block_function
+// for each entry of index_iter_.
+type TwoLevelIterator struct {
+	block_function_ BlockFunction
+	options_        *ReadOptions
+	comparator_     utils.Comparator // Orders the keys of index_iter_
+	status_         error
+	index_iter_     Iterator
+	data_iter_      Iterator // May be nil
+	// If data_iter_ is non-nil, then "data_block_handle_" holds the
+	// "index_value" passed to block_function_ to create the data_iter_.
+	data_block_handle_ []byte
+}
+
+// NewTwoLevelIterator returns a new two level iterator.  A two-level
+// iterator contains an index iterator whose values point to a sequence
+// of blocks where each block is itself a sequence of key,value pairs.
+// The returned two-level iterator yields the concatenation of all
+// key/value pairs in the sequence of blocks.  Takes ownership of
+// "index_iter" and will close it when no longer needed.
+//
+// comparator orders the keys of index_iter, it is used to stop at
+// options.LowerBound and options.UpperBound.
+func NewTwoLevelIterator(index_iter Iterator, block_function BlockFunction, options *ReadOptions, comparator utils.Comparator) Iterator {
+	return &TwoLevelIterator{
+		block_function_: block_function,
+		options_:        options,
+		comparator_:     comparator,
+		index_iter_:     index_iter,
+	}
+}
+
+func (it *TwoLevelIterator) Valid() bool {
+	return it.data_iter_ != nil && it.data_iter_.Valid()
+}
+
+func (it *TwoLevelIterator) Key() []byte {
+	return it.data_iter_.Key()
+}
+
+func (it *TwoLevelIterator) Value() []byte {
+	return it.data_iter_.Value()
+}
+
+func (it *TwoLevelIterator) Error() error {
+	// It'd be nice if status() returned a const Status& instead of a Status
+	if err := it.index_iter_.Error(); err != nil {
+		return err
+	}
+	if it.data_iter_ != nil {
+		if err := it.data_iter_.Error(); err != nil {
+			return err
+		}
+	}
+	return it.status_
+}
+
+func (it *TwoLevelIterator) Seek(target []byte) {
+	it.index_iter_.Seek(target)
+	it.InitDataBlock()
+	if it.data_iter_ != nil {
+		it.data_iter_.Seek(target)
+	}
+	it.SkipEmptyDataBlocksForward()
+}
+
+func (it *TwoLevelIterator) SeekToFirst() {
+	if it.options_.LowerBound != nil {
+		it.Seek(it.options_.LowerBound)
+		return
+	}
+	it.index_iter_.SeekToFirst()
+	it.InitDataBlock()
+	if it.data_iter_ != nil {
+		it.data_iter_.SeekToFirst()
+	}
+	it.SkipEmptyDataBlocksForward()
+}
+
+func (it *TwoLevelIterator) SeekToLast() {
+	if it.options_.UpperBound != nil {
+		it.SeekBeforeUpperBound()
+		return
+	}
+	it.index_iter_.SeekToLast()
+	it.InitDataBlock()
+	if it.data_iter_ != nil {
+		it.data_iter_.SeekToLast()
+	}
+	it.SkipEmptyDataBlocksBackward()
+}
+
+// SeekBeforeUpperBound positions at the last entry before
+// options_.UpperBound, so that the blocks past the bound are not read.
+func (it *TwoLevelIterator) SeekBeforeUpperBound() {
+	upper_bound := it.options_.UpperBound
+	it.index_iter_.Seek(upper_bound)
+	if !it.index_iter_.Valid() {
+		// Every block ends before the bound
+		it.index_iter_.SeekToLast()
+	}
+	it.InitDataBlock()
+	if it.data_iter_ != nil {
+		it.data_iter_.Seek(upper_bound)
+		if it.data_iter_.Valid() {
+			it.data_iter_.Prev()
+		} else {
+			it.data_iter_.SeekToLast()
+		}
+	}
+	it.SkipEmptyDataBlocksBackward()
+}
+
+func (it *TwoLevelIterator) Next() {
+	it.data_iter_.Next()
+	it.SkipEmptyDataBlocksForward()
+}
+
+func (it *TwoLevelIterator) Prev() {
+	it.data_iter_.Prev()
+	it.SkipEmptyDataBlocksBackward()
+}
+
+func (it *TwoLevelIterator) Close() error {
+	it.SetDataIterator(nil)
+	return it.index_iter_.Close()
+}
+
+func (it *TwoLevelIterator) SaveError(err error) {
+	if it.status_ == nil && err != nil {
+		it.status_ = err
+	}
+}
+
+func (it *TwoLevelIterator) SkipEmptyDataBlocksForward() {
+	for it.data_iter_ == nil || !it.data_iter_.Valid() {
+		// Move to next block
+		if !it.index_iter_.Valid() || it.PastUpperBound() {
+			it.SetDataIterator(nil)
+			return
+		}
+		it.index_iter_.Next()
+		it.InitDataBlock()
+		if it.data_iter_ != nil {
+			it.data_iter_.SeekToFirst()
+		}
+	}
+}
+
+func (it *TwoLevelIterator) SkipEmptyDataBlocksBackward() {
+	for it.data_iter_ == nil || !it.data_iter_.Valid() {
+		// Move to previous block
+		if !it.index_iter_.Valid() {
+			it.SetDataIterator(nil)
+			return
+		}
+		it.index_iter_.Prev()
+		if it.BeforeLowerBound() {
+			it.SetDataIterator(nil)
+			return
+		}
+		it.InitDataBlock()
+		if it.data_iter_ != nil {
+			it.data_iter_.SeekToLast()
+		}
+	}
+}
+
+// PastUpperBound returns true if the blocks after the current index
+// entry only hold keys at or after options_.UpperBound.  The index key
+// of a block is >= all its keys and < all keys of the next block.
+func (it *TwoLevelIterator) PastUpperBound() bool {
+	return it.options_.UpperBound != nil &&
+		it.comparator_.Compare(string(it.index_iter_.Key()), string(it.options_.UpperBound)) >= 0
+}
+
+// BeforeLowerBound returns true if the block of the current index
+// entry only holds keys before options_.LowerBound.
+func (it *TwoLevelIterator) BeforeLowerBound() bool {
+	return it.options_.LowerBound != nil && it.index_iter_.Valid() &&
+		it.comparator_.Compare(string(it.index_iter_.Key()), string(it.options_.LowerBound)) < 0
+}
+
+func (it *TwoLevelIterator) SetDataIterator(data_iter Iterator) {
+	if it.data_iter_ != nil {
+		it.SaveError(it.data_iter_.Error())
+		it.data_iter_.Close()
+	}
+	it.data_iter_ = data_iter
+}
+
+func (it *TwoLevelIterator) InitDataBlock() {
+	if !it.index_iter_.Valid() {
+		it.SetDataIterator(nil)
+	} else {
+		handle := it.index_iter_.Value()
+		if it.data_iter_ != nil && bytes.Equal(handle, it.data_block_handle_) {
+			// data_iter_ is already constructed with this iterator, so
+			// no need to change anything
+		} else {
+			iter := it.block_function_(it.options_, handle)
+			it.data_block_handle_ = append(it.data_block_handle_[:0], handle...)
+			it.SetDataIterator(iter)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package leveldb
+
+import (
+	"github.com/lemonwx/goleveldb/leveldb/cache"
+	"github.com/lemonwx/goleveldb/leveldb/env"
+	"github.com/lemonwx/goleveldb/leveldb/table"
+	"github.com/lemonwx/goleveldb/leveldb/utils"
+)
+
+// TableCache keeps the most recently used tables open, it is keyed by
+// file number.  A TableCache may be safely accessed from multiple
+// goroutines without external synchronization.
+type TableCache struct {
+	env_     env.Env
+	dbname_  string
+	options_ *Options
+	icmp_    *utils.InternalKeyComparator
+	cache_   cache.Cache
+}
+
+// NewTableCache returns a cache that keeps at most entries tables of
+// dbname open.
+func NewTableCache(dbname string, options *Options, icmp *utils.InternalKeyComparator, entries int) *TableCache {
+	return &TableCache{
+		env_:     options.Env,
+		dbname_:  dbname,
+		options_: options,
+		icmp_:    icmp,
+		cache_:   cache.NewLRUCache(entries),
+	}
+}
+
+func deleteEntry(key []byte, value interface{}) {
+	value.(*table.Table).Close()
+}
+
+func tableCacheKey(file_number uint64) []byte {
+	buf := make([]byte, 8)
+	utils.EncodeFixed64(buf, file_number)
+	return buf
+}
+
+// FindTable returns a handle on the open table file_number, opening
+// the table if it is not cached.  The caller must release the handle.
+func (tc *TableCache) FindTable(file_number uint64, file_size uint64) (*cache.Handle, error) {
+	key := tableCacheKey(file_number)
+	handle := tc.cache_.Lookup(key)
+	if handle != nil {
+		return handle, nil
+	}
+
+	fname := TableFileName(tc.dbname_, file_number)
+	file, err := tc.env_.NewRandomAccessFile(fname)
+	if err != nil {
+		old_fname := SSTTableFileName(tc.dbname_, file_number)
+		var old_err error
+		if file, old_err = tc.env_.NewRandomAccessFile(old_fname); old_err == nil {
+			err = nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	t, err := table.Open(tc.options_.tableOptions(tc.icmp_), file, file_size)
+	if err != nil {
+		file.Close()
+		// We do not cache error results so that if the error is transient,
+		// or somebody repairs the file, we recover automatically.
+		return nil, err
+	}
+	return tc.cache_.Insert(key, t, 1, deleteEntry), nil
+}
+
+// NewIterator returns an iterator for the specified file number (the
+// corresponding file length must be exactly "file_size" bytes).
+// The table stays open until the iterator is closed.
+func (tc *TableCache) NewIterator(options *table.ReadOptions, file_number uint64, file_size uint64) table.Iterator {
+	handle, err := tc.FindTable(file_number, file_size)
+	if err != nil {
+		return table.NewErrorIterator(err)
+	}
+	t := tc.cache_.Value(handle).(*table.Table)
+	return table.NewCleanupIterator(t.NewIterator(options), func() { tc.cache_.Release(handle) })
+}
+
+// Get calls handle_result with the entry found after a call to
+// Seek(k) on the specified file, if there is one.
+func (tc *TableCache) Get(options *table.ReadOptions, file_number uint64, file_size uint64, k []byte, handle_result func(k, v []byte)) error {
+	handle, err := tc.FindTable(file_number, file_size)
+	if err != nil {
+		return err
+	}
+	t := tc.cache_.Value(handle).(*table.Table)
+	err = t.InternalGet(options, k, handle_result)
+	tc.cache_.Release(handle)
+	return err
+}
+
+// Evict any entry for the specified file number.
+func (tc *TableCache) Evict(file_number uint64) {
+	tc.cache_.Erase(tableCacheKey(file_number))
+}
+
+// Close closes the tables that are not in use by an iterator.
+func (tc *TableCache) Close() {
+	tc.cache_.Prune()
+}
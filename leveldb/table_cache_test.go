@@ -0,0 +1,174 @@
+package leveldb
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/lemonwx/goleveldb/leveldb/table"
+)
+
+// openTableFiles returns the number of files of dbname the process
+// holds open.
+func openTableFiles(t *testing.T, dbname string) int {
+	t.Helper()
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("cannot list the open files:", err)
+	}
+	n := 0
+	for _, fd := range fds {
+		target, err := os.Readlink("/proc/self/fd/" + fd.Name())
+		if err != nil || !strings.HasPrefix(target, dbname+"/") {
+			continue
+		}
+		if strings.HasSuffix(target, ".ldb") || strings.HasSuffix(target, ".sst") {
+			n += 1
+		}
+	}
+	return n
+}
+
+func TestTableCache(t *testing.T) {
+	dbname := t.TempDir() + "/db"
+	db, err := Open(dbname, &Options{CreateIfMissing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.lock.Lock()
+	db.background_compaction_scheduled_ = true
+	var files []*FileMetaData
+	seq := SequenceNumber(1)
+	for i := 0; i < 40; i += 1 {
+		key := fmt.Sprintf("key%d", i)
+		seq = addTestTable(t, db, 1, seq, []dbTestOp{dbTestPut(key, "v"+key)})
+		files = append(files, db.versions.current_.files_[1][i])
+	}
+	db.lock.Unlock()
+	// The DB's own table cache holds the tables open after building them.
+	db.table_cache_.cache_.Prune()
+	base := openTableFiles(t, dbname)
+
+	// The cache is split in 16 shards of (entries+15)/16 tables each.
+	const entries = 16
+	tc := NewTableCache(dbname, db.opt, db.internal_comparator_, entries)
+	get := func(i int) {
+		t.Helper()
+		f := files[i]
+		key := NewLookupKey([]byte(fmt.Sprintf("key%d", i)), kMaxSequenceNumber)
+		var got []byte
+		err := tc.Get(&table.ReadOptions{}, f.number, f.file_size, []byte(key.internal_key()), func(k, v []byte) {
+			got = append([]byte{}, v...)
+		})
+		if err != nil || string(got) != fmt.Sprintf("vkey%d", i) {
+			t.Fatalf("Get(key%d) = (%q, %v)", i, got, err)
+		}
+	}
+
+	// At most "entries" tables stay open.
+	for i := range files {
+		get(i)
+		if n := openTableFiles(t, dbname) - base; n > entries {
+			t.Fatalf("%d tables open after reading table %d, want at most %d", n, i, entries)
+		}
+	}
+
+	// A table in use by an iterator stays open past the capacity.
+	iter := tc.NewIterator(&table.ReadOptions{}, files[0].number, files[0].file_size)
+	for i := 1; i < len(files); i += 1 {
+		get(i)
+	}
+	if n := openTableFiles(t, dbname) - base; n > entries+1 {
+		t.Errorf("%d tables open with one pinned, want at most %d", n, entries+1)
+	}
+	iter.SeekToFirst()
+	if !iter.Valid() || string(iter.Value()) != "vkey0" {
+		t.Errorf("iterator over a pinned table lost its entry")
+	}
+	iter.Close()
+
+	// Evict closes the table at once.
+	for _, f := range files {
+		tc.Evict(f.number)
+	}
+	if n := openTableFiles(t, dbname) - base; n != 0 {
+		t.Errorf("%d tables open after evicting them all", n)
+	}
+
+	// Tables named with the older ".sst" suffix are found too.
+	if err := os.Rename(TableFileName(dbname, files[2].number), SSTTableFileName(dbname, files[2].number)); err != nil {
+		t.Fatal(err)
+	}
+	get(2)
+
+	// A missing table is an error, which is not cached.
+	missing := TableFileName(dbname, files[3].number)
+	if err := os.Rename(missing, missing+".bak"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.Get(&table.ReadOptions{}, files[3].number, files[3].file_size, []byte("key3"), func(k, v []byte) {}); err == nil {
+		t.Error("Get from a missing table succeeded")
+	}
+	if err := os.Rename(missing+".bak", missing); err != nil {
+		t.Fatal(err)
+	}
+	get(3)
+}
+
+func TestTableCacheSize(t *testing.T) {
+	tests := []struct {
+		max_open_files int
+		want           int
+	}{
+		{0, 1000 - kNumNonTableCacheFiles},
+		{10, 64},
+		{80, 80 - kNumNonTableCacheFiles},
+		{100000, 50000 - kNumNonTableCacheFiles},
+	}
+	for _, tt := range tests {
+		db, err := Open(t.TempDir()+"/db", &Options{CreateIfMissing: true, MaxOpenFiles: tt.max_open_files})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := TableCacheSize(db.opt); got != tt.want {
+			t.Errorf("MaxOpenFiles %d: TableCacheSize() = %d, want %d", tt.max_open_files, got, tt.want)
+		}
+	}
+}
+
+func TestDBEvictsObsoleteTables(t *testing.T) {
+	dbname := t.TempDir() + "/db"
+	db, err := Open(dbname, &Options{CreateIfMissing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.lock.Lock()
+	db.background_compaction_scheduled_ = true
+	seq := SequenceNumber(1)
+	for i := 0; i < 4; i += 1 {
+		seq = addTestTable(t, db, 0, seq, []dbTestOp{dbTestPut("a", fmt.Sprintf("v%d", i))})
+	}
+	db.versions.Finalize(db.versions.current_)
+	db.lock.Unlock()
+	// Open every input table in the table cache.
+	checkDBContents(t, db, map[string]string{"a": "v3"})
+	db.lock.Lock()
+	for _, f := range db.versions.current_.files_[0] {
+		db.table_cache_.Get(&table.ReadOptions{}, f.number, f.file_size, []byte("a"), func(k, v []byte) {})
+	}
+	if n := openTableFiles(t, dbname); n != 4 {
+		t.Errorf("%d tables open before the compaction, want 4", n)
+	}
+
+	db.BackgroundCompaction()
+	db.lock.Unlock()
+	if db.bg_error != nil {
+		t.Fatal(db.bg_error)
+	}
+	// Only the output of the compaction is still open.
+	if n := openTableFiles(t, dbname); n != 1 {
+		t.Errorf("%d tables open after the compaction, want 1", n)
+	}
+	checkDBContents(t, db, map[string]string{"a": "v3"})
+}
@@ -92,3 +92,38 @@ func GetVarInt64(input []byte) (uint64, int, error) {
 	}
 	return 0, 0, errors.New("unexpected proto of varInt64")
 }
+
+func EncodeFixed32(buf []byte, v uint32) {
+	buf[0] = byte(v)
+	buf[1] = byte(v >> 8)
+	buf[2] = byte(v >> 16)
+	buf[3] = byte(v >> 24)
+}
+
+func EncodeFixed64(buf []byte, v uint64) {
+	for idx := 0; idx < 8; idx += 1 {
+		buf[idx] = byte(v >> uint(8*idx))
+	}
+}
+
+func PutFixed32(buf *[]byte, v uint32) {
+	tmp := make([]byte, 4)
+	EncodeFixed32(tmp, v)
+	*buf = append(*buf, tmp...)
+}
+
+func PutFixed64(buf *[]byte, v uint64) {
+	tmp := make([]byte, 8)
+	EncodeFixed64(tmp, v)
+	*buf = append(*buf, tmp...)
+}
+
+func DecodeFixed32(buf []byte) uint32 {
+	return uint32(buf[0]) | uint32(buf[1])<<8 | uint32(buf[2])<<16 | uint32(buf[3])<<24
+}
+
+func DecodeFixed64(buf []byte) uint64 {
+	lo := uint64(DecodeFixed32(buf))
+	hi := uint64(DecodeFixed32(buf[4:]))
+	return lo | (hi << 32)
+}
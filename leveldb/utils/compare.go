@@ -3,30 +3,158 @@ package utils
 import "strings"
 
 type Comparator interface {
+	// Three-way comparison.  Returns value:
+	//   < 0 iff "s1" < "s2",
+	//   == 0 iff "s1" == "s2",
+	//   > 0 iff "s1" > "s2"
 	Compare(s1, s2 string) int
+
+	// The name of the comparator.  Used to check for comparator
+	// mismatches (i.e., a DB created with one comparator is
+	// accessed using a different comparator.
 	Name() string
-	FindShortestSeparator(start string, limit string)
-	FindShortSuccessor(key string)
+
+	// Advanced functions: these are used to reduce the space requirements
+	// for internal data structures like index blocks.
+
+	// If start < limit, returns a short string in [start,limit).
+	// Simple comparator implementations may return start unchanged,
+	// i.e., an implementation of this method that does nothing is correct.
+	FindShortestSeparator(start string, limit string) string
+
+	// Returns a short string >= key.
+	// Simple comparator implementations may return key unchanged,
+	// i.e., an implementation of this method that does nothing is correct.
+	FindShortSuccessor(key string) string
 }
 
-type InternalKeyComparator struct {
+// BytewiseComparator orders keys lexicographically, it is the default
+// user comparator.
+type BytewiseComparator struct {
 }
 
-func (ikc *InternalKeyComparator) Compare(s1, s2 string) int {
+func (bc *BytewiseComparator) Compare(s1, s2 string) int {
 	return strings.Compare(s1, s2)
 }
 
-func (ikc *InternalKeyComparator) Name() string {
+func (bc *BytewiseComparator) Name() string {
 	return "leveldb.BytewiseComparator"
 }
 
-func (ikc *InternalKeyComparator) FindShortestSeparator(start string, limit string) {
+func (bc *BytewiseComparator) FindShortestSeparator(start string, limit string) string {
+	// Find length of common prefix
+	min_length := len(start)
+	if len(limit) < min_length {
+		min_length = len(limit)
+	}
+	diff_index := 0
+	for diff_index < min_length && start[diff_index] == limit[diff_index] {
+		diff_index += 1
+	}
 
+	if diff_index >= min_length {
+		// Do not shorten if one string is a prefix of the other
+		return start
+	}
+	diff_byte := start[diff_index]
+	if diff_byte < 0xff && diff_byte+1 < limit[diff_index] {
+		return start[:diff_index] + string([]byte{diff_byte + 1})
+	}
+	return start
 }
 
-func (ikc *InternalKeyComparator) FindShortSuccessor(key string) {
+func (bc *BytewiseComparator) FindShortSuccessor(key string) string {
+	// Find first character that can be incremented
+	for i := 0; i < len(key); i += 1 {
+		b := key[i]
+		if b != 0xff {
+			return key[:i] + string([]byte{b + 1})
+		}
+	}
+	// key is a run of 0xffs.  Leave it alone.
+	return key
+}
+
+// ExtractUserKey strips the 8 bytes (sequence << 8 | type) tag
+// from an internal key.
+func ExtractUserKey(internal_key string) string {
+	if len(internal_key) < 8 {
+		return internal_key
+	}
+	return internal_key[:len(internal_key)-8]
+}
 
+// InternalKeyComparator orders internal keys by
+//
+//	increasing user key (according to user-supplied comparator)
+//	decreasing sequence number
+//	decreasing type (though sequence# should be enough to disambiguate)
+type InternalKeyComparator struct {
+	user_comparator_ Comparator
 }
+
+func NewInternalKeyComparator(c Comparator) *InternalKeyComparator {
+	return &InternalKeyComparator{user_comparator_: c}
+}
+
+func (ikc *InternalKeyComparator) Compare(s1, s2 string) int {
+	r := ikc.user_comparator_.Compare(ExtractUserKey(s1), ExtractUserKey(s2))
+	if r == 0 && len(s1) >= 8 && len(s2) >= 8 {
+		anum := decodeFixed64String(s1[len(s1)-8:])
+		bnum := decodeFixed64String(s2[len(s2)-8:])
+		if anum > bnum {
+			r = -1
+		} else if anum < bnum {
+			r = +1
+		}
+	}
+	return r
+}
+
+func decodeFixed64String(s string) uint64 {
+	ret := uint64(0)
+	for idx := 7; idx >= 0; idx -= 1 {
+		ret = (ret << 8) | uint64(s[idx])
+	}
+	return ret
+}
+
+func (ikc *InternalKeyComparator) Name() string {
+	return "leveldb.InternalKeyComparator"
+}
+
+// kMaxSequenceAndTypeForSeek is PackSequenceAndType(kMaxSequenceNumber, kValueTypeForSeek),
+// the tag that sorts first among the entries of a user key.
+const kMaxSequenceAndTypeForSeek = uint64((1<<56)-1)<<8 | 0x1
+
+func (ikc *InternalKeyComparator) FindShortestSeparator(start string, limit string) string {
+	// Attempt to shorten the user portion of the key
+	user_start := ExtractUserKey(start)
+	user_limit := ExtractUserKey(limit)
+	tmp := ikc.user_comparator_.FindShortestSeparator(user_start, user_limit)
+	if len(tmp) < len(user_start) && ikc.user_comparator_.Compare(user_start, tmp) < 0 {
+		// User key has become shorter physically, but larger logically.
+		// Tack on the earliest possible number to the shortened user key.
+		buf := []byte(tmp)
+		PutFixed64(&buf, kMaxSequenceAndTypeForSeek)
+		return string(buf)
+	}
+	return start
+}
+
+func (ikc *InternalKeyComparator) FindShortSuccessor(key string) string {
+	user_key := ExtractUserKey(key)
+	tmp := ikc.user_comparator_.FindShortSuccessor(user_key)
+	if len(tmp) < len(user_key) && ikc.user_comparator_.Compare(user_key, tmp) < 0 {
+		// User key has become shorter physically, but larger logically.
+		// Tack on the earliest possible number to the shortened user key.
+		buf := []byte(tmp)
+		PutFixed64(&buf, kMaxSequenceAndTypeForSeek)
+		return string(buf)
+	}
+	return key
+}
+
 func (ikc *InternalKeyComparator) User_comparator() Comparator {
-	return ikc
+	return ikc.user_comparator_
 }
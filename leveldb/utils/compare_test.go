@@ -0,0 +1,75 @@
+package utils
+
+import "testing"
+
+func TestBytewiseComparatorShortening(t *testing.T) {
+	cmp := &BytewiseComparator{}
+	separators := []struct {
+		start, limit, want string
+	}{
+		{"abc", "abd", "abc"},
+		{"abc", "abf", "abd"},
+		{"abcdef", "abzz", "abd"},
+		{"abc", "abcdef", "abc"},
+		{"abcdef", "abc", "abcdef"},
+		{"a\xff\xff", "b", "a\xff\xff"},
+		{"", "b", ""},
+	}
+	for _, tt := range separators {
+		if got := cmp.FindShortestSeparator(tt.start, tt.limit); got != tt.want {
+			t.Errorf("FindShortestSeparator(%q, %q) = %q, want %q", tt.start, tt.limit, got, tt.want)
+		}
+	}
+
+	successors := []struct {
+		key, want string
+	}{
+		{"abc", "b"},
+		{"\xffabc", "\xffb"},
+		{"\xff\xff", "\xff\xff"},
+		{"", ""},
+	}
+	for _, tt := range successors {
+		if got := cmp.FindShortSuccessor(tt.key); got != tt.want {
+			t.Errorf("FindShortSuccessor(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestInternalKeyComparatorShortening(t *testing.T) {
+	icmp := NewInternalKeyComparator(&BytewiseComparator{})
+	ikey := func(user_key string, seq uint64) string {
+		buf := []byte(user_key)
+		PutFixed64(&buf, seq<<8|0x1)
+		return string(buf)
+	}
+
+	separators := []struct {
+		start, limit, want string
+	}{
+		// When user keys are same
+		{ikey("foo", 100), ikey("foo", 99), ikey("foo", 100)},
+		// When user keys are misordered
+		{ikey("foo", 100), ikey("bar", 99), ikey("foo", 100)},
+		// When user keys are different, but correctly ordered
+		{ikey("foo", 100), ikey("hello", 200), ikey("g", (1<<56)-1)},
+		// When start user key is prefix of limit user key
+		{ikey("foo", 100), ikey("foobar", 200), ikey("foo", 100)},
+	}
+	for _, tt := range separators {
+		got := icmp.FindShortestSeparator(tt.start, tt.limit)
+		if got != tt.want {
+			t.Errorf("FindShortestSeparator(%q, %q) = %q, want %q", tt.start, tt.limit, got, tt.want)
+		}
+		if icmp.Compare(tt.start, got) > 0 || (icmp.Compare(tt.start, tt.limit) < 0 && icmp.Compare(got, tt.limit) >= 0) {
+			t.Errorf("FindShortestSeparator(%q, %q) = %q is out of range", tt.start, tt.limit, got)
+		}
+	}
+
+	if got, want := icmp.FindShortSuccessor(ikey("foo", 100)), ikey("g", (1<<56)-1); got != want {
+		t.Errorf("FindShortSuccessor(foo) = %q, want %q", got, want)
+	}
+	if got, want := icmp.FindShortSuccessor(ikey("\xff\xff", 100)), ikey("\xff\xff", 100); got != want {
+		t.Errorf("FindShortSuccessor(\\xff\\xff) = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,130 @@
+package utils
+
+// A database can be configured with a custom FilterPolicy object.
+// This object is responsible for creating a small filter from a set
+// of keys.  These filters are stored in leveldb and are consulted
+// automatically by leveldb to decide whether or not to read some
+// information from disk. In many cases, a filter can cut down the
+// number of disk seeks form a handful to a single disk seek per
+// DB.Get() call.
+//
+// Most people will want to use the builtin bloom filter support (see
+// NewBloomFilterPolicy() below).
+type FilterPolicy interface {
+	// Return the name of this policy.  Note that if the filter encoding
+	// changes in an incompatible way, the name returned by this method
+	// must be changed.  Otherwise, old incompatible filters may be
+	// passed to methods of this type.
+	Name() string
+
+	// keys contains a list of keys (potentially with duplicates)
+	// that are ordered according to the user supplied comparator.
+	// Append a filter that summarizes keys to *dst.
+	//
+	// Warning: do not change the initial contents of *dst.  Instead,
+	// append the newly constructed filter to *dst.
+	CreateFilter(keys [][]byte, dst *[]byte)
+
+	// "filter" contains the data appended by a preceding call to
+	// CreateFilter() on this object.  This method must return true if
+	// the key was in the list of keys passed to CreateFilter().
+	// This method may return true or false if the key was not on the
+	// list, but it should aim to return false with a high probability.
+	KeyMayMatch(key []byte, filter []byte) bool
+}
+
+type bloomFilterPolicy struct {
+	bits_per_key_ int
+	k_            int
+}
+
+// NewBloomFilterPolicy returns a new filter policy that uses a bloom
+// filter with approximately the specified number of bits per key.  A
+// good value for bits_per_key is 10, which yields a filter with ~ 1%
+// false positive rate.
+//
+// Note: if you are using a custom comparator that ignores some parts
+// of the keys being compared, you must not use NewBloomFilterPolicy()
+// and must provide your own FilterPolicy that also ignores the
+// corresponding parts of the keys.  For example, if the comparator
+// ignores trailing spaces, it would be incorrect to use a
+// FilterPolicy (like NewBloomFilterPolicy) that does not ignore
+// trailing spaces in keys.
+func NewBloomFilterPolicy(bits_per_key int) FilterPolicy {
+	// We intentionally round down to reduce probing cost a little bit
+	k := int(float64(bits_per_key) * 0.69) // 0.69 =~ ln(2)
+	if k < 1 {
+		k = 1
+	}
+	if k > 30 {
+		k = 30
+	}
+	return &bloomFilterPolicy{bits_per_key_: bits_per_key, k_: k}
+}
+
+func bloomHash(key []byte) uint32 {
+	return Hash(key, 0xbc9f1d34)
+}
+
+func (p *bloomFilterPolicy) Name() string {
+	return "leveldb.BuiltinBloomFilter2"
+}
+
+func (p *bloomFilterPolicy) CreateFilter(keys [][]byte, dst *[]byte) {
+	// Compute bloom filter size (in both bits and bytes)
+	bits := len(keys) * p.bits_per_key_
+
+	// For small n, we can see a very high false positive rate.  Fix it
+	// by enforcing a minimum bloom filter length.
+	if bits < 64 {
+		bits = 64
+	}
+
+	bytes := (bits + 7) / 8
+	bits = bytes * 8
+
+	init_size := len(*dst)
+	*dst = append(*dst, make([]byte, bytes)...)
+	*dst = append(*dst, byte(p.k_)) // Remember # of probes in filter
+	array := (*dst)[init_size:]
+	for _, key := range keys {
+		// Use double-hashing to generate a sequence of hash values.
+		// See analysis in [Kirsch,Mitzenmacher 2006].
+		h := bloomHash(key)
+		delta := (h >> 17) | (h << 15) // Rotate right 17 bits
+		for j := 0; j < p.k_; j += 1 {
+			bitpos := h % uint32(bits)
+			array[bitpos/8] |= 1 << (bitpos % 8)
+			h += delta
+		}
+	}
+}
+
+func (p *bloomFilterPolicy) KeyMayMatch(key []byte, bloom_filter []byte) bool {
+	n := len(bloom_filter)
+	if n < 2 {
+		return false
+	}
+
+	bits := uint32(n-1) * 8
+
+	// Use the encoded k so that we can read filters generated by
+	// bloom filters created using different parameters.
+	k := int(bloom_filter[n-1])
+	if k > 30 {
+		// Reserved for potentially new encodings for short bloom filters.
+		// Consider it a match.
+		return true
+	}
+
+	h := bloomHash(key)
+	delta := (h >> 17) | (h << 15) // Rotate right 17 bits
+	for j := 0; j < k; j += 1 {
+		bitpos := h % bits
+		if bloom_filter[bitpos/8]&(1<<(bitpos%8)) == 0 {
+			return false
+		}
+		h += delta
+	}
+	return true
+}
@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	// The bytes past 0x7f catch hashing them as signed values.
+	tests := []struct {
+		data []byte
+		want uint32
+	}{
+		{nil, 0xbc9f1d34},
+		{[]byte{0x62}, 0xef1345c4},
+		{[]byte{0xc3, 0x97}, 0x5b663814},
+		{[]byte{0xe2, 0x99, 0xa5}, 0x323c078f},
+		{[]byte{0xe1, 0x80, 0xb9, 0x32}, 0xed21633a},
+	}
+	for _, tt := range tests {
+		if got := Hash(tt.data, 0xbc9f1d34); got != tt.want {
+			t.Errorf("Hash(%x) = %#x, want %#x", tt.data, got, tt.want)
+		}
+	}
+}
+
+func bloomTestKey(i int) []byte {
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, uint32(i))
+	return buf
+}
+
+func TestBloomFilter(t *testing.T) {
+	policy := NewBloomFilterPolicy(10)
+	var filter []byte
+	policy.CreateFilter(nil, &filter)
+	for _, key := range []string{"hello", "world"} {
+		if policy.KeyMayMatch([]byte(key), filter) {
+			t.Errorf("empty filter matches %q", key)
+		}
+	}
+
+	filter = []byte("prefix")
+	policy.CreateFilter([][]byte{[]byte("hello"), []byte("world")}, &filter)
+	if string(filter[:6]) != "prefix" {
+		t.Errorf("CreateFilter changed the initial contents of dst")
+	}
+	filter = filter[6:]
+	for _, key := range []string{"hello", "world"} {
+		if !policy.KeyMayMatch([]byte(key), filter) {
+			t.Errorf("filter does not match %q", key)
+		}
+	}
+	for _, key := range []string{"x", "foo"} {
+		if policy.KeyMayMatch([]byte(key), filter) {
+			t.Errorf("filter matches %q", key)
+		}
+	}
+
+	if policy.KeyMayMatch([]byte("hello"), nil) {
+		t.Error("a filter too short to hold the probe count matches")
+	}
+	// Probe counts above 30 are reserved and always match.
+	if !policy.KeyMayMatch([]byte("hello"), []byte{0, 0, 0, 0, 0, 0, 0, 0, 31}) {
+		t.Error("a filter with a reserved encoding does not match")
+	}
+}
+
+func TestBloomFilterVaryingLengths(t *testing.T) {
+	policy := NewBloomFilterPolicy(10)
+	mediocre_filters, good_filters := 0, 0
+	for length := 1; length <= 10000; length = nextBloomLength(length) {
+		keys := make([][]byte, length)
+		for i := range keys {
+			keys[i] = bloomTestKey(i)
+		}
+		var filter []byte
+		policy.CreateFilter(keys, &filter)
+		if len(filter) > length*10/8+40 {
+			t.Errorf("length %d: filter is %d bytes", length, len(filter))
+		}
+
+		// All added keys must match
+		for i := 0; i < length; i += 1 {
+			if !policy.KeyMayMatch(bloomTestKey(i), filter) {
+				t.Fatalf("length %d: key %d does not match", length, i)
+			}
+		}
+
+		// Check false positive rate
+		matches := 0
+		for i := 0; i < 10000; i += 1 {
+			if policy.KeyMayMatch(bloomTestKey(i+1000000000), filter) {
+				matches += 1
+			}
+		}
+		rate := float64(matches) / 10000
+		if rate > 0.02 {
+			t.Errorf("length %d: false positive rate %.2f%%", length, rate*100)
+		}
+		if rate > 0.0125 {
+			mediocre_filters += 1 // Allowed, but not too often
+		} else {
+			good_filters += 1
+		}
+	}
+	if mediocre_filters > good_filters/5 {
+		t.Errorf("%d mediocre filters for %d good ones", mediocre_filters, good_filters)
+	}
+}
+
+func nextBloomLength(length int) int {
+	switch {
+	case length < 10:
+		return length + 1
+	case length < 100:
+		return length + 10
+	case length < 1000:
+		return length + 100
+	}
+	return length + 1000
+}
@@ -0,0 +1,33 @@
+package utils
+
+// Hash is the hash function used by the bloom filter, it is similar to
+// murmur hash.
+func Hash(data []byte, seed uint32) uint32 {
+	// Similar to murmur hash
+	const m = uint32(0xc6a4a793)
+	const r = 24
+	h := seed ^ (uint32(len(data)) * m)
+
+	// Pick up four bytes at a time
+	for ; len(data) >= 4; data = data[4:] {
+		w := DecodeFixed32(data)
+		h += w
+		h *= m
+		h ^= h >> 16
+	}
+
+	// Pick up remaining bytes
+	switch len(data) {
+	case 3:
+		h += uint32(data[2]) << 16
+		fallthrough
+	case 2:
+		h += uint32(data[1]) << 8
+		fallthrough
+	case 1:
+		h += uint32(data[0])
+		h *= m
+		h ^= h >> r
+	}
+	return h
+}
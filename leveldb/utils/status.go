@@ -0,0 +1,41 @@
+package utils
+
+// CorruptionError reports data that failed validation while decoding,
+// e.g. a malformed write batch or a bad block checksum.
+type CorruptionError struct {
+	msg string
+}
+
+func NewCorruption(msg string) error {
+	return &CorruptionError{msg: msg}
+}
+
+func (e *CorruptionError) Error() string {
+	return "corruption: " + e.msg
+}
+
+// IsCorruption returns true iff err is a CorruptionError.
+func IsCorruption(err error) bool {
+	_, ok := err.(*CorruptionError)
+	return ok
+}
+
+// InvalidArgumentError reports an argument the caller should not have
+// passed, e.g. a level out of range.
+type InvalidArgumentError struct {
+	msg string
+}
+
+func NewInvalidArgument(msg string) error {
+	return &InvalidArgumentError{msg: msg}
+}
+
+func (e *InvalidArgumentError) Error() string {
+	return "invalid argument: " + e.msg
+}
+
+// IsInvalidArgument returns true iff err is an InvalidArgumentError.
+func IsInvalidArgument(err error) bool {
+	_, ok := err.(*InvalidArgumentError)
+	return ok
+}
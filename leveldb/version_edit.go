@@ -1,8 +1,8 @@
 package leveldb
 
 import (
-	"errors"
 	"fmt"
+	"sort"
 
 	"github.com/lemonwx/goleveldb/leveldb/utils"
 	"github.com/lemonwx/log"
@@ -37,6 +37,12 @@ type FileMetaData struct {
 
 }
 
+// deletedFile identifies a file removed from a level by a VersionEdit.
+type deletedFile struct {
+	level  int
+	number uint64
+}
+
 type fileMeta struct {
 	k int
 	f *FileMetaData
@@ -54,7 +60,7 @@ type VersionEdit struct {
 	has_next_file_number_ bool
 	has_last_sequence_    bool
 	compact_pointers_     []*compatPointer
-	deleted_files_        map[int]uint64
+	deleted_files_        map[deletedFile]struct{}
 
 	new_files_ []*fileMeta
 }
@@ -70,7 +76,8 @@ func (ve *VersionEdit) Clear() {
 	ve.has_prev_log_number_ = false
 	ve.has_next_file_number_ = false
 	ve.has_last_sequence_ = false
-	ve.deleted_files_ = map[int]uint64{}
+	ve.compact_pointers_ = ve.compact_pointers_[:0]
+	ve.deleted_files_ = map[deletedFile]struct{}{}
 	ve.new_files_ = ve.new_files_[:0]
 }
 
@@ -131,10 +138,21 @@ func (ve *VersionEdit) Encode() []byte {
 		utils.PutVarint32(&dst, p.level)
 		utils.PutLengthPrefixedSlice(&dst, p.key.Encode())
 	}
-	for k, v := range ve.deleted_files_ {
+	// Sort the deleted files so that an edit always has the same encoding
+	deleted_files := make([]deletedFile, 0, len(ve.deleted_files_))
+	for df := range ve.deleted_files_ {
+		deleted_files = append(deleted_files, df)
+	}
+	sort.Slice(deleted_files, func(i, j int) bool {
+		if deleted_files[i].level != deleted_files[j].level {
+			return deleted_files[i].level < deleted_files[j].level
+		}
+		return deleted_files[i].number < deleted_files[j].number
+	})
+	for _, df := range deleted_files {
 		utils.PutVarint32(&dst, kDeletedFile)
-		utils.PutVarint32(&dst, uint32(k)) // level
-		utils.PutVarint64(&dst, uint64(v)) // file number
+		utils.PutVarint32(&dst, uint32(df.level)) // level
+		utils.PutVarint64(&dst, df.number)        // file number
 	}
 	for _, f := range ve.new_files_ {
 		utils.PutVarint32(&dst, kNewFile)
@@ -167,59 +185,170 @@ func (ve *VersionEdit) SetLastSequence(seq SequenceNumber) {
 	ve.last_sequence_ = seq
 }
 
+// GetInternalKey decodes a length prefixed internal key from the
+// front of src, returning it with the number of bytes consumed.
+func GetInternalKey(src []byte) (*InternalKey, int, bool) {
+	str, l, err := utils.GetLengthPrefixedString(src)
+	if err != nil {
+		return nil, 0, false
+	}
+	dst := &InternalKey{}
+	dst.DecodeFrom(string(str))
+	return dst, l, true
+}
+
+// GetLevel decodes a level from the front of src, returning it with
+// the number of bytes consumed.
+func GetLevel(src []byte) (int, int, bool) {
+	v, l, err := utils.GetVarInt32(src)
+	if err != nil || v >= levelNum {
+		return 0, 0, false
+	}
+	return int(v), l, true
+}
+
+// DecodeFrom parses an edit produced by Encode, replacing the contents
+// of ve.
 func (ve *VersionEdit) DecodeFrom(src []byte) error {
-	for {
-		tag, l, err := utils.GetVarInt32(src)
+	ve.Clear()
+	input := src
+	msg := ""
+
+	for msg == "" && len(input) != 0 {
+		tag, l, err := utils.GetVarInt32(input)
 		if err != nil {
-			if len(src) == 0 {
-				return nil
-			}
-			return err
+			break
 		}
-		src = src[l:]
-		log.Debug(tag, src)
+		input = input[l:]
 		switch tag {
 		case kComparator:
-			ret, l, err := utils.GetLengthPrefixedString(src)
+			str, l, err := utils.GetLengthPrefixedString(input)
 			if err != nil {
-				log.Errorf("comparator name")
-				return err
-			} else {
-				src = src[l:]
-				ve.comparator_ = string(ret)
-				ve.has_comparator_ = true
+				msg = "comparator name"
+				break
 			}
+			input = input[l:]
+			ve.comparator_ = string(str)
+			ve.has_comparator_ = true
+
 		case kLogNumber:
-			t, l, err := utils.GetVarInt64(src)
+			v, l, err := utils.GetVarInt64(input)
 			if err != nil {
-				return err
+				msg = "log number"
+				break
 			}
-			src = src[l:]
-			log.Debug(t, src, l)
-			ve.log_number_ = t
+			input = input[l:]
+			ve.log_number_ = v
 			ve.has_log_number_ = true
+
+		case kPrevLogNumber:
+			v, l, err := utils.GetVarInt64(input)
+			if err != nil {
+				msg = "previous log number"
+				break
+			}
+			input = input[l:]
+			ve.prev_log_number_ = v
+			ve.has_prev_log_number_ = true
+
 		case kNextFileNumber:
-			t, l, err := utils.GetVarInt64(src)
+			v, l, err := utils.GetVarInt64(input)
 			if err != nil {
-				return err
+				msg = "next file number"
+				break
 			}
-			src = src[l:]
-			ve.next_file_number_ = t
+			input = input[l:]
+			ve.next_file_number_ = v
 			ve.has_next_file_number_ = true
+
 		case kLastSequence:
-			t, l, err := utils.GetVarInt64(src)
+			v, l, err := utils.GetVarInt64(input)
 			if err != nil {
-				return err
+				msg = "last sequence number"
+				break
 			}
-			src = src[l:]
-			ve.last_sequence_ = SequenceNumber(t)
+			input = input[l:]
+			ve.last_sequence_ = SequenceNumber(v)
 			ve.has_last_sequence_ = true
+
+		case kCompactPointer:
+			level, l, ok := GetLevel(input)
+			if !ok {
+				msg = "compaction pointer"
+				break
+			}
+			key, kl, ok := GetInternalKey(input[l:])
+			if !ok {
+				msg = "compaction pointer"
+				break
+			}
+			input = input[l+kl:]
+			ve.compact_pointers_ = append(ve.compact_pointers_, &compatPointer{level: uint32(level), key: key})
+
+		case kDeletedFile:
+			level, l, ok := GetLevel(input)
+			if !ok {
+				msg = "deleted file"
+				break
+			}
+			number, nl, err := utils.GetVarInt64(input[l:])
+			if err != nil {
+				msg = "deleted file"
+				break
+			}
+			input = input[l+nl:]
+			ve.DeleteFile(level, number)
+
+		case kNewFile:
+			level, l, ok := GetLevel(input)
+			if !ok {
+				msg = "new-file entry"
+				break
+			}
+			f := &FileMetaData{}
+			p := l
+			var n int
+			if f.number, n, err = utils.GetVarInt64(input[p:]); err != nil {
+				msg = "new-file entry"
+				break
+			}
+			p += n
+			if f.file_size, n, err = utils.GetVarInt64(input[p:]); err != nil {
+				msg = "new-file entry"
+				break
+			}
+			p += n
+			if f.smallest, n, ok = GetInternalKey(input[p:]); !ok {
+				msg = "new-file entry"
+				break
+			}
+			p += n
+			if f.largest, n, ok = GetInternalKey(input[p:]); !ok {
+				msg = "new-file entry"
+				break
+			}
+			p += n
+			input = input[p:]
+			ve.new_files_ = append(ve.new_files_, &fileMeta{k: level, f: f})
+
+		case 8:
+			// 8 was used for large value refs, which are no longer supported
+			msg = "large value refs (tag 8) are not supported"
+
 		default:
-			err := errors.New(fmt.Sprintf("unknown tag"))
-			log.Error(err)
-			return err
+			msg = fmt.Sprintf("unknown tag %d", tag)
 		}
 	}
+
+	if msg == "" && len(input) != 0 {
+		msg = "invalid tag"
+	}
+
+	if msg != "" {
+		err := utils.NewCorruption("VersionEdit: " + msg)
+		log.Error(err)
+		return err
+	}
 	return nil
 }
 
@@ -241,3 +370,8 @@ func (ve *VersionEdit) AddFile(level int, file uint64, file_sz uint64, smallest
 	}
 	ve.new_files_ = append(ve.new_files_, &fileMeta{k: level, f: f})
 }
+
+// DeleteFile deletes the specified "file" from the specified "level".
+func (ve *VersionEdit) DeleteFile(level int, file uint64) {
+	ve.deleted_files_[deletedFile{level: level, number: file}] = struct{}{}
+}
@@ -0,0 +1,134 @@
+package leveldb
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/lemonwx/goleveldb/leveldb/utils"
+)
+
+func TestVersionEditRoundTrip(t *testing.T) {
+	const kBig = uint64(1) << 50
+
+	edit := NewVersionEdit()
+	for i := 0; i < 4; i += 1 {
+		edit.AddFile(3, kBig+300+uint64(i), kBig+400+uint64(i),
+			NewInternalKey("foo", SequenceNumber(kBig+500+uint64(i)), kTypeValue),
+			NewInternalKey("zoo", SequenceNumber(kBig+600+uint64(i)), kTypeDeletion))
+		edit.DeleteFile(4, kBig+700+uint64(i))
+		edit.SetComparatorPointer(i, NewInternalKey("x", SequenceNumber(kBig+900+uint64(i)), kTypeValue))
+	}
+	edit.SetComparatorName("foo")
+	edit.SetLogNumber(kBig + 100)
+	edit.SetPrevLogNumber(kBig + 99)
+	edit.SetNextFile(kBig + 200)
+	edit.SetLastSequence(SequenceNumber(kBig + 1000))
+
+	// Every tag shows up in the encoding.
+	encoded := edit.Encode()
+	tags := map[uint32]bool{}
+	for _, tag := range []uint32{kComparator, kLogNumber, kNextFileNumber, kLastSequence,
+		kCompactPointer, kDeletedFile, kNewFile, kPrevLogNumber} {
+		tags[tag] = false
+	}
+	for _, b := range encoded {
+		if _, ok := tags[uint32(b)]; ok {
+			tags[uint32(b)] = true
+		}
+	}
+	for tag, seen := range tags {
+		if !seen {
+			t.Errorf("tag %d missing from the encoding", tag)
+		}
+	}
+
+	decoded := NewVersionEdit()
+	if err := decoded.DecodeFrom(encoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, edit) {
+		t.Errorf("decoded edit differs:\n got %+v\nwant %+v", decoded, edit)
+	}
+
+	// DecodeFrom replaces what the edit held before.
+	if err := decoded.DecodeFrom(NewVersionEdit().Encode()); err != nil {
+		t.Fatal(err)
+	}
+	if encoded := decoded.Encode(); len(encoded) != 0 {
+		t.Errorf("decoding an empty edit left %q", encoded)
+	}
+}
+
+func TestVersionEditEncodeDeterministic(t *testing.T) {
+	// Two edits deleting the same files in another order encode alike,
+	// with the deleted files ordered by (level, number).
+	files := [][2]uint64{{2, 5}, {1, 9}, {2, 3}, {1, 10}, {0, 7}, {1, 2}}
+	edit := NewVersionEdit()
+	reversed := NewVersionEdit()
+	for i := range files {
+		edit.DeleteFile(int(files[i][0]), files[i][1])
+		f := files[len(files)-1-i]
+		reversed.DeleteFile(int(f[0]), f[1])
+	}
+	encoded := edit.Encode()
+	for i := 0; i < 10; i += 1 {
+		if got := reversed.Encode(); !bytes.Equal(got, encoded) {
+			t.Fatalf("Encode() = %q, want %q", got, encoded)
+		}
+	}
+
+	var want []byte
+	for _, f := range [][2]uint64{{0, 7}, {1, 2}, {1, 9}, {1, 10}, {2, 3}, {2, 5}} {
+		utils.PutVarint32(&want, kDeletedFile)
+		utils.PutVarint32(&want, uint32(f[0]))
+		utils.PutVarint64(&want, f[1])
+	}
+	if !bytes.Equal(encoded, want) {
+		t.Errorf("Encode() = %q, want %q", encoded, want)
+	}
+}
+
+func TestVersionEditDecodeErrors(t *testing.T) {
+	valid := func(build func(edit *VersionEdit)) []byte {
+		edit := NewVersionEdit()
+		build(edit)
+		return edit.Encode()
+	}
+	level := func(tag uint32, level uint32) []byte {
+		var dst []byte
+		utils.PutVarint32(&dst, tag)
+		utils.PutVarint32(&dst, level)
+		utils.PutVarint64(&dst, 7)
+		return dst
+	}
+	new_file := valid(func(edit *VersionEdit) {
+		edit.AddFile(1, 7, 100, NewInternalKey("a", 1, kTypeValue), NewInternalKey("b", 2, kTypeValue))
+	})
+
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{name: "large value ref", input: []byte{8, 0}},
+		{name: "unknown tag", input: []byte{100}},
+		{name: "deleted file level out of range", input: level(kDeletedFile, levelNum)},
+		{name: "new file level out of range", input: append(level(kNewFile, levelNum), 0, 0, 0)},
+		{name: "truncated log number", input: []byte{kLogNumber}},
+		{name: "truncated comparator", input: []byte{kComparator, 5, 'a'}},
+		{name: "truncated new file", input: new_file[:len(new_file)-3]},
+		{name: "bad tag encoding", input: []byte{0x80}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := NewVersionEdit().DecodeFrom(tt.input); !utils.IsCorruption(err) {
+				t.Errorf("DecodeFrom = %v, want corruption", err)
+			}
+		})
+	}
+
+	// The last level is accepted.
+	if err := NewVersionEdit().DecodeFrom(level(kDeletedFile, levelNum-1)); err != nil {
+		t.Errorf("DecodeFrom(level %d) = %v", levelNum-1, err)
+	}
+}
@@ -3,16 +3,34 @@ package leveldb
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/lemonwx/goleveldb/leveldb/env"
+	"github.com/lemonwx/goleveldb/leveldb/table"
 	"github.com/lemonwx/goleveldb/leveldb/utils"
 	"github.com/lemonwx/log"
 )
 
 const (
-	levelNum              = 5
+	levelNum = 7 // config::kNumLevels
+
+	// Level-0 compaction is started when we hit this many files.
 	kL0_CompactionTrigger = 4
+
+	// Soft limit on number of level-0 files.  We slow down writes at this point.
+	kL0_SlowdownWritesTrigger = 8
+
+	// Maximum number of level-0 files.  We stop writes at this point.
+	kL0_StopWritesTrigger = 12
+
+	// Maximum level to which a new compacted memtable is pushed if it
+	// does not create overlap.  We try to push to level 2 to avoid the
+	// relatively expensive level 0=>1 compactions and to avoid some
+	// expensive manifest file operations.  We do not push all the way to
+	// the largest level since that can generate a lot of wasted disk
+	// space if the same key space is being repeatedly overwritten.
+	kMaxMemCompactLevel = 2
 )
 
 type BySmallestKey struct {
@@ -34,92 +52,163 @@ func (bsk *BySmallestKey) compare(f1, f2 *FileMetaData) bool {
 
 type LevelState struct {
 	deleted_files map[uint64]struct{}
-	added_files   map[*FileMetaData]BySmallestKey
+	added_files   []*FileMetaData
 }
 
+// Builder is a helper class so we can efficiently apply a whole
+// sequence of edits to a particular state without creating
+// intermediate Versions that contain full copies of the
+// intermediate state.
 type Builder struct {
 	vset_   *VersionSet
 	base_   *Version
 	levels_ [levelNum]*LevelState
 }
 
+// NewBuilder initializes a builder with the files from base and
+// other info from vs.
 func NewBuilder(vs *VersionSet, base *Version) *Builder {
 	b := &Builder{vset_: vs, base_: base}
+	base.Ref()
 	for level := 0; level < levelNum; level += 1 {
 		b.levels_[level] = &LevelState{
 			deleted_files: map[uint64]struct{}{},
-			added_files:   map[*FileMetaData]BySmallestKey{},
 		}
 	}
 	return b
 }
 
-func (b *Builder) Apply(ve *VersionEdit) {
-	// todo: iter ve's compact pointers
-	// todo: iter ve's deletes files
-	// todo: iter ve's new files
+// Release drops the references the builder holds on its base version
+// and on the files it added.  The builder must not be used afterwards.
+func (b *Builder) Release() {
+	for level := 0; level < levelNum; level += 1 {
+		for _, f := range b.levels_[level].added_files {
+			f.refs -= 1
+		}
+	}
+	b.base_.Unref()
 }
 
-func (b *Builder) upper_bound(files []*FileMetaData, tgt *FileMetaData, cmp *BySmallestKey) int {
-	for idx, f := range files {
-		if cmp.compare(f, tgt) {
-			return idx
+// Apply all of the edits in ve to the current state.
+func (b *Builder) Apply(ve *VersionEdit) {
+	// Update compaction pointers
+	for _, p := range ve.compact_pointers_ {
+		b.vset_.compact_pointer_[p.level] = p.key.Encode()
+	}
+
+	// Delete files
+	for df := range ve.deleted_files_ {
+		b.levels_[df.level].deleted_files[df.number] = struct{}{}
+	}
+
+	// Add new files
+	for _, nf := range ve.new_files_ {
+		f := *nf.f
+		f.refs = 1
+
+		// We arrange to automatically compact this file after
+		// a certain number of seeks.  Let's assume:
+		//   (1) One seek costs 10ms
+		//   (2) Writing or reading 1MB costs 10ms (100MB/s)
+		//   (3) A compaction of 1MB does 25MB of IO:
+		//         1MB read from this level
+		//         10-12MB read from next level (boundaries may be misaligned)
+		//         10-12MB written to next level
+		// This implies that 25 seeks cost the same as the compaction
+		// of 1MB of data.  I.e., one seek costs approximately the
+		// same as the compaction of 40KB of data.  We are a little
+		// conservative and allow approximately one seek for every 16KB
+		// of data before triggering a compaction.
+		f.allowed_seeks = int(f.file_size / 16384)
+		if f.allowed_seeks < 100 {
+			f.allowed_seeks = 100
 		}
+
+		delete(b.levels_[nf.k].deleted_files, f.number)
+		b.levels_[nf.k].added_files = append(b.levels_[nf.k].added_files, &f)
 	}
-	return len(files)
 }
 
-func (b *Builder) SaveTo(v *Version) {
+// upper_bound returns the index of the first file in files that
+// sorts after tgt.
+func (b *Builder) upper_bound(files []*FileMetaData, tgt *FileMetaData, cmp *BySmallestKey) int {
+	return sort.Search(len(files), func(i int) bool {
+		return cmp.compare(tgt, files[i])
+	})
+}
+
+// SaveTo saves the current state in v.  It returns a corruption
+// error if the files of a level > 0 would overlap in v.
+func (b *Builder) SaveTo(v *Version) error {
 	cmp := &BySmallestKey{}
 	cmp.internal_comparator = b.vset_.icmp_
-	maxUpperIdx := 0
 	for level := 0; level < levelNum; level += 1 {
+		// Merge the set of added files with the set of pre-existing files.
+		// Drop any deleted files.  Store the result in v.
 		base_files := b.base_.files_[level]
 		added_files := b.levels_[level].added_files
-		for f, _ := range added_files {
-			idx := b.upper_bound(base_files, f, cmp)
-			if idx > maxUpperIdx {
-				maxUpperIdx = idx
+		sort.Slice(added_files, func(i, j int) bool {
+			return cmp.compare(added_files[i], added_files[j])
+		})
+		v.files_[level] = make([]*FileMetaData, 0, len(base_files)+len(added_files))
+		for _, added_file := range added_files {
+			// Add all smaller files listed in base_
+			bpos := b.upper_bound(base_files, added_file, cmp)
+			for _, f := range base_files[:bpos] {
+				if err := b.MaybeAddFile(v, level, f); err != nil {
+					return err
+				}
 			}
-			for i := 0; i < idx; i += 1 {
-				b.MaybeAddFile(v, level, base_files[i])
+			base_files = base_files[bpos:]
+
+			if err := b.MaybeAddFile(v, level, added_file); err != nil {
+				return err
 			}
-			b.MaybeAddFile(v, level, f)
 		}
-		for idx := maxUpperIdx; maxUpperIdx < len(base_files); idx += 1 {
-			b.MaybeAddFile(v, level, base_files[idx])
+
+		// Add remaining base files
+		for _, f := range base_files {
+			if err := b.MaybeAddFile(v, level, f); err != nil {
+				return err
+			}
 		}
 	}
+	return nil
 }
 
-func (b *Builder) MaybeAddFile(v *Version, level int, f *FileMetaData) {
-	_, ok := b.levels_[level].deleted_files[f.number]
-	if ok {
-	} else {
-		files := v.files_[level]
-		if level > 0 && len(files) != 0 {
-			f.refs += 1
-			files = append(files, f)
+func (b *Builder) MaybeAddFile(v *Version, level int, f *FileMetaData) error {
+	if _, ok := b.levels_[level].deleted_files[f.number]; ok {
+		// File is deleted: do nothing
+		return nil
+	}
+	files := v.files_[level]
+	if level > 0 && len(files) != 0 {
+		// Must not overlap
+		if b.vset_.icmp_.Compare(files[len(files)-1].largest.Encode(), f.smallest.Encode()) >= 0 {
+			return utils.NewCorruption(fmt.Sprintf("overlapping ranges in level %d: %s vs. %s",
+				level, files[len(files)-1].largest.DebugString(), f.smallest.DebugString()))
 		}
 	}
-
+	f.refs += 1
+	v.files_[level] = append(files, f)
+	return nil
 }
 
 type VersionSet struct {
 	comparator_           string
+	env_                  env.Env
 	dbname_               string
 	next_file_number_     uint64
 	icmp_                 *utils.InternalKeyComparator
 	current_              *Version
 	dummy_versions_       *Version
-	compaction_level_     int
-	compaction_score_     float64
 	manifest_file_number_ uint64
 	last_sequence_        SequenceNumber
 	log_number_           uint64
 	prev_log_number_      uint64
 	opts                  *Options
-	descriptor_file_      *env.WritableFile
+	table_cache_          *TableCache
+	descriptor_file_      env.WritableFile
 	descriptor_log_       *LogWriter
 	compact_pointer_      [levelNum]string
 }
@@ -148,7 +237,7 @@ func (vs *VersionSet) WriteSnapshot(log *LogWriter) error {
 	return log.AddRecord(record)
 }
 
-func (vs *VersionSet) LogAndApply(edit *VersionEdit, mu sync.Mutex) error {
+func (vs *VersionSet) LogAndApply(edit *VersionEdit, mu *sync.Mutex) error {
 	if edit.has_log_number_ {
 		// todo: assert
 	} else {
@@ -164,7 +253,14 @@ func (vs *VersionSet) LogAndApply(edit *VersionEdit, mu sync.Mutex) error {
 	v := NewVersion(vs)
 	builder := NewBuilder(vs, vs.current_)
 	builder.Apply(edit)
-	builder.SaveTo(v)
+	err := builder.SaveTo(v)
+	builder.Release()
+	if err != nil {
+		// Drop the references v took on its files
+		v.Ref()
+		v.Unref()
+		return err
+	}
 	vs.Finalize(v)
 
 	// Initialize new descriptor log file if necessary by creating
@@ -176,45 +272,57 @@ func (vs *VersionSet) LogAndApply(edit *VersionEdit, mu sync.Mutex) error {
 		// todo: assert(descriptor_file_ == nullptr);
 		new_manifest_file = DescriptorFileName(vs.dbname_, vs.manifest_file_number_)
 		edit.SetNextFile(vs.next_file_number_)
-		var err error
-		vs.descriptor_file_, err = env.NewWritableFile(new_manifest_file)
-		if err != nil {
-			return err
-		}
-		vs.descriptor_log_ = NewLogWriter(vs.descriptor_file_)
-		err = vs.WriteSnapshot(vs.descriptor_log_)
-		if err != nil {
-			return err
+		vs.descriptor_file_, err = vs.env_.NewWritableFile(new_manifest_file)
+		if err == nil {
+			vs.descriptor_log_ = NewLogWriter(vs.descriptor_file_)
+			err = vs.WriteSnapshot(vs.descriptor_log_)
 		}
 	}
 
 	// Unlock during expensive MANIFEST log write
 	{
 		mu.Unlock()
+
 		// Write new record to MANIFEST log
-		record := edit.Encode()
-		if err := vs.descriptor_log_.AddRecord(record); err != nil {
-			log.Errorf("MANIFEST write failed: %v\n", err)
-			return err
-		}
-		if err := vs.descriptor_file_.F.Sync(); err != nil {
-			log.Errorf("MANIFEST write failed: %v\n", err)
-			return err
+		if err == nil {
+			record := edit.Encode()
+			err = vs.descriptor_log_.AddRecord(record)
+			if err == nil {
+				err = vs.descriptor_file_.Sync()
+			}
+			if err != nil {
+				vs.opts.info_log.Errorf("MANIFEST write: %v\n", err)
+			}
 		}
 
 		// If we just created a new descriptor file, install it by writing a
 		// new CURRENT file that points to it.
-		if len(new_manifest_file) != 0 {
-			SetCurrentFile(vs.dbname_, vs.manifest_file_number_)
+		if err == nil && len(new_manifest_file) != 0 {
+			err = SetCurrentFile(vs.env_, vs.dbname_, vs.manifest_file_number_)
 		}
+
 		mu.Lock()
 	}
 
 	// Install the new version
-	vs.AppendVersion(v)
-	vs.log_number_ = edit.log_number_
-	vs.prev_log_number_ = edit.prev_log_number_
-	return nil
+	if err == nil {
+		vs.AppendVersion(v)
+		vs.log_number_ = edit.log_number_
+		vs.prev_log_number_ = edit.prev_log_number_
+	} else {
+		// Drop the references v took on its files
+		v.Ref()
+		v.Unref()
+		if len(new_manifest_file) != 0 {
+			if vs.descriptor_file_ != nil {
+				vs.descriptor_file_.Close()
+			}
+			vs.descriptor_log_ = nil
+			vs.descriptor_file_ = nil
+			vs.env_.DeleteFile(new_manifest_file)
+		}
+	}
+	return err
 }
 
 func (vs *VersionSet) NewFileNumber() uint64 {
@@ -223,6 +331,33 @@ func (vs *VersionSet) NewFileNumber() uint64 {
 	return cur
 }
 
+// ReuseFileNumber arranges to reuse "file_number" unless a newer file
+// number has already been allocated.
+// REQUIRES: "file_number" was returned by a call to NewFileNumber().
+func (vs *VersionSet) ReuseFileNumber(file_number uint64) {
+	if vs.next_file_number_ == file_number+1 {
+		vs.next_file_number_ = file_number
+	}
+}
+
+// NumLevelFiles returns the number of files at the specified level.
+func (vs *VersionSet) NumLevelFiles(level int) int {
+	return len(vs.current_.files_[level])
+}
+
+// LastSequence returns the last sequence number.
+func (vs *VersionSet) LastSequence() SequenceNumber {
+	return vs.last_sequence_
+}
+
+// SetLastSequence sets the last sequence number to s.
+func (vs *VersionSet) SetLastSequence(s SequenceNumber) {
+	if s < vs.last_sequence_ {
+		log.Fatal("sequence number went backwards")
+	}
+	vs.last_sequence_ = s
+}
+
 func (vs *VersionSet) AddLiveFiles() map[uint64]struct{} {
 	live := map[uint64]struct{}{}
 	for v := vs.dummy_versions_.next_; v != vs.dummy_versions_; v = v.next_ {
@@ -249,28 +384,57 @@ func (vs *VersionSet) AddLiveFilesToList() []uint64 {
 	return ret
 }
 
+// AppendVersion makes v the current version, the previous current
+// version stays in the live list until its last reference is dropped.
 func (vs *VersionSet) AppendVersion(v *Version) {
+	// Make "v" current
+	if v.refs_ != 0 || v == vs.current_ {
+		panic("leveldb: AppendVersion of a version in use")
+	}
 	if vs.current_ != nil {
 		vs.current_.Unref()
 	}
 	vs.current_ = v
-	vs.current_.Unref()
+	v.Ref()
 
+	// Append to linked list
 	v.prev_ = vs.dummy_versions_.prev_
 	v.next_ = vs.dummy_versions_
 	v.prev_.next_ = v
 	v.next_.prev_ = v
 }
 
-func NewVersionSet(name string, opt *Options) *VersionSet {
-	vs := &VersionSet{dbname_: name, comparator_: opt.Comparator.Name(), opts: opt}
+func NewVersionSet(name string, opt *Options, table_cache *TableCache) *VersionSet {
+	vs := &VersionSet{
+		env_:         opt.Env,
+		dbname_:      name,
+		comparator_:  opt.Comparator.Name(),
+		icmp_:        utils.NewInternalKeyComparator(opt.Comparator),
+		opts:         opt,
+		table_cache_: table_cache,
+	}
 	vs.dummy_versions_ = NewVersion(vs)
 	vs.AppendVersion(NewVersion(vs))
 	return vs
 }
 
+// Close drops the reference of the set on the current version and
+// closes the MANIFEST.
+func (vs *VersionSet) Close() error {
+	vs.current_.Unref()
+	vs.current_ = nil
+	// todo: assert(dummy_versions_.next_ == &dummy_versions_);  // List must be empty
+	vs.descriptor_log_ = nil
+	if vs.descriptor_file_ == nil {
+		return nil
+	}
+	err := vs.descriptor_file_.Close()
+	vs.descriptor_file_ = nil
+	return err
+}
+
 func (vs *VersionSet) Recover(saveManifest bool) (bool, error) {
-	current, err := env.ReadFileToString(CurrentFileName(vs.dbname_))
+	current, err := env.ReadFileToString(vs.env_, CurrentFileName(vs.dbname_))
 	if err != nil {
 		return false, err
 	}
@@ -280,7 +444,7 @@ func (vs *VersionSet) Recover(saveManifest bool) (bool, error) {
 	current = current[:len(current)-1]
 	dscname := vs.dbname_ + "/" + current
 	log.Debugf("dscname: %s", dscname)
-	f, err := env.NewSequentialFile(dscname)
+	f, err := vs.env_.NewSequentialFile(dscname)
 	if err != nil {
 		return false, err
 	}
@@ -293,10 +457,10 @@ func (vs *VersionSet) Recover(saveManifest bool) (bool, error) {
 	last_sequence := SequenceNumber(0)
 	log_number := uint64(0)
 	prev_log_number := uint64(0)
-	reader := NewLogReader(f)
+	reporter := &StatusReporter{}
+	reader := NewLogReader(f, reporter, true, 0)
 	builder := NewBuilder(vs, vs.current_)
-	for {
-		// todo: review reader.ReadRecord
+	for reporter.err == nil {
 		record, err := reader.ReadRecord()
 		if err != nil {
 			break
@@ -305,12 +469,12 @@ func (vs *VersionSet) Recover(saveManifest bool) (bool, error) {
 		if err := edit.DecodeFrom(record); err != nil {
 			return false, err
 		}
-		if edit.comparator_ != vs.comparator_ {
+		if edit.has_comparator_ && edit.comparator_ != vs.comparator_ {
 			err := fmt.Errorf("%s does not match exising comparator %s", edit.comparator_, vs.comparator_)
 			log.Error(err)
 			return false, err
 		}
-		builder.Apply(edit) // todo: impl apply
+		builder.Apply(edit)
 
 		if edit.has_log_number_ {
 			have_log_number = true
@@ -329,7 +493,10 @@ func (vs *VersionSet) Recover(saveManifest bool) (bool, error) {
 			last_sequence = edit.last_sequence_
 		}
 	}
-	f.F.Close()
+	f.Close()
+	if reporter.err != nil {
+		return false, reporter.err
+	}
 	if !have_next_file {
 		log.Errorf("unexpected: should have next file")
 	}
@@ -345,7 +512,13 @@ func (vs *VersionSet) Recover(saveManifest bool) (bool, error) {
 	vs.MarkFileNumberUsed(prev_log_number)
 	vs.MarkFileNumberUsed(log_number)
 	v := NewVersion(vs)
-	builder.SaveTo(v)
+	err = builder.SaveTo(v)
+	builder.Release()
+	if err != nil {
+		v.Ref()
+		v.Unref()
+		return false, err
+	}
 	vs.Finalize(v)
 	vs.AppendVersion(v)
 	vs.manifest_file_number_ = next_file
@@ -378,20 +551,20 @@ func (vs *VersionSet) ReuseManifest(dscname, dscbase string) bool {
 	if manifestType != env.KDescriptorFile {
 		return false
 	}
-	manifestSize, err := env.GetFileSize(dscname)
+	manifestSize, err := vs.env_.GetFileSize(dscname)
 	if err != nil {
 		return false
 	}
-	if manifestSize >= vs.TargetFileSize(vs.opts) {
+	if manifestSize >= uint64(vs.TargetFileSize(vs.opts)) {
 		return false
 	}
-	vs.descriptor_file_, err = env.NewAppendableFile(dscname)
+	vs.descriptor_file_, err = vs.env_.NewAppendableFile(dscname)
 	if err != nil {
 		log.Errorf("Reuse MANIFEST: %s failed: %v", dscname, err)
 		return false
 	}
 	log.Debugf("Reusing MANIFEST: %s", dscname)
-	vs.descriptor_log_ = NewLogWriter(vs.descriptor_file_)
+	vs.descriptor_log_ = NewLogWriterWithLength(vs.descriptor_file_, manifestSize)
 	vs.manifest_file_number_ = manifestNum
 	return true
 }
@@ -410,7 +583,7 @@ func (vs *VersionSet) Finalize(v *Version) {
 	best_level := -1
 	best_score := -1.0
 
-	for level := 0; level < levelNum; level += 1 {
+	for level := 0; level < levelNum-1; level += 1 {
 		score := float64(0)
 		if level == 0 {
 			score = float64(len(v.files_[level])) / float64(kL0_CompactionTrigger)
@@ -422,8 +595,8 @@ func (vs *VersionSet) Finalize(v *Version) {
 			best_score = score
 		}
 	}
-	vs.compaction_level_ = best_level
-	vs.compaction_score_ = best_score
+	v.compaction_level_ = best_level
+	v.compaction_score_ = best_score
 }
 
 func (vs *VersionSet) TotalFileSize(files []*FileMetaData) int64 {
@@ -443,6 +616,25 @@ func (vs *VersionSet) MaxBytesForLevel(level int) float64 {
 	return result
 }
 
+// ExpandedCompactionByteSizeLimit is the maximum number of bytes in all
+// compacted files.  We avoid expanding the lower level file set of a
+// compaction if it would make the total compaction cover more than
+// this many bytes.
+func (vs *VersionSet) ExpandedCompactionByteSizeLimit() int64 {
+	return 25 * int64(vs.TargetFileSize(vs.opts))
+}
+
+// LevelSummary returns a human-readable short (single-line) summary
+// of the number of files per level.
+func (vs *VersionSet) LevelSummary() string {
+	summary := "files["
+	for level := 0; level < levelNum; level += 1 {
+		summary += fmt.Sprintf(" %d", len(vs.current_.files_[level]))
+	}
+	return summary + " ]"
+}
+
+// NeedsCompaction returns true iff some level needs a compaction.
 func (vs *VersionSet) NeedsCompaction() bool {
 	v := vs.current_
 	return (v.compaction_score_ >= 1) || (v.file_to_compact_ != nil)
@@ -472,8 +664,865 @@ func NewVersion(vs *VersionSet) *Version {
 	return v
 }
 
+// Ref pins v, so its files stay live until the matching Unref.
+// REQUIRES: lock is held
+func (v *Version) Ref() {
+	v.refs_ += 1
+}
+
+// Unref drops a reference to v.  Once the last reference is gone v is
+// removed from the list of live versions, so the files only it refers
+// to can be deleted by DeleteObsoleteFiles.
+// REQUIRES: lock is held
 func (v *Version) Unref() {
+	if v == v.vset_.dummy_versions_ {
+		panic("leveldb: Unref of the dummy version")
+	}
+	if v.refs_ < 1 {
+		panic("leveldb: Unref of an unreferenced version")
+	}
 	v.refs_ -= 1
 	if v.refs_ == 0 {
+		// Remove from linked list
+		v.prev_.next_ = v.next_
+		v.next_.prev_ = v.prev_
+		v.prev_ = v
+		v.next_ = v
+
+		// Drop references to files
+		for level := 0; level < levelNum; level += 1 {
+			for _, f := range v.files_[level] {
+				f.refs -= 1
+			}
+		}
+	}
+}
+
+// FindFile returns the smallest index i such that files[i].largest >= key.
+// Returns len(files) if there is no such file.
+// REQUIRES: "files" contains a sorted list of non-overlapping files.
+func FindFile(icmp *utils.InternalKeyComparator, files []*FileMetaData, key string) int {
+	return sort.Search(len(files), func(i int) bool {
+		return icmp.Compare(files[i].largest.Encode(), key) >= 0
+	})
+}
+
+// GetStats records the first file a Version.Get had to read past, it
+// is charged for the seek via UpdateStats.
+type GetStats struct {
+	seek_file       *FileMetaData
+	seek_file_level int
+}
+
+type SaverState int
+
+const (
+	kNotFound SaverState = iota
+	kFound
+	kDeleted
+	kCorrupt
+)
+
+// Saver collects the result of a point lookup in a table file.
+type Saver struct {
+	state    SaverState
+	ucmp     utils.Comparator
+	user_key string
+	value    []byte
+}
+
+// SaveValue is called with the first entry at or after the lookup key.
+func (s *Saver) SaveValue(ikey, v []byte) {
+	parsed_key, ok := ParseInternalKey(string(ikey))
+	if !ok {
+		s.state = kCorrupt
+		return
+	}
+	if s.ucmp.Compare(parsed_key.user_key, s.user_key) == 0 {
+		if parsed_key.Type == kTypeValue {
+			s.state = kFound
+			s.value = append([]byte{}, v...)
+		} else {
+			s.state = kDeleted
+		}
+	}
+}
+
+// LevelFileNumIterator is an internal iterator.  For a given
+// version/level pair, yields information about the files in the level.
+// For a given entry, Key() is the largest key that occurs in the file,
+// and Value() is a 16-byte value containing the file number and file
+// size, both encoded using EncodeFixed64.
+type LevelFileNumIterator struct {
+	icmp_      *utils.InternalKeyComparator
+	flist_     []*FileMetaData
+	index_     int
+	value_buf_ [16]byte // Backing store for Value().  Holds the file number and size.
+}
+
+func NewLevelFileNumIterator(icmp *utils.InternalKeyComparator, flist []*FileMetaData) *LevelFileNumIterator {
+	// Marks as invalid
+	return &LevelFileNumIterator{icmp_: icmp, flist_: flist, index_: len(flist)}
+}
+
+func (it *LevelFileNumIterator) Valid() bool {
+	return it.index_ < len(it.flist_)
+}
+
+func (it *LevelFileNumIterator) Seek(target []byte) {
+	it.index_ = FindFile(it.icmp_, it.flist_, string(target))
+}
+
+func (it *LevelFileNumIterator) SeekToFirst() {
+	it.index_ = 0
+}
+
+func (it *LevelFileNumIterator) SeekToLast() {
+	if len(it.flist_) == 0 {
+		it.index_ = 0
+	} else {
+		it.index_ = len(it.flist_) - 1
+	}
+}
+
+func (it *LevelFileNumIterator) Next() {
+	it.index_ += 1
+}
+
+func (it *LevelFileNumIterator) Prev() {
+	if it.index_ == 0 {
+		it.index_ = len(it.flist_) // Marks as invalid
+	} else {
+		it.index_ -= 1
+	}
+}
+
+func (it *LevelFileNumIterator) Key() []byte {
+	return []byte(it.flist_[it.index_].largest.Encode())
+}
+
+func (it *LevelFileNumIterator) Value() []byte {
+	utils.EncodeFixed64(it.value_buf_[:], it.flist_[it.index_].number)
+	utils.EncodeFixed64(it.value_buf_[8:], it.flist_[it.index_].file_size)
+	return it.value_buf_[:]
+}
+
+func (it *LevelFileNumIterator) Error() error {
+	return nil
+}
+
+func (it *LevelFileNumIterator) Close() error {
+	return nil
+}
+
+// GetFileIterator is the table.BlockFunction that opens the file
+// described by a LevelFileNumIterator value.
+func (vs *VersionSet) GetFileIterator(options *table.ReadOptions, file_value []byte) table.Iterator {
+	if len(file_value) != 16 {
+		return table.NewErrorIterator(utils.NewCorruption("FileReader invoked with unexpected value"))
+	}
+	return vs.table_cache_.NewIterator(options, utils.DecodeFixed64(file_value), utils.DecodeFixed64(file_value[8:]))
+}
+
+// NewConcatenatingIterator returns an iterator that sequentially walks
+// through the non-overlapping files of level, opening them lazily.
+func (v *Version) NewConcatenatingIterator(options *table.ReadOptions, level int) table.Iterator {
+	return table.NewTwoLevelIterator(NewLevelFileNumIterator(v.vset_.icmp_, v.FilesInBounds(options, level)), v.vset_.GetFileIterator, options, v.vset_.icmp_)
+}
+
+// FilesInBounds returns the files of level that may hold keys within
+// options.LowerBound and options.UpperBound, the other files need not
+// be opened by the iterators.
+func (v *Version) FilesInBounds(options *table.ReadOptions, level int) []*FileMetaData {
+	icmp := v.vset_.icmp_
+	files := v.files_[level]
+	if options.LowerBound == nil && options.UpperBound == nil {
+		return files
+	}
+	before_lower := func(f *FileMetaData) bool {
+		return options.LowerBound != nil && icmp.Compare(f.largest.Encode(), string(options.LowerBound)) < 0
+	}
+	past_upper := func(f *FileMetaData) bool {
+		return options.UpperBound != nil && icmp.Compare(f.smallest.Encode(), string(options.UpperBound)) >= 0
+	}
+
+	if level == 0 {
+		// Level-0 files may overlap each other, check them all
+		var ret []*FileMetaData
+		for _, f := range files {
+			if !before_lower(f) && !past_upper(f) {
+				ret = append(ret, f)
+			}
+		}
+		return ret
+	}
+
+	// Files of other levels are sorted and disjoint
+	if options.LowerBound != nil {
+		files = files[FindFile(icmp, files, string(options.LowerBound)):]
+	}
+	return files[:sort.Search(len(files), func(i int) bool { return past_upper(files[i]) })]
+}
+
+// AddIterators appends to iters a sequence of iterators that will
+// yield the contents of this Version when merged together.
+// REQUIRES: This version has been saved (see VersionSet.SaveTo)
+func (v *Version) AddIterators(options *table.ReadOptions, iters []table.Iterator) []table.Iterator {
+	// Merge all level zero files together since they may overlap
+	for _, f := range v.FilesInBounds(options, 0) {
+		iters = append(iters, v.vset_.table_cache_.NewIterator(options, f.number, f.file_size))
+	}
+
+	// For levels > 0, we can use a concatenating iterator that sequentially
+	// walks through the non-overlapping files in the level, opening them
+	// lazily.
+	for level := 1; level < levelNum; level += 1 {
+		if len(v.files_[level]) != 0 {
+			iters = append(iters, v.NewConcatenatingIterator(options, level))
+		}
+	}
+	return iters
+}
+
+func (v *Version) getFromFile(options *ReadOptions, f *FileMetaData, ikey string, saver *Saver) error {
+	return v.vset_.table_cache_.Get(options.tableReadOptions(), f.number, f.file_size, []byte(ikey), saver.SaveValue)
+}
+
+// Get looks up the value for key. If found, returns it.
+// Else returns ErrNotFound. Fills stats with the file that should be
+// charged for the seek.
+// REQUIRES: lock is not held
+func (v *Version) Get(options *ReadOptions, k *LookupKey, stats *GetStats) ([]byte, error) {
+	ikey := k.internal_key()
+	user_key := k.user_key()
+	ucmp := v.vset_.icmp_.User_comparator()
+
+	stats.seek_file = nil
+	stats.seek_file_level = -1
+	var last_file_read *FileMetaData
+	last_file_read_level := -1
+
+	// We can search level-by-level since entries never hop across
+	// levels.  Therefore we are guaranteed that if we find data
+	// in a smaller level, later levels are irrelevant.
+	for level := 0; level < levelNum; level += 1 {
+		files := v.files_[level]
+		if len(files) == 0 {
+			continue
+		}
+
+		if level == 0 {
+			// Level-0 files may overlap each other.  Find all files that
+			// overlap user_key and process them in order from newest to oldest.
+			tmp := make([]*FileMetaData, 0, len(files))
+			for _, f := range files {
+				if ucmp.Compare(user_key, f.smallest.user_key()) >= 0 &&
+					ucmp.Compare(user_key, f.largest.user_key()) <= 0 {
+					tmp = append(tmp, f)
+				}
+			}
+			if len(tmp) == 0 {
+				continue
+			}
+			sort.Slice(tmp, func(i, j int) bool {
+				return tmp[i].number > tmp[j].number
+			})
+			files = tmp
+		} else {
+			// Binary search to find earliest index whose largest key >= ikey.
+			index := FindFile(v.vset_.icmp_, files, ikey)
+			if index >= len(files) {
+				continue
+			}
+			f := files[index]
+			if ucmp.Compare(user_key, f.smallest.user_key()) < 0 {
+				// All of "f" is past any data for user_key
+				continue
+			}
+			files = []*FileMetaData{f}
+		}
+
+		for _, f := range files {
+			if last_file_read != nil && stats.seek_file == nil {
+				// We have had more than one seek for this read.  Charge the 1st file.
+				stats.seek_file = last_file_read
+				stats.seek_file_level = last_file_read_level
+			}
+			last_file_read = f
+			last_file_read_level = level
+
+			saver := &Saver{state: kNotFound, ucmp: ucmp, user_key: user_key}
+			if err := v.getFromFile(options, f, ikey, saver); err != nil {
+				return nil, err
+			}
+			switch saver.state {
+			case kNotFound:
+				// Keep searching in other files
+			case kFound:
+				return saver.value, nil
+			case kDeleted:
+				return nil, ErrNotFound
+			case kCorrupt:
+				return nil, utils.NewCorruption("corrupted key for " + user_key)
+			}
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// UpdateStats adds "stats" into the current state.  Returns true if a new
+// compaction may need to be triggered, false otherwise.
+// REQUIRES: lock is held
+func (v *Version) UpdateStats(stats *GetStats) bool {
+	f := stats.seek_file
+	if f != nil {
+		f.allowed_seeks -= 1
+		if f.allowed_seeks <= 0 && v.file_to_compact_ == nil {
+			v.file_to_compact_ = f
+			v.file_to_compact_level = stats.seek_file_level
+			return true
+		}
+	}
+	return false
+}
+
+func AfterFile(ucmp utils.Comparator, user_key *string, f *FileMetaData) bool {
+	// nil user_key occurs before all keys and is therefore never after *f
+	return user_key != nil && ucmp.Compare(*user_key, f.largest.user_key()) > 0
+}
+
+func BeforeFile(ucmp utils.Comparator, user_key *string, f *FileMetaData) bool {
+	// nil user_key occurs after all keys and is therefore never before *f
+	return user_key != nil && ucmp.Compare(*user_key, f.smallest.user_key()) < 0
+}
+
+// SomeFileOverlapsRange returns true iff some file in "files" overlaps
+// the user key range [*smallest,*largest].
+// smallest==nil represents a key smaller than all keys in the DB.
+// largest==nil represents a key largest than all keys in the DB.
+// REQUIRES: If disjoint_sorted_files, files[] contains disjoint
+// ranges in sorted order.
+func SomeFileOverlapsRange(icmp *utils.InternalKeyComparator, disjoint_sorted_files bool, files []*FileMetaData,
+	smallest_user_key *string, largest_user_key *string) bool {
+	ucmp := icmp.User_comparator()
+	if !disjoint_sorted_files {
+		// Need to check against all files
+		for _, f := range files {
+			if AfterFile(ucmp, smallest_user_key, f) || BeforeFile(ucmp, largest_user_key, f) {
+				// No overlap
+			} else {
+				return true // Overlap
+			}
+		}
+		return false
+	}
+
+	// Binary search over file list
+	index := 0
+	if smallest_user_key != nil {
+		// Find the earliest possible internal key for smallest_user_key
+		small_key := NewInternalKey(*smallest_user_key, kMaxSequenceNumber, kValueTypeForSeek)
+		index = FindFile(icmp, files, small_key.Encode())
+	}
+
+	if index >= len(files) {
+		// beginning of range is after all files, so no overlap.
+		return false
+	}
+
+	return !BeforeFile(ucmp, largest_user_key, files[index])
+}
+
+// OverlapInLevel returns true iff some file in the specified level
+// overlaps some part of [*smallest_user_key,*largest_user_key].
+// smallest_user_key==nil represents a key smaller than all the DB's keys.
+// largest_user_key==nil represents a key largest than all the DB's keys.
+func (v *Version) OverlapInLevel(level int, smallest_user_key *string, largest_user_key *string) bool {
+	return SomeFileOverlapsRange(v.vset_.icmp_, level > 0, v.files_[level], smallest_user_key, largest_user_key)
+}
+
+// GetOverlappingInputs returns the files in level that overlap [begin,end].
+// begin==nil means before all keys, end==nil means after all keys.
+func (v *Version) GetOverlappingInputs(level int, begin *InternalKey, end *InternalKey) []*FileMetaData {
+	inputs := []*FileMetaData{}
+	var user_begin, user_end string
+	if begin != nil {
+		user_begin = begin.user_key()
+	}
+	if end != nil {
+		user_end = end.user_key()
+	}
+	user_cmp := v.vset_.icmp_.User_comparator()
+	for i := 0; i < len(v.files_[level]); {
+		f := v.files_[level][i]
+		i += 1
+		file_start := f.smallest.user_key()
+		file_limit := f.largest.user_key()
+		if begin != nil && user_cmp.Compare(file_limit, user_begin) < 0 {
+			// "f" is completely before specified range; skip it
+		} else if end != nil && user_cmp.Compare(file_start, user_end) > 0 {
+			// "f" is completely after specified range; skip it
+		} else {
+			inputs = append(inputs, f)
+			if level == 0 {
+				// Level-0 files may overlap each other.  So check if the newly
+				// added file has expanded the range.  If so, restart search.
+				if begin != nil && user_cmp.Compare(file_start, user_begin) < 0 {
+					user_begin = file_start
+					inputs = inputs[:0]
+					i = 0
+				} else if end != nil && user_cmp.Compare(file_limit, user_end) > 0 {
+					user_end = file_limit
+					inputs = inputs[:0]
+					i = 0
+				}
+			}
+		}
+	}
+	return inputs
+}
+
+// MaxGrandParentOverlapBytes is the maximum bytes of overlaps in
+// grandparent (i.e., level+2) before we stop building a single file
+// in a level->level+1 compaction.
+func (vs *VersionSet) MaxGrandParentOverlapBytes() int64 {
+	return 10 * int64(vs.TargetFileSize(vs.opts))
+}
+
+// PickLevelForMemTableOutput returns the level at which we should place a new memtable compaction
+// result that covers the range [smallest_user_key,largest_user_key].
+func (v *Version) PickLevelForMemTableOutput(smallest_user_key string, largest_user_key string) int {
+	level := 0
+	if !v.OverlapInLevel(0, &smallest_user_key, &largest_user_key) {
+		// Push to next level if there is no overlap in next level,
+		// and the #bytes overlapping in the level after that are limited.
+		start := NewInternalKey(smallest_user_key, kMaxSequenceNumber, kValueTypeForSeek)
+		limit := NewInternalKey(largest_user_key, 0, ValueType(0))
+		for level < kMaxMemCompactLevel {
+			if v.OverlapInLevel(level+1, &smallest_user_key, &largest_user_key) {
+				break
+			}
+			if level+2 < levelNum {
+				// Check that file does not overlap too many grandparent bytes.
+				overlaps := v.GetOverlappingInputs(level+2, start, limit)
+				sum := v.vset_.TotalFileSize(overlaps)
+				if sum > v.vset_.MaxGrandParentOverlapBytes() {
+					break
+				}
+			}
+			level += 1
+		}
+	}
+	return level
+}
+
+// GetRange stores the minimal range that covers all entries in inputs
+// in smallest, largest.
+// REQUIRES: inputs is not empty
+func (vs *VersionSet) GetRange(inputs []*FileMetaData) (*InternalKey, *InternalKey) {
+	if len(inputs) == 0 {
+		panic("leveldb: GetRange of no files")
+	}
+	smallest := inputs[0].smallest
+	largest := inputs[0].largest
+	for _, f := range inputs[1:] {
+		if vs.icmp_.Compare(f.smallest.Encode(), smallest.Encode()) < 0 {
+			smallest = f.smallest
+		}
+		if vs.icmp_.Compare(f.largest.Encode(), largest.Encode()) > 0 {
+			largest = f.largest
+		}
+	}
+	return smallest, largest
+}
+
+// GetRange2 stores the minimal range that covers all entries in inputs1
+// and inputs2 in smallest, largest.
+// REQUIRES: inputs is not empty
+func (vs *VersionSet) GetRange2(inputs1 []*FileMetaData, inputs2 []*FileMetaData) (*InternalKey, *InternalKey) {
+	all := append(append([]*FileMetaData{}, inputs1...), inputs2...)
+	return vs.GetRange(all)
+}
+
+// MakeInputIterator creates an iterator that reads over the compaction
+// inputs for "c".
+func (vs *VersionSet) MakeInputIterator(c *Compaction) table.Iterator {
+	options := &table.ReadOptions{
+		VerifyChecksums: vs.opts.ParanoidChecks,
+		FillCache:       false,
+	}
+
+	// Level-0 files have to be merged together.  For other levels,
+	// we will make a concatenating iterator per level.
+	list := []table.Iterator{}
+	for which := 0; which < 2; which += 1 {
+		if len(c.inputs_[which]) != 0 {
+			if c.level()+which == 0 {
+				for _, f := range c.inputs_[which] {
+					list = append(list, vs.table_cache_.NewIterator(options, f.number, f.file_size))
+				}
+			} else {
+				// Create concatenating iterator for the files from this level
+				list = append(list, table.NewTwoLevelIterator(
+					NewLevelFileNumIterator(vs.icmp_, c.inputs_[which]), vs.GetFileIterator, options, vs.icmp_))
+			}
+		}
+	}
+	return table.NewMergingIterator(vs.icmp_, list)
+}
+
+// PickCompaction picks level and inputs for a new compaction.
+// Returns nil if there is no compaction to be done.
+// Otherwise returns a Compaction object that describes the compaction.
+// REQUIRES: lock is held
+func (vs *VersionSet) PickCompaction() *Compaction {
+	var c *Compaction
+	level := 0
+
+	// We prefer compactions triggered by too much data in a level over
+	// the compactions triggered by seeks.
+	size_compaction := vs.current_.compaction_score_ >= 1
+	seek_compaction := vs.current_.file_to_compact_ != nil
+	if size_compaction {
+		level = vs.current_.compaction_level_
+		c = NewCompaction(vs.opts, level)
+
+		// Pick the first file that comes after compact_pointer_[level]
+		for _, f := range vs.current_.files_[level] {
+			if len(vs.compact_pointer_[level]) == 0 ||
+				vs.icmp_.Compare(f.largest.Encode(), vs.compact_pointer_[level]) > 0 {
+				c.inputs_[0] = append(c.inputs_[0], f)
+				break
+			}
+		}
+		if len(c.inputs_[0]) == 0 {
+			// Wrap-around to the beginning of the key space
+			c.inputs_[0] = append(c.inputs_[0], vs.current_.files_[level][0])
+		}
+	} else if seek_compaction {
+		level = vs.current_.file_to_compact_level
+		c = NewCompaction(vs.opts, level)
+		c.inputs_[0] = append(c.inputs_[0], vs.current_.file_to_compact_)
+	} else {
+		return nil
+	}
+
+	c.input_version_ = vs.current_
+	c.input_version_.Ref()
+
+	// Files in level 0 may overlap each other, so pick up all overlapping ones
+	if level == 0 {
+		smallest, largest := vs.GetRange(c.inputs_[0])
+		// Note that the next call will discard the file we placed in
+		// c.inputs_[0] earlier and replace it with an overlapping set
+		// which will include the picked file.
+		c.inputs_[0] = vs.current_.GetOverlappingInputs(0, smallest, largest)
+	}
+
+	vs.SetupOtherInputs(c)
+	return c
+}
+
+// MaxFileSizeForLevel returns the size limit of the files a
+// compaction writes to level.
+func (vs *VersionSet) MaxFileSizeForLevel(level int) uint64 {
+	// We could vary per level to reduce number of files?
+	return uint64(vs.TargetFileSize(vs.opts))
+}
+
+// CompactRange returns a compaction for the range [begin,end] in the
+// specified level.  Returns nil if there is nothing in that level that
+// overlaps the specified range.
+// begin==nil means before all keys, end==nil means after all keys.
+// REQUIRES: lock is held
+func (vs *VersionSet) CompactRange(level int, begin *InternalKey, end *InternalKey) *Compaction {
+	inputs := vs.current_.GetOverlappingInputs(level, begin, end)
+	if len(inputs) == 0 {
+		return nil
+	}
+
+	// Avoid compacting too much in one shot in case the range is large.
+	// But we cannot do this for level-0 since level-0 files can overlap
+	// and we must not pick one file and drop another older file if the
+	// two files overlap.
+	if level > 0 {
+		limit := vs.MaxFileSizeForLevel(level)
+		total := uint64(0)
+		for i, f := range inputs {
+			total += f.file_size
+			if total >= limit {
+				inputs = inputs[:i+1]
+				break
+			}
+		}
+	}
+
+	c := NewCompaction(vs.opts, level)
+	c.input_version_ = vs.current_
+	c.input_version_.Ref()
+	c.inputs_[0] = inputs
+	vs.SetupOtherInputs(c)
+	return c
+}
+
+// FindLargestKey finds the largest key in a vector of files.
+// Returns false if files is empty.
+func FindLargestKey(icmp *utils.InternalKeyComparator, files []*FileMetaData) (*InternalKey, bool) {
+	if len(files) == 0 {
+		return nil, false
+	}
+	largest_key := files[0].largest
+	for _, f := range files[1:] {
+		if icmp.Compare(f.largest.Encode(), largest_key.Encode()) > 0 {
+			largest_key = f.largest
+		}
+	}
+	return largest_key, true
+}
+
+// FindSmallestBoundaryFile finds the minimum file b2=(l2, u2) in
+// level_files for which l2 > u1 and user_key(l2) = user_key(u1).
+func FindSmallestBoundaryFile(icmp *utils.InternalKeyComparator, level_files []*FileMetaData,
+	largest_key *InternalKey) *FileMetaData {
+	user_cmp := icmp.User_comparator()
+	var smallest_boundary_file *FileMetaData
+	for _, f := range level_files {
+		if icmp.Compare(f.smallest.Encode(), largest_key.Encode()) > 0 &&
+			user_cmp.Compare(f.smallest.user_key(), largest_key.user_key()) == 0 {
+			if smallest_boundary_file == nil ||
+				icmp.Compare(f.smallest.Encode(), smallest_boundary_file.smallest.Encode()) < 0 {
+				smallest_boundary_file = f
+			}
+		}
+	}
+	return smallest_boundary_file
+}
+
+// AddBoundaryInputs extends the compaction files in compaction_files
+// with the boundary files of level_files.
+//
+// A boundary file is any file b2=(l2, u2) in level_files whose smallest
+// key has the same user key as the largest key u1 of a file
+// b1=(l1, u1) in compaction_files, with l2 > u1.  If such a file is left
+// out of the compaction, a Get of that user key would find the older
+// entry in b2 in this level after b1's newer entry moved to level+1,
+// so we have to compact b2 as well.  We repeat this until no boundary
+// file is left.
+func AddBoundaryInputs(icmp *utils.InternalKeyComparator, level_files []*FileMetaData,
+	compaction_files *[]*FileMetaData) {
+	// Quick return if compaction_files is empty.
+	largest_key, ok := FindLargestKey(icmp, *compaction_files)
+	if !ok {
+		return
+	}
+
+	for {
+		smallest_boundary_file := FindSmallestBoundaryFile(icmp, level_files, largest_key)
+
+		// If a boundary file was found advance largest_key, otherwise we're done.
+		if smallest_boundary_file == nil {
+			break
+		}
+		*compaction_files = append(*compaction_files, smallest_boundary_file)
+		largest_key = smallest_boundary_file.largest
+	}
+}
+
+// SetupOtherInputs picks the level+1 inputs of c, and grows its level
+// inputs when that does not pull in more level+1 files.
+// REQUIRES: lock is held
+func (vs *VersionSet) SetupOtherInputs(c *Compaction) {
+	level := c.level()
+	current := vs.current_
+
+	AddBoundaryInputs(vs.icmp_, current.files_[level], &c.inputs_[0])
+	smallest, largest := vs.GetRange(c.inputs_[0])
+
+	c.inputs_[1] = current.GetOverlappingInputs(level+1, smallest, largest)
+	AddBoundaryInputs(vs.icmp_, current.files_[level+1], &c.inputs_[1])
+
+	// Get entire range covered by compaction
+	all_start, all_limit := vs.GetRange2(c.inputs_[0], c.inputs_[1])
+
+	// See if we can grow the number of inputs in "level" without
+	// changing the number of "level+1" files we pick up.
+	if len(c.inputs_[1]) != 0 {
+		expanded0 := current.GetOverlappingInputs(level, all_start, all_limit)
+		AddBoundaryInputs(vs.icmp_, current.files_[level], &expanded0)
+		inputs0_size := vs.TotalFileSize(c.inputs_[0])
+		inputs1_size := vs.TotalFileSize(c.inputs_[1])
+		expanded0_size := vs.TotalFileSize(expanded0)
+		if len(expanded0) > len(c.inputs_[0]) &&
+			inputs1_size+expanded0_size < vs.ExpandedCompactionByteSizeLimit() {
+			new_start, new_limit := vs.GetRange(expanded0)
+			expanded1 := current.GetOverlappingInputs(level+1, new_start, new_limit)
+			AddBoundaryInputs(vs.icmp_, current.files_[level+1], &expanded1)
+			if len(expanded1) == len(c.inputs_[1]) {
+				vs.opts.info_log.Infof("Expanding@%d %d+%d (%d+%d bytes) to %d+%d (%d+%d bytes)\n",
+					level, len(c.inputs_[0]), len(c.inputs_[1]), inputs0_size, inputs1_size,
+					len(expanded0), len(expanded1), expanded0_size, inputs1_size)
+				smallest = new_start
+				largest = new_limit
+				c.inputs_[0] = expanded0
+				c.inputs_[1] = expanded1
+				all_start, all_limit = vs.GetRange2(c.inputs_[0], c.inputs_[1])
+			}
+		}
+	}
+
+	// Compute the set of grandparent files that overlap this compaction
+	// (parent == level+1; grandparent == level+2)
+	if level+2 < levelNum {
+		c.grandparents_ = current.GetOverlappingInputs(level+2, all_start, all_limit)
+	}
+
+	// Update the place where we will do the next compaction for this level.
+	// We update this immediately instead of waiting for the VersionEdit
+	// to be applied so that if the compaction fails, we will try a different
+	// key range next time.
+	vs.compact_pointer_[level] = largest.Encode()
+	c.edit_.SetComparatorPointer(level, largest)
+}
+
+// Compaction encapsulates information about a compaction.
+type Compaction struct {
+	level_                int
+	max_output_file_size_ uint64
+	input_version_        *Version
+	edit_                 *VersionEdit
+
+	// Each compaction reads inputs from "level_" and "level_+1"
+	inputs_ [2][]*FileMetaData // The two sets of inputs
+
+	// State used to check for number of overlapping grandparent files
+	// (parent == level_ + 1, grandparent == level_ + 2)
+	grandparents_      []*FileMetaData
+	grandparent_index_ int   // Index in grandparent_starts_
+	seen_key_          bool  // Some output key has been seen
+	overlapped_bytes_  int64 // Bytes of overlap between current output
+	// and grandparent files
+
+	// State for implementing IsBaseLevelForKey
+
+	// level_ptrs_ holds indices into input_version_.files_: our state
+	// is that we are positioned at one of the file ranges for each
+	// higher level than the ones involved in this compaction (i.e. for
+	// all L >= level_ + 2).
+	level_ptrs_ [levelNum]int
+}
+
+func NewCompaction(options *Options, level int) *Compaction {
+	return &Compaction{
+		level_:                level,
+		max_output_file_size_: uint64(options.MaxFileSize),
+		edit_:                 NewVersionEdit(),
+	}
+}
+
+// level returns the level that is being compacted.  Inputs from
+// "level" and "level+1" will be merged to produce a set of "level+1"
+// files.
+func (c *Compaction) level() int {
+	return c.level_
+}
+
+// Edit returns the object that holds the edits to the descriptor done
+// by this compaction.
+func (c *Compaction) Edit() *VersionEdit {
+	return c.edit_
+}
+
+// num_input_files returns the number of input files, "which" must be
+// either 0 or 1.
+func (c *Compaction) num_input_files(which int) int {
+	return len(c.inputs_[which])
+}
+
+// input returns the ith input file at "level()+which" ("which" must be 0 or 1).
+func (c *Compaction) input(which int, i int) *FileMetaData {
+	return c.inputs_[which][i]
+}
+
+// MaxOutputFileSize returns the maximum size of files to build during
+// this compaction.
+func (c *Compaction) MaxOutputFileSize() uint64 {
+	return c.max_output_file_size_
+}
+
+// IsTrivialMove returns true if this is a trivial compaction that can
+// be implemented by just moving a single input file to the next level
+// (no merging or splitting).
+func (c *Compaction) IsTrivialMove() bool {
+	vset := c.input_version_.vset_
+	// Avoid a move if there is lots of overlapping grandparent data.
+	// Otherwise, the move could create a parent file that will require
+	// a very expensive merge later on.
+	return c.num_input_files(0) == 1 && c.num_input_files(1) == 0 &&
+		vset.TotalFileSize(c.grandparents_) <= vset.MaxGrandParentOverlapBytes()
+}
+
+// AddInputDeletions adds all inputs to this compaction as delete
+// operations to *edit.
+func (c *Compaction) AddInputDeletions(edit *VersionEdit) {
+	for which := 0; which < 2; which += 1 {
+		for _, f := range c.inputs_[which] {
+			edit.DeleteFile(c.level_+which, f.number)
+		}
+	}
+}
+
+// IsBaseLevelForKey returns true if the information we have available
+// guarantees that the compaction is producing data in "level+1" for
+// which no data exists in levels greater than "level+1".
+func (c *Compaction) IsBaseLevelForKey(user_key string) bool {
+	// Maybe use binary search to find right entry instead of linear search?
+	user_cmp := c.input_version_.vset_.icmp_.User_comparator()
+	for lvl := c.level_ + 2; lvl < levelNum; lvl += 1 {
+		files := c.input_version_.files_[lvl]
+		for c.level_ptrs_[lvl] < len(files) {
+			f := files[c.level_ptrs_[lvl]]
+			if user_cmp.Compare(user_key, f.largest.user_key()) <= 0 {
+				// We've advanced far enough
+				if user_cmp.Compare(user_key, f.smallest.user_key()) >= 0 {
+					// Key falls in this file's range, so definitely not base level
+					return false
+				}
+				break
+			}
+			c.level_ptrs_[lvl] += 1
+		}
+	}
+	return true
+}
+
+// ShouldStopBefore returns true iff we should stop building the current
+// output before processing "internal_key".
+func (c *Compaction) ShouldStopBefore(internal_key []byte) bool {
+	vset := c.input_version_.vset_
+	// Scan to find earliest grandparent file that contains key.
+	icmp := vset.icmp_
+	for c.grandparent_index_ < len(c.grandparents_) &&
+		icmp.Compare(string(internal_key), c.grandparents_[c.grandparent_index_].largest.Encode()) > 0 {
+		if c.seen_key_ {
+			c.overlapped_bytes_ += int64(c.grandparents_[c.grandparent_index_].file_size)
+		}
+		c.grandparent_index_ += 1
+	}
+	c.seen_key_ = true
+
+	if c.overlapped_bytes_ > vset.MaxGrandParentOverlapBytes() {
+		// Too much overlap for current output; start new output
+		c.overlapped_bytes_ = 0
+		return true
+	}
+	return false
+}
+
+// ReleaseInputs releases the input version for the compaction, once
+// the compaction is successful.
+func (c *Compaction) ReleaseInputs() {
+	if c.input_version_ != nil {
+		c.input_version_.Unref()
+		c.input_version_ = nil
 	}
 }
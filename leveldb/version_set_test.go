@@ -0,0 +1,451 @@
+package leveldb
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/lemonwx/goleveldb/leveldb/env"
+	"github.com/lemonwx/goleveldb/leveldb/utils"
+)
+
+func TestFindFile(t *testing.T) {
+	icmp := utils.NewInternalKeyComparator(&utils.BytewiseComparator{})
+	var files []*FileMetaData
+	add := func(smallest, largest string) {
+		files = append(files, &FileMetaData{
+			number:   uint64(len(files) + 1),
+			smallest: NewInternalKey(smallest, 100, kTypeValue),
+			largest:  NewInternalKey(largest, 100, kTypeValue),
+		})
+	}
+	find := func(key string) int {
+		return FindFile(icmp, files, NewInternalKey(key, kMaxSequenceNumber, kValueTypeForSeek).Encode())
+	}
+
+	if got := find("foo"); got != 0 {
+		t.Errorf("empty: FindFile(foo) = %d, want 0", got)
+	}
+
+	add("150", "200")
+	add("200", "250")
+	add("300", "350")
+	add("400", "450")
+	tests := []struct {
+		key  string
+		want int
+	}{
+		{"100", 0},
+		{"150", 0},
+		{"151", 0},
+		{"199", 0},
+		{"200", 0},
+		{"201", 1},
+		{"249", 1},
+		{"250", 1},
+		{"251", 2},
+		{"299", 2},
+		{"300", 2},
+		{"349", 2},
+		{"350", 2},
+		{"351", 3},
+		{"400", 3},
+		{"450", 3},
+		{"451", 4},
+	}
+	for _, tt := range tests {
+		if got := find(tt.key); got != tt.want {
+			t.Errorf("FindFile(%q) = %d, want %d", tt.key, got, tt.want)
+		}
+	}
+}
+
+// testVersion returns a version of a set whose files are at most
+// max_file_size bytes, with a file per [smallest, largest, size] in
+// each level of files.
+func testVersion(max_file_size int, files map[int][][3]string) *Version {
+	vs := NewVersionSet("testdb", &Options{Comparator: &utils.BytewiseComparator{}, MaxFileSize: max_file_size}, nil)
+	v := NewVersion(vs)
+	number := uint64(1)
+	for level := 0; level < levelNum; level++ {
+		for _, r := range files[level] {
+			size, _ := strconv.Atoi(r[2])
+			v.files_[level] = append(v.files_[level], &FileMetaData{
+				number:    number,
+				file_size: uint64(size),
+				smallest:  NewInternalKey(r[0], 100, kTypeValue),
+				largest:   NewInternalKey(r[1], 100, kTypeValue),
+			})
+			number += 1
+		}
+	}
+	return v
+}
+
+func TestOverlapInLevel(t *testing.T) {
+	v := testVersion(1000, map[int][][3]string{
+		0: {{"150", "600", "1"}, {"400", "500", "1"}},
+		1: {{"150", "200", "1"}, {"200", "250", "1"}, {"300", "350", "1"}, {"400", "450", "1"}},
+	})
+	key := func(s string) *string { return &s }
+	tests := []struct {
+		level             int
+		smallest, largest *string
+		want              bool
+	}{
+		{1, key("100"), key("149"), false},
+		{1, key("251"), key("299"), false},
+		{1, key("451"), key("500"), false},
+		{1, key("351"), key("351"), false},
+		{1, key("100"), key("150"), true},
+		{1, key("100"), key("200"), true},
+		{1, key("100"), key("300"), true},
+		{1, key("100"), key("400"), true},
+		{1, key("100"), key("500"), true},
+		{1, key("375"), key("400"), true},
+		{1, key("450"), key("450"), true},
+		{1, key("450"), key("500"), true},
+		{1, nil, key("149"), false},
+		{1, key("451"), nil, false},
+		{1, nil, nil, true},
+		{1, nil, key("150"), true},
+		{1, key("450"), nil, true},
+		{0, key("100"), key("149"), false},
+		{0, key("601"), key("700"), false},
+		{0, key("100"), key("150"), true},
+		{0, key("450"), key("700"), true},
+		{0, key("450"), key("450"), true},
+		{2, nil, nil, false},
+	}
+	str := func(s *string) string {
+		if s == nil {
+			return "nil"
+		}
+		return *s
+	}
+	for _, tt := range tests {
+		if got := v.OverlapInLevel(tt.level, tt.smallest, tt.largest); got != tt.want {
+			t.Errorf("OverlapInLevel(%d, %s, %s) = %v, want %v", tt.level, str(tt.smallest), str(tt.largest), got, tt.want)
+		}
+	}
+}
+
+func TestGetOverlappingInputs(t *testing.T) {
+	v := testVersion(1000, map[int][][3]string{
+		// The second file expands the range of the first one.
+		0: {{"100", "200", "1"}, {"150", "300", "1"}, {"400", "500", "1"}},
+		1: {{"100", "200", "1"}, {"300", "400", "1"}, {"500", "600", "1"}},
+	})
+	ikey := func(s string) *InternalKey { return NewInternalKey(s, kMaxSequenceNumber, kValueTypeForSeek) }
+	tests := []struct {
+		level      int
+		begin, end *InternalKey
+		want       []uint64
+	}{
+		{1, ikey("250"), ikey("350"), []uint64{5}},
+		{1, ikey("000"), ikey("050"), nil},
+		{1, nil, ikey("300"), []uint64{4, 5}},
+		{1, ikey("350"), nil, []uint64{5, 6}},
+		{1, nil, nil, []uint64{4, 5, 6}},
+		{0, ikey("250"), ikey("260"), []uint64{1, 2}},
+		{0, ikey("450"), ikey("450"), []uint64{3}},
+		{0, ikey("310"), ikey("390"), nil},
+	}
+	for _, tt := range tests {
+		var got []uint64
+		for _, f := range v.GetOverlappingInputs(tt.level, tt.begin, tt.end) {
+			got = append(got, f.number)
+		}
+		sort.Slice(got, func(i, j int) bool { return got[i] < got[j] })
+		if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+			t.Errorf("level %d: GetOverlappingInputs = %v, want %v", tt.level, got, tt.want)
+		}
+	}
+}
+
+func TestPickLevelForMemTableOutput(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[int][][3]string
+		want  int
+	}{
+		{name: "empty", want: kMaxMemCompactLevel},
+		{name: "overlaps level 0", files: map[int][][3]string{0: {{"a", "m", "1"}}}, want: 0},
+		{name: "overlaps level 1", files: map[int][][3]string{1: {{"a", "m", "1"}}}, want: 0},
+		{name: "overlaps level 2", files: map[int][][3]string{2: {{"a", "m", "1"}}}, want: 1},
+		{name: "disjoint files", files: map[int][][3]string{0: {{"x", "z", "1"}}, 1: {{"a", "b", "1"}}}, want: kMaxMemCompactLevel},
+		{name: "too many grandparent bytes", files: map[int][][3]string{2: {{"a", "e", "6000"}, {"f", "m", "6000"}}}, want: 0},
+		{name: "too many bytes in level 3", files: map[int][][3]string{3: {{"a", "m", "20000"}}}, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := testVersion(1000, tt.files)
+			if got := v.PickLevelForMemTableOutput("c", "h"); got != tt.want {
+				t.Errorf("PickLevelForMemTableOutput = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddBoundaryInputs(t *testing.T) {
+	icmp := utils.NewInternalKeyComparator(&utils.BytewiseComparator{})
+	file := func(number uint64, smallest string, smallest_seq SequenceNumber, largest string, largest_seq SequenceNumber) *FileMetaData {
+		return &FileMetaData{
+			number:   number,
+			smallest: NewInternalKey(smallest, smallest_seq, kTypeValue),
+			largest:  NewInternalKey(largest, largest_seq, kTypeValue),
+		}
+	}
+	tests := []struct {
+		name        string
+		level_files func() ([]*FileMetaData, []*FileMetaData)
+		want        []uint64
+	}{
+		{
+			name:        "empty file sets",
+			level_files: func() ([]*FileMetaData, []*FileMetaData) { return nil, nil },
+		},
+		{
+			name: "empty level files",
+			level_files: func() ([]*FileMetaData, []*FileMetaData) {
+				return nil, []*FileMetaData{file(1, "100", 2, "100", 1)}
+			},
+			want: []uint64{1},
+		},
+		{
+			name: "empty compaction files",
+			level_files: func() ([]*FileMetaData, []*FileMetaData) {
+				return []*FileMetaData{file(1, "100", 2, "100", 1)}, nil
+			},
+		},
+		{
+			name: "no boundary files",
+			level_files: func() ([]*FileMetaData, []*FileMetaData) {
+				f1 := file(1, "100", 2, "100", 1)
+				f2 := file(2, "200", 2, "200", 1)
+				f3 := file(3, "300", 2, "300", 1)
+				return []*FileMetaData{f3, f2, f1}, []*FileMetaData{f2, f3}
+			},
+			want: []uint64{2, 3},
+		},
+		{
+			name: "one boundary file",
+			level_files: func() ([]*FileMetaData, []*FileMetaData) {
+				f1 := file(1, "100", 3, "100", 2)
+				f2 := file(2, "100", 1, "200", 3)
+				f3 := file(3, "300", 2, "300", 1)
+				return []*FileMetaData{f3, f2, f1}, []*FileMetaData{f1}
+			},
+			want: []uint64{1, 2},
+		},
+		{
+			name: "two boundary files",
+			level_files: func() ([]*FileMetaData, []*FileMetaData) {
+				f1 := file(1, "100", 6, "100", 5)
+				f2 := file(2, "100", 2, "300", 1)
+				f3 := file(3, "100", 4, "100", 3)
+				return []*FileMetaData{f2, f3, f1}, []*FileMetaData{f1}
+			},
+			want: []uint64{1, 3, 2},
+		},
+		{
+			name: "disjoint file pointers",
+			level_files: func() ([]*FileMetaData, []*FileMetaData) {
+				f1 := file(1, "100", 6, "100", 5)
+				f2 := file(2, "100", 6, "100", 5)
+				f3 := file(3, "100", 2, "300", 1)
+				f4 := file(4, "100", 4, "100", 3)
+				return []*FileMetaData{f2, f3, f4}, []*FileMetaData{f1}
+			},
+			want: []uint64{1, 4, 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			level_files, compaction_files := tt.level_files()
+			AddBoundaryInputs(icmp, level_files, &compaction_files)
+			var got []uint64
+			for _, f := range compaction_files {
+				got = append(got, f.number)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("compaction files = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	base := testVersion(1000, map[int][][3]string{
+		0: {{"100", "200", "1"}},
+		1: {{"100", "200", "1"}, {"300", "400", "1"}, {"500", "600", "1"}},
+	})
+	vs := base.vset_
+	ikey := func(s string) *InternalKey { return NewInternalKey(s, 100, kTypeValue) }
+	levelFiles := func(v *Version) string {
+		var s []string
+		for level := 0; level < levelNum; level++ {
+			for _, f := range v.files_[level] {
+				s = append(s, fmt.Sprintf("%d:%d", level, f.number))
+			}
+		}
+		return fmt.Sprint(s)
+	}
+
+	edit := NewVersionEdit()
+	edit.DeleteFile(1, 3)
+	edit.AddFile(1, 10, 1, ikey("700"), ikey("800"))
+	edit.AddFile(1, 11, 10*16384*16384, ikey("250"), ikey("280"))
+	edit.AddFile(0, 12, 1, ikey("150"), ikey("160"))
+	edit.AddFile(2, 13, 1, ikey("100"), ikey("900"))
+	edit.SetComparatorPointer(1, ikey("400"))
+	// A later edit may delete a file added by an earlier one.
+	later := NewVersionEdit()
+	later.DeleteFile(2, 13)
+
+	b := NewBuilder(vs, base)
+	b.Apply(edit)
+	b.Apply(later)
+	v := NewVersion(vs)
+	if err := b.SaveTo(v); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := levelFiles(v), "[0:1 0:12 1:2 1:11 1:4 1:10]"; got != want {
+		t.Errorf("files = %s, want %s", got, want)
+	}
+	if got := levelFiles(base); got != "[0:1 1:2 1:3 1:4]" {
+		t.Errorf("base files changed to %s", got)
+	}
+	for _, f := range v.files_[1] {
+		want := 0
+		switch f.number {
+		case 10:
+			want = 100
+		case 11:
+			want = 10 * 16384
+		}
+		if f.allowed_seeks != want {
+			t.Errorf("file %d: allowed_seeks = %d, want %d", f.number, f.allowed_seeks, want)
+		}
+	}
+	if got := vs.compact_pointer_[1]; got != ikey("400").Encode() {
+		t.Errorf("compact_pointer_[1] = %q, want %q", got, ikey("400").Encode())
+	}
+}
+
+func TestBuilderOverlap(t *testing.T) {
+	ikey := func(s string) *InternalKey { return NewInternalKey(s, 100, kTypeValue) }
+	tests := []struct {
+		name    string
+		edit    func(edit *VersionEdit)
+		wantErr bool
+	}{
+		{
+			name: "level 0 may overlap",
+			edit: func(edit *VersionEdit) {
+				edit.AddFile(0, 10, 1, ikey("150"), ikey("350"))
+			},
+		},
+		{
+			name: "level 1 must not overlap",
+			edit: func(edit *VersionEdit) {
+				edit.AddFile(1, 10, 1, ikey("150"), ikey("350"))
+			},
+			wantErr: true,
+		},
+		{
+			name: "level 1 must not overlap at a shared boundary key",
+			edit: func(edit *VersionEdit) {
+				edit.AddFile(1, 10, 1, ikey("200"), ikey("250"))
+			},
+			wantErr: true,
+		},
+		{
+			name: "replacing the overlapped files",
+			edit: func(edit *VersionEdit) {
+				edit.DeleteFile(1, 2)
+				edit.DeleteFile(1, 3)
+				edit.AddFile(1, 10, 1, ikey("150"), ikey("350"))
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			base := testVersion(1000, map[int][][3]string{
+				0: {{"100", "200", "1"}},
+				1: {{"100", "200", "1"}, {"300", "400", "1"}},
+			})
+			edit := NewVersionEdit()
+			tt.edit(edit)
+			b := NewBuilder(base.vset_, base)
+			b.Apply(edit)
+			err := b.SaveTo(NewVersion(base.vset_))
+			if tt.wantErr && !utils.IsCorruption(err) {
+				t.Errorf("SaveTo = %v, want corruption", err)
+			} else if !tt.wantErr && err != nil {
+				t.Errorf("SaveTo = %v", err)
+			}
+		})
+	}
+}
+
+func TestVersionSetOverlapCorruption(t *testing.T) {
+	const dbname = "/db"
+	ikey := func(s string) *InternalKey { return NewInternalKey(s, 100, kTypeValue) }
+	mem_env := env.NewMemEnv(env.Default())
+	opt := &Options{Comparator: &utils.BytewiseComparator{}, Env: mem_env}
+	writeManifest := func(edits ...*VersionEdit) {
+		t.Helper()
+		file, err := mem_env.NewWritableFile(DescriptorFileName(dbname, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		writer := NewLogWriter(file)
+		for _, edit := range edits {
+			if err := writer.AddRecord(edit.Encode()); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := file.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := SetCurrentFile(mem_env, dbname, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	first := NewVersionEdit()
+	first.SetComparatorName(opt.Comparator.Name())
+	first.SetLogNumber(0)
+	first.SetNextFile(3)
+	first.SetLastSequence(100)
+	first.AddFile(1, 2, 1, ikey("100"), ikey("200"))
+	overlap := NewVersionEdit()
+	overlap.AddFile(1, 3, 1, ikey("150"), ikey("250"))
+
+	// A MANIFEST whose edits overlap in level 1 does not recover.
+	writeManifest(first, overlap)
+	vs := NewVersionSet(dbname, opt, nil)
+	if _, err := vs.Recover(false); !utils.IsCorruption(err) {
+		t.Errorf("Recover = %v, want corruption", err)
+	}
+
+	// LogAndApply rejects the edit and keeps the current version.
+	writeManifest(first)
+	vs = NewVersionSet(dbname, opt, nil)
+	if _, err := vs.Recover(false); err != nil {
+		t.Fatal(err)
+	}
+	current := vs.current_
+	var mu sync.Mutex
+	mu.Lock()
+	if err := vs.LogAndApply(overlap, &mu); !utils.IsCorruption(err) {
+		t.Errorf("LogAndApply = %v, want corruption", err)
+	}
+	mu.Unlock()
+	if vs.current_ != current || len(current.files_[1]) != 1 || current.files_[1][0].refs != 1 {
+		t.Errorf("current version changed by a rejected edit")
+	}
+}
@@ -2,17 +2,191 @@ package leveldb
 
 import "github.com/lemonwx/goleveldb/leveldb/utils"
 
-const (
-	kTypeDeletion = 0x0
-	kTypeValue    = 0x1
-)
+// WriteBatch::rep_ :=
+//    sequence: fixed64
+//    count: fixed32
+//    data: record[count]
+// record :=
+//    kTypeValue varstring varstring         |
+//    kTypeDeletion varstring
+// varstring :=
+//    len: varint32
+//    data: uint8[len]
 
+// WriteBatch header has an 8-byte sequence number followed by a 4-byte count.
+const kWriteBatchHeader = 12
+
+// Handler receives the records of a WriteBatch in order, see Iterate.
+type Handler interface {
+	Put(key, value []byte)
+	Delete(key []byte)
+}
+
+// WriteBatch holds a collection of updates to apply atomically to a DB.
 type WriteBatch struct {
 	rep []byte
 }
 
+func NewWriteBatch() *WriteBatch {
+	wb := &WriteBatch{}
+	wb.Clear()
+	return wb
+}
+
+// Clear all updates buffered in this batch.
+func (wb *WriteBatch) Clear() {
+	wb.rep = make([]byte, kWriteBatchHeader)
+}
+
+func (wb *WriteBatch) init() {
+	if len(wb.rep) < kWriteBatchHeader {
+		wb.Clear()
+	}
+}
+
+// Put stores the mapping "key->value" in the database.
 func (wb *WriteBatch) Put(k, v []byte) {
-	wb.rep = append(wb.rep, kTypeValue)
+	wb.init()
+	wb.SetCount(wb.Count() + 1)
+	wb.rep = append(wb.rep, byte(kTypeValue))
 	utils.PutLengthPrefixedSlice(&wb.rep, string(k))
 	utils.PutLengthPrefixedSlice(&wb.rep, string(v))
 }
+
+// Delete erases the mapping for "key" if the database contains it.
+func (wb *WriteBatch) Delete(k []byte) {
+	wb.init()
+	wb.SetCount(wb.Count() + 1)
+	wb.rep = append(wb.rep, byte(kTypeDeletion))
+	utils.PutLengthPrefixedSlice(&wb.rep, string(k))
+}
+
+// Append copies the operations in "source" to this batch.
+//
+// This runs in O(source size) time. However, the constant factor is better
+// than calling Iterate() over the source batch with a Handler that replicates
+// the operations into this batch.
+func (wb *WriteBatch) Append(source *WriteBatch) {
+	wb.init()
+	source.init()
+	wb.SetCount(wb.Count() + source.Count())
+	wb.rep = append(wb.rep, source.rep[kWriteBatchHeader:]...)
+}
+
+// ApproximateSize returns the size of the database changes caused by this batch.
+//
+// This number is tied to implementation details, and may change across
+// releases. It is intended for LevelDB usage metrics.
+func (wb *WriteBatch) ApproximateSize() int {
+	wb.init()
+	return len(wb.rep)
+}
+
+// Count returns the number of entries in the batch.
+func (wb *WriteBatch) Count() int {
+	wb.init()
+	return int(utils.DecodeFixed32(wb.rep[8:]))
+}
+
+// SetCount sets the count for the number of entries in the batch.
+func (wb *WriteBatch) SetCount(n int) {
+	wb.init()
+	utils.EncodeFixed32(wb.rep[8:], uint32(n))
+}
+
+// Sequence returns the sequence number for the start of this batch.
+func (wb *WriteBatch) Sequence() SequenceNumber {
+	wb.init()
+	return SequenceNumber(utils.DecodeFixed64(wb.rep))
+}
+
+// SetSequence stores the specified number as the sequence number for the start of
+// this batch.
+func (wb *WriteBatch) SetSequence(seq SequenceNumber) {
+	wb.init()
+	utils.EncodeFixed64(wb.rep, uint64(seq))
+}
+
+// Contents returns the encoded batch, it is what gets written to the log.
+func (wb *WriteBatch) Contents() []byte {
+	wb.init()
+	return wb.rep
+}
+
+// SetContents replaces the batch with an encoded batch, e.g. a record
+// read back from the log.
+func (wb *WriteBatch) SetContents(contents []byte) error {
+	if len(contents) < kWriteBatchHeader {
+		return utils.NewCorruption("malformed WriteBatch (too small)")
+	}
+	wb.rep = make([]byte, len(contents))
+	copy(wb.rep, contents)
+	return nil
+}
+
+// Iterate replays the records of the batch into handler, it returns a
+// corruption error if the batch is malformed.
+func (wb *WriteBatch) Iterate(handler Handler) error {
+	input := wb.rep
+	if len(input) < kWriteBatchHeader {
+		return utils.NewCorruption("malformed WriteBatch (too small)")
+	}
+
+	input = input[kWriteBatchHeader:]
+	found := 0
+	for len(input) != 0 {
+		found += 1
+		tag := ValueType(input[0])
+		input = input[1:]
+		switch tag {
+		case kTypeValue:
+			key, l, err := utils.GetLengthPrefixedString(input)
+			if err != nil {
+				return utils.NewCorruption("bad WriteBatch Put")
+			}
+			input = input[l:]
+			value, l, err := utils.GetLengthPrefixedString(input)
+			if err != nil {
+				return utils.NewCorruption("bad WriteBatch Put")
+			}
+			input = input[l:]
+			handler.Put(key, value)
+		case kTypeDeletion:
+			key, l, err := utils.GetLengthPrefixedString(input)
+			if err != nil {
+				return utils.NewCorruption("bad WriteBatch Delete")
+			}
+			input = input[l:]
+			handler.Delete(key)
+		default:
+			return utils.NewCorruption("unknown WriteBatch tag")
+		}
+	}
+	if found != wb.Count() {
+		return utils.NewCorruption("WriteBatch has wrong count")
+	}
+	return nil
+}
+
+// MemTableInserter is the Handler that applies a batch to a memtable,
+// assigning consecutive sequence numbers to its records.
+type MemTableInserter struct {
+	sequence_ SequenceNumber
+	mem_      *MemTable
+}
+
+func (mi *MemTableInserter) Put(key, value []byte) {
+	mi.mem_.Add(mi.sequence_, kTypeValue, key, value)
+	mi.sequence_ += 1
+}
+
+func (mi *MemTableInserter) Delete(key []byte) {
+	mi.mem_.Add(mi.sequence_, kTypeDeletion, key, nil)
+	mi.sequence_ += 1
+}
+
+// InsertInto inserts the batch into memtable.
+func (wb *WriteBatch) InsertInto(memtable *MemTable) error {
+	inserter := &MemTableInserter{sequence_: wb.Sequence(), mem_: memtable}
+	return wb.Iterate(inserter)
+}
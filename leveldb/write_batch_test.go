@@ -0,0 +1,194 @@
+package leveldb
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/lemonwx/goleveldb/leveldb/utils"
+)
+
+// printContents inserts b into a fresh memtable and prints its
+// entries in internal key order, followed by "ParseError()" or
+// "CountMismatch()" if the batch is malformed.
+func printContents(b *WriteBatch) string {
+	mem := NewMemTable(utils.NewInternalKeyComparator(&utils.BytewiseComparator{}))
+	mem.Ref()
+	defer mem.Unref()
+	err := b.InsertInto(mem)
+	var state strings.Builder
+	count := 0
+	iter := NewSkipListIterator(mem.table_)
+	for iter.SeekToFirst(); iter.Valid(); iter.Next() {
+		internal_key, n := decodeLengthPrefixedSlice(iter.Key())
+		ikey, ok := ParseInternalKey(internal_key)
+		if !ok {
+			panic("bad internal key in memtable")
+		}
+		switch ikey.Type {
+		case kTypeValue:
+			fmt.Fprintf(&state, "Put(%s, %s)", ikey.user_key, GetLengthPrefixedSlice(iter.Key()[n:]))
+		case kTypeDeletion:
+			fmt.Fprintf(&state, "Delete(%s)", ikey.user_key)
+		}
+		fmt.Fprintf(&state, "@%d", ikey.sequence)
+		count += 1
+	}
+	if err != nil {
+		state.WriteString("ParseError()")
+	} else if count != b.Count() {
+		state.WriteString("CountMismatch()")
+	}
+	return state.String()
+}
+
+func TestWriteBatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func() *WriteBatch
+		count int
+		want  string
+	}{
+		{
+			name:  "empty",
+			build: NewWriteBatch,
+			count: 0,
+			want:  "",
+		},
+		{
+			name: "multiple",
+			build: func() *WriteBatch {
+				b := NewWriteBatch()
+				b.Put([]byte("foo"), []byte("bar"))
+				b.Delete([]byte("box"))
+				b.Put([]byte("baz"), []byte("boo"))
+				b.SetSequence(100)
+				return b
+			},
+			count: 3,
+			want:  "Put(baz, boo)@102Delete(box)@101Put(foo, bar)@100",
+		},
+		{
+			name: "corruption",
+			build: func() *WriteBatch {
+				b := NewWriteBatch()
+				b.Put([]byte("foo"), []byte("bar"))
+				b.Delete([]byte("box"))
+				b.SetSequence(200)
+				contents := b.Contents()
+				b.SetContents(contents[:len(contents)-1])
+				return b
+			},
+			count: 2,
+			want:  "Put(foo, bar)@200ParseError()",
+		},
+		{
+			name: "wrong count",
+			build: func() *WriteBatch {
+				b := NewWriteBatch()
+				b.Put([]byte("foo"), []byte("bar"))
+				b.SetCount(2)
+				return b
+			},
+			count: 2,
+			want:  "Put(foo, bar)@0ParseError()",
+		},
+		{
+			name: "unknown tag",
+			build: func() *WriteBatch {
+				b := NewWriteBatch()
+				b.Put([]byte("foo"), []byte("bar"))
+				b.SetContents(append(append([]byte{}, b.Contents()...), 0x7f))
+				return b
+			},
+			count: 1,
+			want:  "Put(foo, bar)@0ParseError()",
+		},
+		{
+			name: "zero value",
+			build: func() *WriteBatch {
+				b := &WriteBatch{}
+				b.Put([]byte("a"), []byte("va"))
+				return b
+			},
+			count: 1,
+			want:  "Put(a, va)@0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := tt.build()
+			if b.Count() != tt.count {
+				t.Errorf("Count() = %d, want %d", b.Count(), tt.count)
+			}
+			if got := printContents(b); got != tt.want {
+				t.Errorf("contents = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteBatchAppend(t *testing.T) {
+	b1 := NewWriteBatch()
+	b2 := NewWriteBatch()
+	b1.SetSequence(200)
+	b2.SetSequence(300)
+	steps := []struct {
+		name   string
+		modify func()
+		want   string
+	}{
+		{"empty", func() {}, ""},
+		{"put", func() { b2.Put([]byte("a"), []byte("va")) }, "Put(a, va)@200"},
+		{"clear and put", func() {
+			b2.Clear()
+			b2.Put([]byte("b"), []byte("vb"))
+		}, "Put(a, va)@200Put(b, vb)@201"},
+		{"delete", func() {
+			b2.Delete([]byte("foo"))
+		}, "Put(a, va)@200Put(b, vb)@202Put(b, vb)@201Delete(foo)@203"},
+	}
+	for _, step := range steps {
+		step.modify()
+		b1.Append(b2)
+		if got := printContents(b1); got != step.want {
+			t.Errorf("%s: contents = %q, want %q", step.name, got, step.want)
+		}
+	}
+	if b1.Count() != 4 {
+		t.Errorf("Count() = %d, want 4", b1.Count())
+	}
+}
+
+func TestWriteBatchSetContentsTooSmall(t *testing.T) {
+	b := NewWriteBatch()
+	if err := b.SetContents(make([]byte, kWriteBatchHeader-1)); !utils.IsCorruption(err) {
+		t.Errorf("SetContents(short) = %v, want corruption", err)
+	}
+	if err := (&WriteBatch{}).Iterate(&MemTableInserter{}); !utils.IsCorruption(err) {
+		t.Errorf("Iterate(empty rep) = %v, want corruption", err)
+	}
+}
+
+func TestWriteBatchApproximateSize(t *testing.T) {
+	b := NewWriteBatch()
+	empty_size := b.ApproximateSize()
+
+	b.Put([]byte("foo"), []byte("bar"))
+	one_key_size := b.ApproximateSize()
+	if empty_size >= one_key_size {
+		t.Errorf("size after Put = %d, want > %d", one_key_size, empty_size)
+	}
+
+	b.Put([]byte("baz"), []byte("boo"))
+	two_keys_size := b.ApproximateSize()
+	if one_key_size >= two_keys_size {
+		t.Errorf("size after second Put = %d, want > %d", two_keys_size, one_key_size)
+	}
+
+	b.Delete([]byte("box"))
+	post_delete_size := b.ApproximateSize()
+	if two_keys_size >= post_delete_size {
+		t.Errorf("size after Delete = %d, want > %d", post_delete_size, two_keys_size)
+	}
+}